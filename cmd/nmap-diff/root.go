@@ -12,19 +12,77 @@ func NewRootCmd() *cobra.Command {
 	baseConfig := config.BaseConfig{}
 	gcloudConfig := config.GCloudConfig{}
 	slackConfig := config.SlackConfig{}
+	storageConfig := config.StorageConfig{}
+	historyConfig := config.HistoryConfig{}
+	awsConfig := config.AWSConfig{}
+	azureConfig := config.AzureConfig{}
+	staticConfig := config.StaticConfig{}
+	kubernetesConfig := config.KubernetesConfig{}
+	terraformConfig := config.TerraformConfig{}
+	dnsConfig := config.DNSConfig{}
 
 	baseConfig.GCloudConfig = &gcloudConfig
+	baseConfig.AzureConfig = &azureConfig
+	baseConfig.StaticConfig = &staticConfig
+	baseConfig.KubernetesConfig = &kubernetesConfig
+	baseConfig.TerraformConfig = &terraformConfig
+	baseConfig.DNSConfig = &dnsConfig
 	baseConfig.SlackConfig = &slackConfig
+	baseConfig.AWSConfig = &awsConfig
 
 	logConfig := logConfig{}
+	var scanProfilesPath string
+	var awsAccountsPath string
+	var webhookURLs []string
+	var logNotifier bool
+	var keepHistory bool
 
 	cmd := &cobra.Command{
 		Use:   "nmap-diff",
 		Short: "compares nmap results of cloud providers with previous runs",
-		Long: `nmap-diff fetches the xml output of a previous scan from S3, runs a scan from the list of public instances,
-		outputs the diff to slack, and then stores the current scan results to S3`,
+		Long: `nmap-diff fetches the xml output of a previous scan from storage, runs a scan from the list of public instances,
+		outputs the diff to slack, and then stores the current scan results`,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return setupLogging(&logConfig)
+			err := setupLogging(&logConfig)
+			if err != nil {
+				return err
+			}
+
+			for _, webhookURL := range webhookURLs {
+				baseConfig.Notifiers = append(baseConfig.Notifiers, &config.NotifierConfig{
+					Type:          config.WebhookNotifier,
+					WebhookConfig: &config.WebhookConfig{URL: webhookURL},
+				})
+			}
+
+			if logNotifier {
+				baseConfig.Notifiers = append(baseConfig.Notifiers, &config.NotifierConfig{Type: config.LogNotifier})
+			}
+
+			if storageConfig.Bucket == "" {
+				storageConfig.Bucket = baseConfig.BucketName
+			}
+			storageConfig.ServiceAccountPath = gcloudConfig.ServiceAccountPath
+			baseConfig.StorageConfig = &storageConfig
+
+			if keepHistory || historyConfig.CompareTo != "" {
+				baseConfig.HistoryConfig = &historyConfig
+			}
+
+			if awsAccountsPath != "" {
+				awsConfig.Accounts, err = config.LoadAWSAccounts(awsAccountsPath)
+				if err != nil {
+					return fmt.Errorf("PreRunE: Error loading AWS accounts %s", err)
+				}
+			}
+
+			if scanProfilesPath != "" {
+				baseConfig.ScanProfiles, err = config.LoadScanProfiles(scanProfilesPath)
+				if err != nil {
+					return fmt.Errorf("PreRunE: Error loading scan profiles %s", err)
+				}
+			}
+			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			log.Debug("Setting up runner")
@@ -42,14 +100,60 @@ func NewRootCmd() *cobra.Command {
 	f.StringVarP(&logConfig.LogType, "log-type", "", "", "Log type (text,json)")
 
 	f.BoolVarP(&baseConfig.IncludeAWS, "include-aws", "a", false, "Include AWS Instances In Report")
+	f.StringSliceVarP(&awsConfig.Resources, "aws-resources", "", []string{}, "AWS resources to inventory (instances,load-balancers,elastic-ips,nat-gateways). Default is all of them")
+	f.IntVarP(&baseConfig.InventoryWorkers, "inventory-workers", "", config.DefaultInventoryWorkers, "Number of AWS regions, GCloud projects, Azure subscriptions or Kubernetes clusters to inventory at the same time")
+	f.StringVarP(&baseConfig.InventoryErrorPolicy, "inventory-error-policy", "", config.InventoryFailFast, "What to do when a region or zone cannot be inventoried (fail-fast,best-effort)")
+	f.StringSliceVarP(&awsConfig.Regions, "aws-regions", "", []string{}, "AWS regions to inventory, such as us-east-1 or us-*. Default is every region enabled in the account")
+	f.StringSliceVarP(&awsConfig.ExcludeRegions, "aws-exclude-regions", "", []string{}, "AWS regions to skip, such as ap-east-1 or ap-*")
+	f.StringVarP(&awsAccountsPath, "aws-accounts-file", "", "", "Path of a JSON file listing the roles to assume in every AWS account to scan")
 	f.StringVarP(&baseConfig.BucketName, "s3-bucket", "s", "", "Name of S3 bucket to store reports in")
+	f.StringVarP(&storageConfig.Type, "storage-type", "", config.S3Storage, "Where to store reports (s3,local,gcs)")
+	f.StringVarP(&storageConfig.Bucket, "storage-bucket", "", "", "Name of the S3 or GCS bucket to store reports in. Defaults to --s3-bucket")
+	f.StringVarP(&storageConfig.Directory, "storage-dir", "", "", "Directory to store reports in when using local storage")
+
+	f.BoolVarP(&keepHistory, "history", "", false, "Keep every report in storage along with an index of the reports")
+	f.StringVarP(&historyConfig.Prefix, "history-prefix", "", "", "Storage path to keep the report history under. Default is history")
+	f.IntVarP(&historyConfig.KeepScans, "history-keep-scans", "", 0, "Number of reports to keep in history. 0 keeps every report")
+	f.IntVarP(&historyConfig.KeepDays, "history-keep-days", "", 0, "Number of days to keep reports in history for. 0 keeps every report")
+	f.StringVarP(&historyConfig.CompareTo, "compare-to", "", "", "Diff against the report with this history ID, or the latest report at or before this RFC 3339 time")
 	f.StringVarP(&baseConfig.PreviousFileName, "report-path", "f", "", "Path of report in service account")
 
 	f.BoolVarP(&baseConfig.IncludeGCloud, "include-gcloud", "g", false, "Include Google Cloud Instances In Report")
 	f.StringVarP(&baseConfig.GCloudConfig.ServiceAccountPath, "gcloud-service-account-path", "", "", "Path of service account token. Uses default if not specified")
 	f.StringVarP(&baseConfig.GCloudConfig.ProjectName, "gcloud-project", "p", "", "GCloud project to list instances from")
+	f.StringSliceVarP(&baseConfig.GCloudConfig.Projects, "gcloud-projects", "", []string{}, "Additional GCloud projects to list instances from")
+	f.StringVarP(&baseConfig.GCloudConfig.Parent, "gcloud-parent", "", "", "GCloud folder or organization (folders/ID or organizations/ID) whose projects are all listed")
+	f.StringSliceVarP(&baseConfig.GCloudConfig.Resources, "gcloud-resources", "", []string{}, "GCloud resources to inventory (instances,forwarding-rules,addresses). Default is all of them")
+
+	f.StringSliceVarP(&terraformConfig.StateFiles, "terraform-state", "", []string{}, "Path, s3://bucket/key or gs://bucket/key URL of a Terraform state file whose public addresses are scanned. Can be repeated")
+
+	f.BoolVarP(&baseConfig.IncludeAzure, "include-azure", "", false, "Include Azure public IP addresses In Report")
+	f.StringVarP(&azureConfig.TenantID, "azure-tenant-id", "", "", "Azure tenant of the service principal. Defaults to AZURE_TENANT_ID")
+	f.StringVarP(&azureConfig.ClientID, "azure-client-id", "", "", "Azure service principal client ID. Defaults to AZURE_CLIENT_ID. The secret is read from AZURE_CLIENT_SECRET")
+	f.StringSliceVarP(&azureConfig.Subscriptions, "azure-subscriptions", "", []string{}, "Azure subscriptions to inventory. Default is every subscription the service principal can read")
+
+	f.BoolVarP(&baseConfig.IncludeKubernetes, "include-kubernetes", "", false, "Include Kubernetes load balancers, ingresses and node ports In Report")
+	f.StringVarP(&kubernetesConfig.Kubeconfig, "kubeconfig", "", "", "Path of the kubeconfig file. Found the same way as kubectl when not specified")
+	f.StringSliceVarP(&kubernetesConfig.Contexts, "kube-contexts", "", []string{}, "Kubeconfig contexts of the clusters to inventory. Default is the current context")
+
+	f.StringSliceVarP(&staticConfig.Files, "targets-file", "", []string{}, "Path of a YAML, JSON or CSV file of IP addresses, CIDR ranges and hostnames to scan. Can be repeated")
+
+	f.StringSliceVarP(&dnsConfig.ZoneFiles, "dns-zone-file", "", []string{}, "Path of a BIND zone file whose A, AAAA and CNAME records are scanned. Can be repeated")
+	f.StringSliceVarP(&dnsConfig.Route53Zones, "route53-zones", "", []string{}, "IDs of the Route53 hosted zones whose A, AAAA, CNAME and alias records are scanned")
+	f.StringSliceVarP(&dnsConfig.CloudDNSZones, "cloud-dns-zones", "", []string{}, "Cloud DNS managed zones (project/zone) whose A, AAAA and CNAME records are scanned")
 
 	f.StringVarP(&baseConfig.SlackConfig.SlackURL, "slack-url", "u", "", "Slack URL to post messages to")
+	f.BoolVarP(&baseConfig.SlackConfig.Digest, "slack-digest", "", false, "Post a single digest message per run instead of one message per change")
+	f.StringVarP(&baseConfig.SlackConfig.GroupBy, "slack-group-by", "", "", "Group digest changes by change, provider or tag:<key>")
+	f.StringVarP(&baseConfig.SlackConfig.Token, "slack-token", "", "", "Slack bot token used to post digests and threaded follow ups through the Web API")
+	f.StringVarP(&baseConfig.SlackConfig.Channel, "slack-channel", "", "", "Slack channel to post digests to when using --slack-token")
+	f.StringSliceVarP(&webhookURLs, "webhook-url", "", []string{}, "URL to post the JSON report of each run to. Can be given more than once")
+	f.BoolVarP(&logNotifier, "log-notifier", "", false, "Write every change to the log")
+
+	f.StringVarP(&baseConfig.ScanProfile, "scan-profile", "", config.DefaultScanProfileName, "Name of the scan profile to run (default,quick,full,udp,version or one from --scan-profiles-file)")
+	f.StringVarP(&scanProfilesPath, "scan-profiles-file", "", "", "Path of a JSON file containing named scan profiles")
+	f.StringSliceVarP(&baseConfig.NotifyTransitions, "notify-transitions", "", []string{}, "Port state transitions to post to slack on top of opened and closed ports, e.g. closed->filtered,*->open|filtered")
+	f.StringVarP(&baseConfig.BaselineNotification, "baseline-notification", "", config.BaselineNotifyNone, "What to notify when no previous report exists and a baseline is established (none,summary)")
 	return cmd
 }
 
@@ -0,0 +1,161 @@
+package aws
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	log "github.com/sirupsen/logrus"
+)
+
+// The tags every AWS resource is recorded with, so notifications tell which account changed.
+const (
+	AccountIDTag    = "accountId"
+	AccountAliasTag = "accountAlias"
+)
+
+// The opt-in statuses of regions that can be inventoried. Regions that are "not-opted-in" are disabled in the account.
+const (
+	optInNotRequired = "opt-in-not-required"
+	optedIn          = "opted-in"
+)
+
+// account is an inventoried AWS account. credentials assume roleARN, or are nil to use the default credentials.
+// includeRegions and excludeRegions hold the region names or patterns to inventory and to skip.
+type account struct {
+	roleARN        string
+	includeRegions []string
+	excludeRegions []string
+	credentials    *credentials.Credentials
+	// regions holds the regions that are inventoried. id, alias and partition are looked up from AWS.
+	regions   []string
+	id        string
+	alias     string
+	partition string
+	// err holds why the account could not be set up, in which case it is not inventoried.
+	err error
+}
+
+// name returns the role of the account, which identifies it before its ID is looked up.
+func (acct *account) name() string {
+	if acct.roleARN == "" {
+		return "default credentials"
+	}
+	return acct.roleARN
+}
+
+// getIdentity looks up the account ID and partition, which are needed to build ARNs.
+func (acct *account) getIdentity(stsSvc stsiface.STSAPI, region string) error {
+	if stsSvc == nil {
+		return fmt.Errorf("getIdentity: stsSvc is nil")
+	}
+
+	identity, err := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("getIdentity: Error getting caller identity of %s %s", acct.roleARN, err)
+	}
+	acct.id = aws.StringValue(identity.Account)
+
+	acct.partition = endpoints.AwsPartitionID
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		acct.partition = partition.ID()
+	}
+	return nil
+}
+
+// getAlias looks up the alias of the account. The alias is only informative, so the account is inventoried without
+// one when it cannot be listed.
+func (acct *account) getAlias(iamSvc iamiface.IAMAPI) {
+	if iamSvc == nil {
+		return
+	}
+
+	aliases, err := iamSvc.ListAccountAliases(&iam.ListAccountAliasesInput{})
+	if err != nil {
+		log.WithField("error", err).Warn("getAlias: Unable to list the aliases of account " + acct.id)
+		return
+	}
+	if len(aliases.AccountAliases) > 0 {
+		acct.alias = aws.StringValue(aliases.AccountAliases[0])
+	}
+}
+
+// getRegions looks up the regions enabled in the account, limited to includeRegions when it is set and minus
+// excludeRegions. ec2Svc must use the credentials of the account itself, since the regions that are opted in differ
+// between accounts.
+func (acct *account) getRegions(ec2Svc ec2iface.EC2API) error {
+	if ec2Svc == nil {
+		return fmt.Errorf("getRegions: ec2svc is not yet initialized")
+	}
+
+	resultRegions, err := ec2Svc.DescribeRegions(&ec2.DescribeRegionsInput{AllRegions: aws.Bool(true)})
+	if err != nil {
+		return fmt.Errorf("getRegions: Error Describing regions %s", err)
+	}
+
+	acct.regions = nil
+	for _, region := range resultRegions.Regions {
+		name := aws.StringValue(region.RegionName)
+		optInStatus := aws.StringValue(region.OptInStatus)
+		switch {
+		case optInStatus != "" && optInStatus != optInNotRequired && optInStatus != optedIn:
+			log.Debug("Skipping region ", name, " of account ", acct.id, " which is ", optInStatus)
+		case len(acct.includeRegions) > 0 && !matchRegion(acct.includeRegions, name):
+			log.Debug("Skipping region ", name, " of account ", acct.id, " which is not included")
+		case matchRegion(acct.excludeRegions, name):
+			log.Debug("Skipping region ", name, " of account ", acct.id, " which is excluded")
+		default:
+			acct.regions = append(acct.regions, name)
+		}
+	}
+	return nil
+}
+
+// validateRegions checks that every region pattern is well formed.
+func validateRegions(patternLists ...[]string) error {
+	for _, patterns := range patternLists {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("validateRegions: invalid region pattern %s", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchRegion returns whether the region matches one of the names or patterns.
+func matchRegion(patterns []string, region string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, region); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// arn returns the ARN of a resource of the account.
+func (acct *account) arn(service string, region string, resource string) string {
+	return "arn:" + acct.partition + ":" + service + ":" + region + ":" + acct.id + ":" + resource
+}
+
+// tag records the account on the server, and adds the account to its scopes so the server is related to the account
+// when it cannot be set up.
+func (acct *account) tag(s *server.Server) {
+	if s.Tags == nil {
+		s.Tags = make(map[string]string)
+	}
+	s.Tags[AccountIDTag] = acct.id
+	if acct.alias != "" {
+		s.Tags[AccountAliasTag] = acct.alias
+	}
+	s.Scopes = append(s.Scopes, acct.name())
+}
@@ -0,0 +1,149 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/mocks"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetIdentity(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	mockSTS := &mocks.MockSTSAPI{}
+	acct := account{}
+
+	testCases := []awsTestCase{
+		{
+			desc: "successful identity retrieval",
+			setup: func() {
+				mockSTS.Reset()
+				mockSTS.On("GetCallerIdentity", mock.AnythingOfType("*sts.GetCallerIdentityInput")).Return(
+					&sts.GetCallerIdentityOutput{Account: aws.String("123456789012")}, nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "error returned by identity retrieval",
+			setup: func() {
+				mockSTS.Reset()
+				mockSTS.On("GetCallerIdentity", mock.AnythingOfType("*sts.GetCallerIdentityInput")).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		err := acct.getIdentity(mockSTS, "us-gov-west-1")
+
+		mockSTS.AssertExpectations(t)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, "123456789012", acct.id)
+	assert.Equal(t, "aws-us-gov", acct.partition)
+	assert.Equal(t, "arn:aws-us-gov:ec2:us-gov-west-1:123456789012:natgateway/nat-1", acct.arn("ec2", "us-gov-west-1", "natgateway/nat-1"))
+}
+
+func TestGetAlias(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	mockIAM := &mocks.MockIAMAPI{}
+
+	t.Logf("TestGetAlias: the first alias is recorded")
+	mockIAM.On("ListAccountAliases", mock.AnythingOfType("*iam.ListAccountAliasesInput")).Return(
+		&iam.ListAccountAliasesOutput{AccountAliases: []*string{aws.String("production")}}, nil)
+	acct := account{id: "123456789012"}
+	acct.getAlias(mockIAM)
+	assert.Equal(t, "production", acct.alias)
+
+	t.Logf("TestGetAlias: an account whose aliases cannot be listed has no alias")
+	mockIAM.Reset()
+	mockIAM.On("ListAccountAliases", mock.AnythingOfType("*iam.ListAccountAliasesInput")).Return(nil, fmt.Errorf("AccessDenied"))
+	acct = account{id: "123456789012"}
+	acct.getAlias(mockIAM)
+	assert.Equal(t, "", acct.alias)
+
+	t.Logf("TestGetAlias: the account is recorded on the server")
+	s := server.Server{Name: "Instance 1"}
+	acct.tag(&s)
+	assert.Equal(t, map[string]string{AccountIDTag: "123456789012"}, s.Tags)
+	assert.Equal(t, []string{"default credentials"}, s.Scopes)
+}
+
+func TestGetRegionsFilters(t *testing.T) {
+	mockEc2 := &mocks.MockEC2API{}
+	mockEc2.On("DescribeRegions", &ec2.DescribeRegionsInput{AllRegions: aws.Bool(true)}).Return(&ec2.DescribeRegionsOutput{
+		Regions: []*ec2.Region{
+			{RegionName: aws.String("us-east-1"), OptInStatus: aws.String("opt-in-not-required")},
+			{RegionName: aws.String("us-west-2"), OptInStatus: aws.String("opt-in-not-required")},
+			{RegionName: aws.String("eu-west-1"), OptInStatus: aws.String("opt-in-not-required")},
+			{RegionName: aws.String("ap-east-1"), OptInStatus: aws.String("opted-in")},
+			{RegionName: aws.String("me-south-1"), OptInStatus: aws.String("not-opted-in")},
+		},
+	}, nil)
+
+	testCases := []struct {
+		desc     string
+		acct     account
+		expected []string
+	}{
+		{
+			desc:     "every opted in region is inventoried by default",
+			acct:     account{},
+			expected: []string{"us-east-1", "us-west-2", "eu-west-1", "ap-east-1"},
+		},
+		{
+			desc:     "only included regions are inventoried",
+			acct:     account{includeRegions: []string{"eu-west-1", "us-east-1", "me-south-1"}},
+			expected: []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			desc:     "excluded regions are skipped",
+			acct:     account{excludeRegions: []string{"ap-*", "us-west-2"}},
+			expected: []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			desc:     "excluded regions are skipped from the included ones",
+			acct:     account{includeRegions: []string{"us-*"}, excludeRegions: []string{"us-west-2"}},
+			expected: []string{"us-east-1"},
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc": testCase.desc,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		acct := testCase.acct
+		err := acct.getRegions(mockEc2)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, acct.regions)
+	}
+}
+
+func TestValidateRegions(t *testing.T) {
+	assert.NoError(t, validateRegions([]string{"us-east-1", "ap-*"}, nil))
+	assert.Error(t, validateRegions([]string{"us-east-1"}, []string{"us-[east-1"}))
+}
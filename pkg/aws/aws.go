@@ -1,20 +1,25 @@
 package aws
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
 	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
+	"net"
 	"os"
+	"sort"
+	"sync"
 )
 
 const (
@@ -22,74 +27,139 @@ const (
 )
 
 type awsSvc struct {
-	regions    []string
-	bucketName string
-	ec2svc     ec2iface.EC2API
-	s3svc      s3iface.S3API
+	accounts   []*account
 	awsSession *session.Session
+	// resources holds the resources to inventory.
+	resources  map[string]bool
+	lookupHost func(host string) ([]string, error)
+	// workers is the number of regions inventoried at the same time.
+	workers int
 }
 
 func New(configObject config.BaseConfig) (*awsSvc, error) {
 	var err error
-	if configObject.BucketName == "" {
-		return nil, fmt.Errorf("New: BucketName cannot be nil")
-	}
 
 	a := awsSvc{}
 
 	a.awsSession = session.Must(session.NewSession())
-	a.ec2svc = a.createEC2Service(os.Getenv("AWS_REGION"))
-	a.bucketName = configObject.BucketName
-	a.s3svc = s3.New(a.awsSession)
+	a.lookupHost = net.LookupHost
+	a.workers = configObject.InventoryWorkerCount()
 
-	err = a.getRegions()
+	a.resources, err = selectResources(configObject.AWSConfig)
 	if err != nil {
-		return nil, fmt.Errorf("Error Getting regions %s", err)
+		return nil, fmt.Errorf("New: %s", err)
+	}
+
+	var accountConfigs []*config.AWSAccount
+	if configObject.AWSConfig != nil {
+		accountConfigs = configObject.AWSConfig.Accounts
+
+		err = validateRegions(configObject.AWSConfig.Regions, configObject.AWSConfig.ExcludeRegions)
+		if err != nil {
+			return nil, fmt.Errorf("New: %s", err)
+		}
+	}
+	if len(accountConfigs) == 0 {
+		accountConfigs = []*config.AWSAccount{{RoleARN: os.Getenv("ROLE_ARN")}}
+	}
+
+	region := os.Getenv("AWS_REGION")
+	for _, accountConfig := range accountConfigs {
+		err = validateRegions(accountConfig.Regions, accountConfig.ExcludeRegions)
+		if err != nil {
+			return nil, fmt.Errorf("New: %s", err)
+		}
+
+		acct := a.newAccount(accountConfig, configObject.AWSConfig)
+
+		// An account that cannot be set up, such as one whose role cannot be assumed, is reported by Instances so
+		// that the other accounts are still inventoried.
+		acct.err = a.setupAccount(acct, region)
+		if acct.err != nil {
+			log.WithField("error", acct.err).Warn("New: Unable to set up account " + acct.name())
+		}
+
+		a.accounts = append(a.accounts, acct)
 	}
 
 	return &a, nil
 }
 
-// TODO: Look at removing the a.ec2svc field and get a new service from createEC2Service
-//
-// The only future danger I see here is that by using a.ec2svc you'll get the regions of the "local account" not the
-// "to be scanned account" and the region list may not be the same. ie If the local account disabled us-west-1 then
-// it wouldn't be returned, and hence instances running in us-west-1 would never be scanned in the
-// "to be scanned account".
-//
-// Not a problem at Invoca due to us having all regions enabled, but definitely an issue someone could run into.
-//
-// A workaround could be to throw down a https://docs.aws.amazon.com/sdk-for-go/api/service/ec2/#DescribeRegionsInput w
-// ith AllRegions: true
-func (a *awsSvc) getRegions() error {
-	if a.ec2svc == nil {
-		return fmt.Errorf("getRegions: ec2svc is not yet initialized")
-	}
-
-	resultRegions, err := a.ec2svc.DescribeRegions(nil)
+// setupAccount looks up the identity, alias and regions of the account.
+func (a *awsSvc) setupAccount(acct *account, region string) error {
+	err := acct.getIdentity(sts.New(a.awsSession, a.createConfig(acct, region)), region)
 	if err != nil {
-		return fmt.Errorf("getRegions: Error Describing regions %s", err)
+		return fmt.Errorf("setupAccount: %s", err)
 	}
 
-	for _, region := range resultRegions.Regions {
-		a.regions = append(a.regions, *region.RegionName)
+	acct.getAlias(iam.New(a.awsSession, a.createConfig(acct, region)))
+
+	err = acct.getRegions(a.createEC2Service(acct, region))
+	if err != nil {
+		return fmt.Errorf("setupAccount: Error Getting regions of account %s %s", acct.id, err)
 	}
 	return nil
 }
 
-// Does it make sense to grab this within New() and store it within the struct? Then you're not constantly hitting
-// os.Getenv() every time you need a new ec2.EC2, not that it's slow but it's work that can be done once and reused.
-func (a *awsSvc) createEC2Service(region string) *ec2.EC2 {
-	var baseConfig *aws.Config
-	roleArnName := os.Getenv("ROLE_ARN")
-	if roleArnName != "" {
-		creds := stscreds.NewCredentials(a.awsSession, roleArnName)
-		baseConfig = aws.NewConfig().WithCredentials(creds).WithRegion(*aws.String(region)).WithMaxRetries(10)
+// selectResources returns the resources to inventory, which are all of them unless awsConfig lists some.
+func selectResources(awsConfig *config.AWSConfig) (map[string]bool, error) {
+	known := []string{config.AWSInstances, config.AWSLoadBalancers, config.AWSElasticIPs, config.AWSNatGateways}
+	resources := make(map[string]bool)
+	if awsConfig == nil || len(awsConfig.Resources) == 0 {
+		for _, resource := range known {
+			resources[resource] = true
+		}
+		return resources, nil
+	}
+
+	for _, resource := range awsConfig.Resources {
+		valid := false
+		for _, k := range known {
+			valid = valid || resource == k
+		}
+		if !valid {
+			return nil, fmt.Errorf("selectResources: unknown AWS resource %s", resource)
+		}
+		resources[resource] = true
+	}
+	return resources, nil
+}
+
+// newAccount returns the account for accountConfig, with credentials assuming its role when one is set. The regions
+// of the account default to those of awsConfig.
+func (a *awsSvc) newAccount(accountConfig *config.AWSAccount, awsConfig *config.AWSConfig) *account {
+	acct := &account{
+		roleARN:        accountConfig.RoleARN,
+		includeRegions: accountConfig.Regions,
+		excludeRegions: accountConfig.ExcludeRegions,
+	}
+	if awsConfig != nil {
+		if len(acct.includeRegions) == 0 {
+			acct.includeRegions = awsConfig.Regions
+		}
+		acct.excludeRegions = append(acct.excludeRegions, awsConfig.ExcludeRegions...)
+	}
+	if acct.roleARN != "" {
+		acct.credentials = stscreds.NewCredentials(a.awsSession, acct.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			if accountConfig.ExternalID != "" {
+				p.ExternalID = aws.String(accountConfig.ExternalID)
+			}
+		})
+	}
+	return acct
+}
+
+func (a *awsSvc) createEC2Service(acct *account, region string) *ec2.EC2 {
+	return ec2.New(a.awsSession, a.createConfig(acct, region))
+}
+
+func (a *awsSvc) createConfig(acct *account, region string) *aws.Config {
+	baseConfig := aws.NewConfig().WithRegion(region).WithMaxRetries(10)
+	if acct.credentials != nil {
+		baseConfig = baseConfig.WithCredentials(acct.credentials)
 		baseConfig.CredentialsChainVerboseErrors = aws.Bool(true)
-	} else {
-		baseConfig = aws.NewConfig().WithRegion(*aws.String(region)).WithMaxRetries(10)
 	}
-	return ec2.New(a.awsSession, baseConfig)
+	return baseConfig
 }
 
 func (a *awsSvc) getInstancesInRegion(ec2Svc ec2iface.EC2API, serversMap map[string]server.Server) error {
@@ -97,84 +167,176 @@ func (a *awsSvc) getInstancesInRegion(ec2Svc ec2iface.EC2API, serversMap map[str
 		return fmt.Errorf("getInstancesInRegion: ec2Svc is nil")
 	}
 
-	ec2Instances, err := ec2Svc.DescribeInstances(nil)
+	err := ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				log.Debug("Reservation Id ", aws.StringValue(res.ReservationId), " Num Instances: ", len(res.Instances))
+				for _, inst := range res.Instances {
+					addInstance(inst, serversMap)
+				}
+			}
+			return true
+		})
 	if err != nil {
 		return fmt.Errorf("Instances: Error Describing Instances %s", err)
 	}
+	return nil
+}
+
+// addInstance records a running instance under every public IP attached to it. Instances without a public IP are
+// skipped since they cannot be scanned.
+func addInstance(inst *ec2.Instance, serversMap map[string]server.Server) {
+	// Status code 16 is Runnning state
+	if inst.State == nil || aws.Int64Value(inst.State.Code) != instanceRunningState {
+		return
+	}
 
-	reservations := ec2Instances.Reservations
+	addresses := publicAddresses(inst)
+	if len(addresses) == 0 {
+		log.Debug("Skipping instance ", aws.StringValue(inst.InstanceId), " without a public IP")
+		return
+	}
 
-	for _, res := range reservations {
-		log.Debug("Reservation Id", *res.ReservationId, " Num Instances: ", len(res.Instances))
-		for _, inst := range res.Instances {
-			// Status code 16 is Runnning state
-			if *inst.State.Code == instanceRunningState {
-				newInstance := server.Server{}
-				newInstance.Tags = make(map[string]string)
-				newInstance.Address = *inst.PublicIpAddress
-				newInstance.Name = *inst.InstanceId
-				for _, tag := range inst.Tags {
-					newInstance.Tags[*tag.Key] = *tag.Value
-				}
-				serversMap[newInstance.Address] = newInstance
+	for _, address := range addresses {
+		newInstance := server.Server{}
+		newInstance.Tags = make(map[string]string)
+		newInstance.Address = address
+		newInstance.Name = aws.StringValue(inst.InstanceId)
+		newInstance.Provider = "aws"
+		newInstance.ResourceID = aws.StringValue(inst.InstanceId)
+		for _, tag := range inst.Tags {
+			newInstance.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		serversMap[newInstance.Address] = newInstance
+	}
+}
+
+// publicAddresses returns the public IPs of the instance, including those associated with the secondary private IPs
+// of every network interface, in the order they are attached.
+func publicAddresses(inst *ec2.Instance) []string {
+	var addresses []string
+	seen := make(map[string]bool)
+	add := func(address *string) {
+		if address == nil || *address == "" || seen[*address] {
+			return
+		}
+		seen[*address] = true
+		addresses = append(addresses, *address)
+	}
+
+	add(inst.PublicIpAddress)
+	for _, networkInterface := range inst.NetworkInterfaces {
+		if networkInterface.Association != nil {
+			add(networkInterface.Association.PublicIp)
+		}
+		for _, privateAddress := range networkInterface.PrivateIpAddresses {
+			if privateAddress.Association != nil {
+				add(privateAddress.Association.PublicIp)
 			}
 		}
 	}
-	return nil
+	return addresses
 }
 
 func (a *awsSvc) Instances(serversMap map[string]server.Server) error {
 	if a.awsSession == nil {
 		return fmt.Errorf("Instances: awsSession Cannot be nil")
 	}
-	for _, region := range a.regions {
-		ec2Svc := a.createEC2Service(region)
-		err := a.getInstancesInRegion(ec2Svc, serversMap)
-		for k, v := range serversMap {
-			serversMap[k] = v
+
+	// Load balancers that could not be resolved are collected from every region and returned along with the regions
+	// that failed, while the other resources of their region are still recorded.
+	var hostErrors inventory.Errors
+	var hostErrorsMutex sync.Mutex
+
+	// Accounts that could not be set up are returned as inventory.Errors along with the regions of the other accounts.
+	// Nothing is inventoried when none of them could be set up.
+	var accountErrors inventory.Errors
+	var tasks []inventory.Task
+	for _, acct := range a.accounts {
+		if acct.err != nil {
+			accountErrors = append(accountErrors, &inventory.TaskError{Task: acct.name(), Err: acct.err})
+			continue
 		}
-		if err != nil {
-			return fmt.Errorf("Error gettings instances in region %s", err)
+		for _, region := range acct.regions {
+			acct, region := acct, region
+			tasks = append(tasks, inventory.Task{
+				Name: acct.id + "/" + region,
+				Run: func(regionServers map[string]server.Server) error {
+					regionHostErrors, err := a.getResourcesInRegion(acct, region, regionServers)
+					if err != nil {
+						return err
+					}
+					hostErrorsMutex.Lock()
+					hostErrors = append(hostErrors, regionHostErrors...)
+					hostErrorsMutex.Unlock()
+					for address, s := range regionServers {
+						acct.tag(&s)
+						regionServers[address] = s
+					}
+					return nil
+				},
+			})
 		}
 	}
-	return nil
-}
 
-func (a *awsSvc) UploadObjectToS3(fileData []byte, s3Key string) error {
-	if a.s3svc == nil {
-		return fmt.Errorf("UploadObjectToS3: s3svc cannot be nil")
+	if len(accountErrors) > 0 && len(accountErrors) == len(a.accounts) {
+		return fmt.Errorf("Instances: Unable to set up any account %s", accountErrors)
 	}
 
-	_, err := a.s3svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(a.bucketName),
-		Body:   bytes.NewReader(fileData),
-		Key:    aws.String(s3Key),
-	})
-
-	if err != nil {
-		return fmt.Errorf("Error uploading object %s", err)
+	var errs inventory.Errors
+	err := inventory.Run(a.workers, tasks, serversMap)
+	if err != nil && !errors.As(err, &errs) {
+		return fmt.Errorf("Instances: Error getting resources %s", err)
 	}
+	sort.Slice(hostErrors, func(i, j int) bool { return hostErrors[i].Task < hostErrors[j].Task })
+	errs = append(append(accountErrors, errs...), hostErrors...)
 
+	if len(errs) > 0 {
+		return fmt.Errorf("Instances: Error getting resources %w", errs)
+	}
 	return nil
 }
 
-func (a *awsSvc) GetFileFromS3(s3Key string) ([]byte, error) {
-	if a.s3svc == nil {
-		return nil, fmt.Errorf("GetFileFromS3: s3svc cannot be nil")
+// getResourcesInRegion records every selected resource of the region. Elastic IPs are collected last so that those
+// attached to another resource keep the entry of that resource. Load balancers that could not be resolved are returned
+// as inventory.Errors.
+func (a *awsSvc) getResourcesInRegion(acct *account, region string, serversMap map[string]server.Server) (inventory.Errors, error) {
+	ec2Svc := a.createEC2Service(acct, region)
+	var hostErrors inventory.Errors
+
+	if a.resources[config.AWSInstances] {
+		err := a.getInstancesInRegion(ec2Svc, serversMap)
+		if err != nil {
+			return nil, fmt.Errorf("getResourcesInRegion: %s %s", region, err)
+		}
 	}
 
-	resp, err := a.s3svc.GetObject(&s3.GetObjectInput{
-		Key:    aws.String(s3Key),
-		Bucket: aws.String(a.bucketName),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("GetFileFromS3: Error getting resp from s3")
+	if a.resources[config.AWSLoadBalancers] {
+		loadBalancerErrors, err := a.getLoadBalancersInRegion(elbv2.New(a.awsSession, a.createConfig(acct, region)), serversMap)
+		if err != nil {
+			return nil, fmt.Errorf("getResourcesInRegion: %s %s", region, err)
+		}
+		hostErrors = append(hostErrors, loadBalancerErrors...)
+
+		loadBalancerErrors, err = a.getClassicLoadBalancersInRegion(elb.New(a.awsSession, a.createConfig(acct, region)), acct, region, serversMap)
+		if err != nil {
+			return nil, fmt.Errorf("getResourcesInRegion: %s %s", region, err)
+		}
+		hostErrors = append(hostErrors, loadBalancerErrors...)
 	}
 
-	byteSlice, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("GetFileFromS3: Error reading to byte slice %s", err)
+	if a.resources[config.AWSNatGateways] {
+		err := a.getNatGatewaysInRegion(ec2Svc, acct, region, serversMap)
+		if err != nil {
+			return nil, fmt.Errorf("getResourcesInRegion: %s %s", region, err)
+		}
 	}
 
-	return byteSlice, nil
+	if a.resources[config.AWSElasticIPs] {
+		err := a.getElasticIPsInRegion(ec2Svc, acct, region, serversMap)
+		if err != nil {
+			return nil, fmt.Errorf("getResourcesInRegion: %s %s", region, err)
+		}
+	}
+	return hostErrors, nil
 }
@@ -1,18 +1,18 @@
 package aws
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
 	"github.com/Invoca/nmap-diff/pkg/mocks"
 	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/s3"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
-	"io/ioutil"
 	"strconv"
-	"strings"
 	"testing"
 )
 
@@ -29,31 +29,69 @@ func TestGetAWSInstances(t *testing.T) {
 	mockEc2 := &mocks.MockEC2API{}
 	serversMap := make(map[string]server.Server)
 	instanceState := instanceRunningState
+	stoppedState := int64(80)
 
 	runningState := ec2.InstanceState{Code: &instanceState}
 
-	resp := ec2.DescribeInstancesOutput{
-		Reservations: []*ec2.Reservation{
-			{
-				ReservationId: aws.String("123ABC"),
-				Instances: []*ec2.Instance{
-					{
-						InstanceId:       aws.String("Instance 1"),
-						PublicIpAddress:  aws.String("6.6.6.6"),
-						PrivateIpAddress: aws.String("1.1.1.1"),
-						State:            &runningState,
-					},
-					{
-						InstanceId:       aws.String("Instance 2"),
-						PublicIpAddress:  aws.String("6.6.6.7"),
-						PrivateIpAddress: aws.String("2.2.2.2"),
-						State:            &runningState,
+	pages := []*ec2.DescribeInstancesOutput{
+		{
+			Reservations: []*ec2.Reservation{
+				{
+					ReservationId: aws.String("123ABC"),
+					Instances: []*ec2.Instance{
+						{
+							InstanceId:       aws.String("Instance 1"),
+							PublicIpAddress:  aws.String("6.6.6.6"),
+							PrivateIpAddress: aws.String("1.1.1.1"),
+							State:            &runningState,
+							Tags:             []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+						},
+						{
+							InstanceId:       aws.String("Instance 2"),
+							PrivateIpAddress: aws.String("2.2.2.2"),
+							State:            &runningState,
+						},
+						{
+							InstanceId:      aws.String("Instance 3"),
+							PublicIpAddress: aws.String("6.6.6.9"),
+							State:           &ec2.InstanceState{Code: &stoppedState},
+						},
 					},
-					{
-						InstanceId:       aws.String("Instance 3"),
-						PublicIpAddress:  aws.String("6.6.6.8"),
-						PrivateIpAddress: aws.String("3.3.3.3"),
-						State:            &runningState,
+				},
+			},
+		},
+		{
+			Reservations: []*ec2.Reservation{
+				{
+					ReservationId: aws.String("456DEF"),
+					Instances: []*ec2.Instance{
+						{
+							InstanceId:       aws.String("Instance 4"),
+							PublicIpAddress:  aws.String("6.6.6.7"),
+							PrivateIpAddress: aws.String("4.4.4.4"),
+							State:            &runningState,
+							NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+								{
+									Association: &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("6.6.6.7")},
+									PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+										{
+											PrivateIpAddress: aws.String("4.4.4.4"),
+											Association:      &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("6.6.6.7")},
+										},
+										{
+											PrivateIpAddress: aws.String("4.4.4.5"),
+											Association:      &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("6.6.6.8")},
+										},
+										{
+											PrivateIpAddress: aws.String("4.4.4.6"),
+										},
+									},
+								},
+								{
+									Association: &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("7.7.7.7")},
+								},
+							},
+						},
 					},
 				},
 			},
@@ -65,7 +103,7 @@ func TestGetAWSInstances(t *testing.T) {
 			desc: "successful ip retrieval",
 			setup: func() {
 				mockEc2.Reset()
-				mockEc2.On("DescribeInstances", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(&resp, nil)
+				mockEc2.On("DescribeInstancesPages", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(pages, nil)
 			},
 			shouldError: false,
 		},
@@ -73,7 +111,7 @@ func TestGetAWSInstances(t *testing.T) {
 			desc: "error returned by ip retrieval",
 			setup: func() {
 				mockEc2.Reset()
-				mockEc2.On("DescribeInstances", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(&resp, fmt.Errorf("error"))
+				mockEc2.On("DescribeInstancesPages", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(nil, fmt.Errorf("error"))
 			},
 			shouldError: true,
 		},
@@ -88,7 +126,6 @@ func TestGetAWSInstances(t *testing.T) {
 		testCase.setup()
 
 		ec2api := awsSvc{}
-		ec2api.ec2svc = mockEc2
 
 		err := ec2api.getInstancesInRegion(mockEc2, serversMap)
 
@@ -101,6 +138,16 @@ func TestGetAWSInstances(t *testing.T) {
 		}
 	}
 
+	t.Logf("TestGetAWSInstances: every public IP of running instances is recorded across pages")
+	var addresses []string
+	for address := range serversMap {
+		addresses = append(addresses, address)
+	}
+	assert.ElementsMatch(t, []string{"6.6.6.6", "6.6.6.7", "6.6.6.8", "7.7.7.7"}, addresses)
+	assert.Equal(t, "Instance 4", serversMap["7.7.7.7"].Name)
+	assert.Equal(t, "aws:Instance 4", serversMap["7.7.7.7"].Identity())
+	assert.Equal(t, "web", serversMap["6.6.6.6"].Tags["Name"])
+
 	t.Logf("TestGetAWSInstances: pass nil object to getInstances")
 
 	ec2api := awsSvc{}
@@ -165,10 +212,9 @@ func TestGetAWSRegions(t *testing.T) {
 
 		testCase.setup()
 
-		ec2api := awsSvc{}
-		ec2api.ec2svc = mockEc2
+		acct := account{}
 
-		err := ec2api.getRegions()
+		err := acct.getRegions(mockEc2)
 
 		mockEc2.AssertExpectations(t)
 
@@ -181,59 +227,35 @@ func TestGetAWSRegions(t *testing.T) {
 
 	t.Logf("TestGetAWSRegions: pass nil object to getRegions")
 
-	ec2api := awsSvc{}
-	err := ec2api.getRegions()
+	acct := account{}
+	err := acct.getRegions(nil)
 	assert.Error(t, err)
 
 }
 
-func TestGetS3Object(t *testing.T) {
-	body := ioutil.NopCloser(strings.NewReader("I am string"))
-
-	returnValue := s3.GetObjectOutput{
-		Body: body,
-	}
-
-	mockS3 := &mocks.MockS3API{}
+func TestInstancesAccountErrors(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
 
-	testCases := []awsTestCase{
-		{
-			desc: "successful object retrieval",
-			setup: func() {
-				mockS3.Reset()
-				mockS3.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(&returnValue, nil)
-			},
-			shouldError: false,
-		},
-		{
-			desc: "error returned by object retrieval",
-			setup: func() {
-				mockS3.Reset()
-				mockS3.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(&returnValue, fmt.Errorf("error"))
-			},
-			shouldError: true,
+	failedRole := "arn:aws:iam::222222222222:role/nmap-diff"
+	a := awsSvc{
+		awsSession: session.Must(session.NewSession()),
+		workers:    1,
+		accounts: []*account{
+			{id: "111111111111"},
+			{roleARN: failedRole, err: fmt.Errorf("AccessDenied")},
 		},
 	}
 
-	for index, testCase := range testCases {
-		log.WithFields(log.Fields{
-			"desc":        testCase.desc,
-			"shouldError": testCase.shouldError,
-		}).Debug("Starting testCase " + strconv.Itoa(index))
+	t.Logf("TestInstancesAccountErrors: an account that could not be set up is reported on its own")
+	err := a.Instances(make(map[string]server.Server))
+	var accountErrors inventory.Errors
+	assert.True(t, errors.As(err, &accountErrors))
+	assert.Equal(t, 1, len(accountErrors))
+	assert.Equal(t, failedRole, accountErrors[0].Task)
 
-		testCase.setup()
-
-		ec2api := awsSvc{}
-		ec2api.s3svc = mockS3
-
-		_, err := ec2api.GetFileFromS3("file")
-
-		mockS3.AssertExpectations(t)
-
-		if testCase.shouldError {
-			assert.Error(t, err)
-		} else {
-			assert.NoError(t, err)
-		}
-	}
+	t.Logf("TestInstancesAccountErrors: nothing is inventoried when no account could be set up")
+	a.accounts = a.accounts[1:]
+	err = a.Instances(make(map[string]server.Server))
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &accountErrors))
 }
@@ -0,0 +1,211 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	log "github.com/sirupsen/logrus"
+)
+
+// The tags every resource other than instances is recorded with.
+const (
+	ResourceTypeTag = "resourceType"
+	ArnTag          = "arn"
+
+	loadBalancerResourceType        = "load-balancer"
+	classicLoadBalancerResourceType = "classic-load-balancer"
+	elasticIPResourceType           = "elastic-ip"
+	natGatewayResourceType          = "nat-gateway"
+
+	internetFacingScheme = "internet-facing"
+)
+
+// newResource returns a server for a resource other than an instance, identified and tagged with its ARN.
+func newResource(name string, address string, resourceType string, arn string) server.Server {
+	return server.Server{
+		Name:       name,
+		Address:    address,
+		Provider:   "aws",
+		ResourceID: arn,
+		Tags: map[string]string{
+			ResourceTypeTag: resourceType,
+			ArnTag:          arn,
+		},
+	}
+}
+
+// resolve returns the IPv4 and IPv6 addresses a load balancer DNS name resolves to, so that every address of a
+// dualstack load balancer is scanned.
+func (a *awsSvc) resolve(dnsName string) ([]string, error) {
+	resolved, err := a.lookupHost(dnsName)
+	if err != nil {
+		return nil, fmt.Errorf("resolve: Error resolving %s %s", dnsName, err)
+	}
+
+	var addresses []string
+	for _, address := range resolved {
+		if ip := net.ParseIP(address); ip != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses, nil
+}
+
+// getLoadBalancersInRegion records every address of the internet facing application and network load balancers, with
+// their DNS name as scope. Load balancers whose DNS name could not be resolved, such as those being deleted, are returned as inventory.Errors
+// after the others are recorded.
+func (a *awsSvc) getLoadBalancersInRegion(elbv2Svc elbv2iface.ELBV2API, serversMap map[string]server.Server) (inventory.Errors, error) {
+	if elbv2Svc == nil {
+		return nil, fmt.Errorf("getLoadBalancersInRegion: elbv2Svc is nil")
+	}
+
+	var loadBalancers []*elbv2.LoadBalancer
+	err := elbv2Svc.DescribeLoadBalancersPages(&elbv2.DescribeLoadBalancersInput{},
+		func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+			loadBalancers = append(loadBalancers, page.LoadBalancers...)
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("getLoadBalancersInRegion: Error Describing Load Balancers %s", err)
+	}
+
+	var hostErrors inventory.Errors
+	for _, loadBalancer := range loadBalancers {
+		if aws.StringValue(loadBalancer.Scheme) != internetFacingScheme {
+			continue
+		}
+
+		dnsName := aws.StringValue(loadBalancer.DNSName)
+		addresses, err := a.resolve(dnsName)
+		if err != nil {
+			hostErrors = append(hostErrors, &inventory.TaskError{Task: dnsName, Err: err})
+			continue
+		}
+
+		for _, address := range addresses {
+			newServer := newResource(aws.StringValue(loadBalancer.LoadBalancerName), address,
+				loadBalancerResourceType, aws.StringValue(loadBalancer.LoadBalancerArn))
+			newServer.Scopes = []string{dnsName}
+			serversMap[address] = newServer
+		}
+	}
+	return hostErrors, nil
+}
+
+// getClassicLoadBalancersInRegion records every address of the internet facing classic load balancers, with their DNS
+// name as scope. Load balancers whose DNS name could not be resolved are returned as inventory.Errors after the others
+// are recorded.
+func (a *awsSvc) getClassicLoadBalancersInRegion(elbSvc elbiface.ELBAPI, acct *account, region string, serversMap map[string]server.Server) (inventory.Errors, error) {
+	if elbSvc == nil {
+		return nil, fmt.Errorf("getClassicLoadBalancersInRegion: elbSvc is nil")
+	}
+
+	var loadBalancers []*elb.LoadBalancerDescription
+	err := elbSvc.DescribeLoadBalancersPages(&elb.DescribeLoadBalancersInput{},
+		func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+			loadBalancers = append(loadBalancers, page.LoadBalancerDescriptions...)
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("getClassicLoadBalancersInRegion: Error Describing Load Balancers %s", err)
+	}
+
+	var hostErrors inventory.Errors
+	for _, loadBalancer := range loadBalancers {
+		if aws.StringValue(loadBalancer.Scheme) != internetFacingScheme {
+			continue
+		}
+
+		dnsName := aws.StringValue(loadBalancer.DNSName)
+		addresses, err := a.resolve(dnsName)
+		if err != nil {
+			hostErrors = append(hostErrors, &inventory.TaskError{Task: dnsName, Err: err})
+			continue
+		}
+
+		name := aws.StringValue(loadBalancer.LoadBalancerName)
+		for _, address := range addresses {
+			newServer := newResource(name, address, classicLoadBalancerResourceType,
+				acct.arn("elasticloadbalancing", region, "loadbalancer/"+name))
+			newServer.Scopes = []string{dnsName}
+			serversMap[address] = newServer
+		}
+	}
+	return hostErrors, nil
+}
+
+// getNatGatewaysInRegion records the public IPs of the available NAT gateways.
+func (a *awsSvc) getNatGatewaysInRegion(ec2Svc ec2iface.EC2API, acct *account, region string, serversMap map[string]server.Server) error {
+	if ec2Svc == nil {
+		return fmt.Errorf("getNatGatewaysInRegion: ec2Svc is nil")
+	}
+
+	err := ec2Svc.DescribeNatGatewaysPages(&ec2.DescribeNatGatewaysInput{},
+		func(page *ec2.DescribeNatGatewaysOutput, lastPage bool) bool {
+			for _, natGateway := range page.NatGateways {
+				if aws.StringValue(natGateway.State) != ec2.NatGatewayStateAvailable {
+					continue
+				}
+
+				id := aws.StringValue(natGateway.NatGatewayId)
+				for _, natAddress := range natGateway.NatGatewayAddresses {
+					address := aws.StringValue(natAddress.PublicIp)
+					if address == "" {
+						continue
+					}
+
+					newServer := newResource(id, address, natGatewayResourceType, acct.arn("ec2", region, "natgateway/"+id))
+					for _, tag := range natGateway.Tags {
+						newServer.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+					}
+					serversMap[address] = newServer
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return fmt.Errorf("getNatGatewaysInRegion: Error Describing NAT Gateways %s", err)
+	}
+	return nil
+}
+
+// getElasticIPsInRegion records the Elastic IPs that no other resource was recorded under, such as unattached ones.
+// Elastic IPs attached to an instance or NAT gateway keep the entry of that resource.
+func (a *awsSvc) getElasticIPsInRegion(ec2Svc ec2iface.EC2API, acct *account, region string, serversMap map[string]server.Server) error {
+	if ec2Svc == nil {
+		return fmt.Errorf("getElasticIPsInRegion: ec2Svc is nil")
+	}
+
+	result, err := ec2Svc.DescribeAddresses(&ec2.DescribeAddressesInput{})
+	if err != nil {
+		return fmt.Errorf("getElasticIPsInRegion: Error Describing Addresses %s", err)
+	}
+
+	for _, elasticIP := range result.Addresses {
+		address := aws.StringValue(elasticIP.PublicIp)
+		if address == "" {
+			continue
+		}
+		if _, ok := serversMap[address]; ok {
+			log.Debug("Elastic IP ", address, " is already recorded")
+			continue
+		}
+
+		id := aws.StringValue(elasticIP.AllocationId)
+		newServer := newResource(id, address, elasticIPResourceType, acct.arn("ec2", region, "elastic-ip/"+id))
+		for _, tag := range elasticIP.Tags {
+			newServer.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		serversMap[address] = newServer
+	}
+	return nil
+}
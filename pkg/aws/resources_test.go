@@ -0,0 +1,258 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/mocks"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testAccount = &account{id: "123456789012", partition: "aws"}
+
+func newTestSvc() *awsSvc {
+	return &awsSvc{
+		lookupHost: func(host string) ([]string, error) {
+			switch host {
+			case "web.elb.amazonaws.com":
+				return []string{"8.8.8.1", "8.8.8.2", "2600:1f18::1"}, nil
+			case "classic.elb.amazonaws.com":
+				return []string{"8.8.8.3"}, nil
+			default:
+				return nil, fmt.Errorf("no such host")
+			}
+		},
+	}
+}
+
+func TestGetLoadBalancers(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	mockELBV2 := &mocks.MockELBV2API{}
+	serversMap := make(map[string]server.Server)
+
+	pages := []*elbv2.DescribeLoadBalancersOutput{
+		{
+			LoadBalancers: []*elbv2.LoadBalancer{
+				{
+					LoadBalancerName: aws.String("web"),
+					LoadBalancerArn:  aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/web/1"),
+					DNSName:          aws.String("web.elb.amazonaws.com"),
+					Scheme:           aws.String("internet-facing"),
+				},
+			},
+		},
+		{
+			LoadBalancers: []*elbv2.LoadBalancer{
+				{
+					LoadBalancerName: aws.String("internal"),
+					DNSName:          aws.String("internal.elb.amazonaws.com"),
+					Scheme:           aws.String("internal"),
+				},
+			},
+		},
+	}
+
+	testCases := []awsTestCase{
+		{
+			desc: "successful load balancer retrieval",
+			setup: func() {
+				mockELBV2.Reset()
+				mockELBV2.On("DescribeLoadBalancersPages", mock.AnythingOfType("*elbv2.DescribeLoadBalancersInput")).Return(pages, nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "error returned by load balancer retrieval",
+			setup: func() {
+				mockELBV2.Reset()
+				mockELBV2.On("DescribeLoadBalancersPages", mock.AnythingOfType("*elbv2.DescribeLoadBalancersInput")).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		hostErrors, err := newTestSvc().getLoadBalancersInRegion(mockELBV2, serversMap)
+		assert.Empty(t, hostErrors)
+
+		mockELBV2.AssertExpectations(t)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	t.Logf("TestGetLoadBalancers: every IPv4 and IPv6 address of internet facing load balancers is recorded")
+	assert.Equal(t, 3, len(serversMap))
+	assert.Equal(t, "web", serversMap["2600:1f18::1"].Name)
+	assert.Equal(t, "web", serversMap["8.8.8.2"].Name)
+	assert.Equal(t, "load-balancer", serversMap["8.8.8.1"].Tags[ResourceTypeTag])
+	assert.Equal(t, "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/web/1", serversMap["8.8.8.1"].Tags[ArnTag])
+	assert.Equal(t, serversMap["8.8.8.1"].Tags[ArnTag], serversMap["8.8.8.1"].ResourceID)
+	assert.Equal(t, []string{"web.elb.amazonaws.com"}, serversMap["8.8.8.1"].Scopes)
+
+	_, err := newTestSvc().getLoadBalancersInRegion(nil, serversMap)
+	assert.Error(t, err)
+}
+
+func TestGetClassicLoadBalancers(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	mockELB := &mocks.MockELBAPI{}
+	serversMap := make(map[string]server.Server)
+
+	pages := []*elb.DescribeLoadBalancersOutput{
+		{
+			LoadBalancerDescriptions: []*elb.LoadBalancerDescription{
+				{
+					LoadBalancerName: aws.String("classic"),
+					DNSName:          aws.String("classic.elb.amazonaws.com"),
+					Scheme:           aws.String("internet-facing"),
+				},
+			},
+		},
+	}
+
+	testCases := []awsTestCase{
+		{
+			desc: "successful classic load balancer retrieval",
+			setup: func() {
+				mockELB.Reset()
+				mockELB.On("DescribeLoadBalancersPages", mock.AnythingOfType("*elb.DescribeLoadBalancersInput")).Return(pages, nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "error returned by classic load balancer retrieval",
+			setup: func() {
+				mockELB.Reset()
+				mockELB.On("DescribeLoadBalancersPages", mock.AnythingOfType("*elb.DescribeLoadBalancersInput")).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		_, err := newTestSvc().getClassicLoadBalancersInRegion(mockELB, testAccount, "us-east-1", serversMap)
+
+		mockELB.AssertExpectations(t)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, "classic-load-balancer", serversMap["8.8.8.3"].Tags[ResourceTypeTag])
+	assert.Equal(t, "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/classic", serversMap["8.8.8.3"].Tags[ArnTag])
+
+	t.Logf("TestGetClassicLoadBalancers: a load balancer that does not resolve is returned without failing the others")
+	mockELB.Reset()
+	mockELB.On("DescribeLoadBalancersPages", mock.AnythingOfType("*elb.DescribeLoadBalancersInput")).Return(
+		[]*elb.DescribeLoadBalancersOutput{{LoadBalancerDescriptions: []*elb.LoadBalancerDescription{
+			{LoadBalancerName: aws.String("gone"), DNSName: aws.String("gone.elb.amazonaws.com"), Scheme: aws.String("internet-facing")},
+			{LoadBalancerName: aws.String("classic"), DNSName: aws.String("classic.elb.amazonaws.com"), Scheme: aws.String("internet-facing")},
+		}}}, nil)
+	serversMap = make(map[string]server.Server)
+	hostErrors, err := newTestSvc().getClassicLoadBalancersInRegion(mockELB, testAccount, "us-east-1", serversMap)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(hostErrors))
+	assert.Equal(t, "gone.elb.amazonaws.com", hostErrors[0].Task)
+	assert.Contains(t, serversMap, "8.8.8.3")
+}
+
+func TestGetNatGatewaysAndElasticIPs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	mockEc2 := &mocks.MockEC2API{}
+	serversMap := map[string]server.Server{
+		"6.6.6.6": {Name: "Instance 1", Address: "6.6.6.6", Provider: "aws"},
+	}
+
+	natPages := []*ec2.DescribeNatGatewaysOutput{
+		{
+			NatGateways: []*ec2.NatGateway{
+				{
+					NatGatewayId:        aws.String("nat-1"),
+					State:               aws.String(ec2.NatGatewayStateAvailable),
+					NatGatewayAddresses: []*ec2.NatGatewayAddress{{PublicIp: aws.String("9.9.9.1")}},
+					Tags:                []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("egress")}},
+				},
+				{
+					NatGatewayId:        aws.String("nat-2"),
+					State:               aws.String(ec2.NatGatewayStateDeleted),
+					NatGatewayAddresses: []*ec2.NatGatewayAddress{{PublicIp: aws.String("9.9.9.2")}},
+				},
+			},
+		},
+	}
+
+	addresses := ec2.DescribeAddressesOutput{
+		Addresses: []*ec2.Address{
+			{PublicIp: aws.String("6.6.6.6"), AllocationId: aws.String("eipalloc-1"), InstanceId: aws.String("Instance 1")},
+			{PublicIp: aws.String("9.9.9.1"), AllocationId: aws.String("eipalloc-2")},
+			{PublicIp: aws.String("9.9.9.3"), AllocationId: aws.String("eipalloc-3")},
+		},
+	}
+
+	mockEc2.On("DescribeNatGatewaysPages", mock.AnythingOfType("*ec2.DescribeNatGatewaysInput")).Return(natPages, nil)
+	mockEc2.On("DescribeAddresses", mock.AnythingOfType("*ec2.DescribeAddressesInput")).Return(&addresses, nil)
+
+	a := newTestSvc()
+	assert.NoError(t, a.getNatGatewaysInRegion(mockEc2, testAccount, "us-east-1", serversMap))
+	assert.NoError(t, a.getElasticIPsInRegion(mockEc2, testAccount, "us-east-1", serversMap))
+	mockEc2.AssertExpectations(t)
+
+	t.Logf("TestGetNatGatewaysAndElasticIPs: attached Elastic IPs keep the entry of their resource")
+	assert.Equal(t, 3, len(serversMap))
+	assert.Equal(t, "Instance 1", serversMap["6.6.6.6"].Name)
+	assert.Equal(t, "nat-gateway", serversMap["9.9.9.1"].Tags[ResourceTypeTag])
+	assert.Equal(t, "egress", serversMap["9.9.9.1"].Tags["Name"])
+	assert.Equal(t, "arn:aws:ec2:us-east-1:123456789012:natgateway/nat-1", serversMap["9.9.9.1"].Tags[ArnTag])
+	assert.Equal(t, "elastic-ip", serversMap["9.9.9.3"].Tags[ResourceTypeTag])
+	assert.Equal(t, "arn:aws:ec2:us-east-1:123456789012:elastic-ip/eipalloc-3", serversMap["9.9.9.3"].Tags[ArnTag])
+
+	mockEc2.Reset()
+	mockEc2.On("DescribeAddresses", mock.AnythingOfType("*ec2.DescribeAddressesInput")).Return(nil, fmt.Errorf("error"))
+	assert.Error(t, a.getElasticIPsInRegion(mockEc2, testAccount, "us-east-1", serversMap))
+}
+
+func TestSelectResources(t *testing.T) {
+	resources, err := selectResources(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(resources))
+
+	resources, err = selectResources(&config.AWSConfig{Resources: []string{config.AWSInstances, config.AWSElasticIPs}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{config.AWSInstances: true, config.AWSElasticIPs: true}, resources)
+
+	_, err = selectResources(&config.AWSConfig{Resources: []string{"lambdas"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,224 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SubscriptionTag  = "subscriptionId"
+	ResourceGroupTag = "resourceGroup"
+	LocationTag      = "location"
+	// PublicIPTag holds the name of the public IP address resource.
+	PublicIPTag = "publicIpName"
+	// AttachedTypeTag holds the type of the resource the address is associated with, such as virtual-machine or
+	// load-balancer. It is not set for unassociated addresses.
+	AttachedTypeTag = "attachedType"
+)
+
+// The types of the resources public IP addresses are associated with.
+const (
+	virtualMachineType        = "virtual-machine"
+	networkInterfaceType      = "network-interface"
+	loadBalancerType          = "load-balancer"
+	applicationGatewayType    = "application-gateway"
+	virtualNetworkGatewayType = "virtual-network-gateway"
+	natGatewayType            = "nat-gateway"
+)
+
+// attachedTypes maps the lower cased Azure type of the resource holding an IP configuration to the type recorded in
+// AttachedTypeTag. Other types are recorded as they are.
+var attachedTypes = map[string]string{
+	"microsoft.network/networkinterfaces":      networkInterfaceType,
+	"microsoft.network/loadbalancers":          loadBalancerType,
+	"microsoft.network/applicationgateways":    applicationGatewayType,
+	"microsoft.network/virtualnetworkgateways": virtualNetworkGatewayType,
+	"microsoft.network/natgateways":            natGatewayType,
+}
+
+type azureSvc struct {
+	client wrapper.AzureWrapper
+	// subscriptions holds the subscriptions to inventory. Every readable subscription is inventoried when it is empty.
+	subscriptions []string
+	// workers is the number of subscriptions inventoried at the same time.
+	workers int
+}
+
+// New returns a service inventorying the public IP addresses of the configured subscriptions. The service principal
+// defaults to the one set in AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET.
+func New(configObject config.BaseConfig) (*azureSvc, error) {
+	azureConfig := config.AzureConfig{}
+	if configObject.AzureConfig != nil {
+		azureConfig = *configObject.AzureConfig
+	}
+	if azureConfig.TenantID == "" {
+		azureConfig.TenantID = os.Getenv("AZURE_TENANT_ID")
+	}
+	if azureConfig.ClientID == "" {
+		azureConfig.ClientID = os.Getenv("AZURE_CLIENT_ID")
+	}
+	if azureConfig.ClientSecret == "" {
+		azureConfig.ClientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+	}
+
+	client, err := newAzureClient(azureConfig)
+	if err != nil {
+		return nil, fmt.Errorf("New: Error creating Azure client %s", err)
+	}
+
+	a := azureSvc{
+		client:        client,
+		subscriptions: azureConfig.Subscriptions,
+		workers:       configObject.InventoryWorkerCount(),
+	}
+	return &a, nil
+}
+
+// Instances records the public IP addresses of every subscription, inventorying several subscriptions at the same
+// time. Subscriptions that could not be listed are returned as inventory.Errors after the others are recorded.
+func (a *azureSvc) Instances(serversMap map[string]server.Server) error {
+	subscriptions := a.subscriptions
+	if len(subscriptions) == 0 {
+		var err error
+		subscriptions, err = a.client.Subscriptions()
+		if err != nil {
+			return fmt.Errorf("Instances: Error listing subscriptions %s", err)
+		}
+	}
+
+	tasks := make([]inventory.Task, len(subscriptions))
+	for index, subscriptionID := range subscriptions {
+		subscriptionID := subscriptionID
+		tasks[index] = inventory.Task{
+			Name: subscriptionID,
+			Run: func(subscriptionServers map[string]server.Server) error {
+				return a.getSubscriptionResources(subscriptionID, subscriptionServers)
+			},
+		}
+	}
+
+	err := inventory.Run(a.workers, tasks, serversMap)
+	if err != nil {
+		return fmt.Errorf("Instances: Error getting resources %w", err)
+	}
+	return nil
+}
+
+// getSubscriptionResources records the allocated public IP addresses of the subscription along with the virtual
+// machine, network interface, load balancer or NAT gateway they are associated with.
+func (a *azureSvc) getSubscriptionResources(subscriptionID string, serversMap map[string]server.Server) error {
+	log.Debug("Listing public IP addresses of subscription ", subscriptionID)
+	addresses, err := a.client.PublicIPAddresses(subscriptionID)
+	if err != nil {
+		return fmt.Errorf("getSubscriptionResources: %s", err)
+	}
+
+	networkInterfaces, err := a.client.NetworkInterfaces(subscriptionID)
+	if err != nil {
+		return fmt.Errorf("getSubscriptionResources: %s", err)
+	}
+	networkInterfacesByID := make(map[string]*wrapper.AzureNetworkInterface)
+	for _, networkInterface := range networkInterfaces {
+		networkInterfacesByID[strings.ToLower(networkInterface.ID)] = networkInterface
+	}
+
+	virtualMachines, err := a.client.VirtualMachines(subscriptionID)
+	if err != nil {
+		return fmt.Errorf("getSubscriptionResources: %s", err)
+	}
+	virtualMachinesByID := make(map[string]*wrapper.AzureResource)
+	for _, virtualMachine := range virtualMachines {
+		virtualMachinesByID[strings.ToLower(virtualMachine.ID)] = virtualMachine
+	}
+
+	for _, address := range addresses {
+		if address.IPAddress == "" {
+			continue
+		}
+
+		// Tags of the public IP address take precedence over those of the network interface and then of the
+		// virtual machine it is associated with.
+		tagSources := []map[string]string{address.Tags}
+		// The address is identified by the resource it is attached to, so it is tracked along with that resource.
+		name := address.Name
+		identity := address.ID
+		attachedType := ""
+		// The ID of a NAT gateway is the ID of the resource itself rather than of one of its IP configurations, which
+		// parentResource returns as it is.
+		attachedID := address.IPConfigurationID
+		if attachedID == "" {
+			attachedID = address.NATGatewayID
+		}
+		if attachedID != "" {
+			resourceType, resourceID, resourceName := parentResource(attachedID)
+			name = resourceName
+			identity = resourceID
+			attachedType = resourceType
+			if mapped, ok := attachedTypes[strings.ToLower(resourceType)]; ok {
+				attachedType = mapped
+			}
+
+			if networkInterface, ok := networkInterfacesByID[strings.ToLower(resourceID)]; ok {
+				tagSources = append(tagSources, networkInterface.Tags)
+				if virtualMachine, ok := virtualMachinesByID[strings.ToLower(networkInterface.VirtualMachineID)]; ok {
+					name = virtualMachine.Name
+					identity = virtualMachine.ID
+					attachedType = virtualMachineType
+					tagSources = append(tagSources, virtualMachine.Tags)
+				}
+			}
+		}
+
+		// Azure resource IDs are case insensitive.
+		newServer := server.Server{
+			Name:       name,
+			Address:    address.IPAddress,
+			Provider:   "azure",
+			ResourceID: strings.ToLower(identity),
+			Tags:       make(map[string]string),
+		}
+		for index := len(tagSources) - 1; index >= 0; index-- {
+			for key, value := range tagSources[index] {
+				newServer.Tags[key] = value
+			}
+		}
+		newServer.Tags[SubscriptionTag] = subscriptionID
+		newServer.Tags[ResourceGroupTag] = resourceGroup(address.ID)
+		newServer.Tags[LocationTag] = address.Location
+		newServer.Tags[PublicIPTag] = address.Name
+		if attachedType != "" {
+			newServer.Tags[AttachedTypeTag] = attachedType
+		}
+		serversMap[address.IPAddress] = newServer
+	}
+	return nil
+}
+
+// parentResource returns the type, ID and name of the resource a child resource ID, such as the ID of an IP
+// configuration, belongs to.
+func parentResource(id string) (string, string, string) {
+	// /subscriptions/ID/resourceGroups/GROUP/providers/NAMESPACE/TYPE/NAME/CHILD_TYPE/CHILD_NAME
+	segments := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if len(segments) < 8 || !strings.EqualFold(segments[4], "providers") {
+		return "", "", ""
+	}
+	return segments[5] + "/" + segments[6], "/" + strings.Join(segments[:8], "/"), segments[7]
+}
+
+// resourceGroup returns the resource group of a resource ID.
+func resourceGroup(id string) string {
+	segments := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	for index := 0; index+1 < len(segments); index++ {
+		if strings.EqualFold(segments[index], "resourceGroups") {
+			return segments[index+1]
+		}
+	}
+	return ""
+}
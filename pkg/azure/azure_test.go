@@ -0,0 +1,208 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/mocks"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const resourceGroupID = "/subscriptions/sub-1/resourceGroups/web"
+
+func publicIPAddress(name string, address string, ipConfigurationID string, tags map[string]string) *wrapper.AzurePublicIPAddress {
+	return &wrapper.AzurePublicIPAddress{
+		AzureResource: wrapper.AzureResource{
+			ID:       resourceGroupID + "/providers/Microsoft.Network/publicIPAddresses/" + name,
+			Name:     name,
+			Location: "eastus",
+			Tags:     tags,
+		},
+		IPAddress:         address,
+		IPConfigurationID: ipConfigurationID,
+	}
+}
+
+type instancesTestCase struct {
+	desc        string
+	setup       func(clientMock *mocks.AzureMock)
+	shouldError bool
+}
+
+func TestInstances(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	natAddress := publicIPAddress("nat-ip", "5.5.5.5", "", nil)
+	natAddress.NATGatewayID = resourceGroupID + "/providers/Microsoft.Network/natGateways/outbound"
+	addresses := []*wrapper.AzurePublicIPAddress{
+		publicIPAddress("vm-ip", "1.1.1.1",
+			resourceGroupID+"/providers/Microsoft.Network/networkInterfaces/vm-nic/ipConfigurations/ipconfig1",
+			map[string]string{"env": "prod"}),
+		publicIPAddress("nic-ip", "2.2.2.2",
+			resourceGroupID+"/providers/Microsoft.Network/networkInterfaces/spare-nic/ipConfigurations/ipconfig1", nil),
+		publicIPAddress("lb-ip", "3.3.3.3",
+			resourceGroupID+"/providers/Microsoft.Network/loadBalancers/web-lb/frontendIPConfigurations/frontend", nil),
+		publicIPAddress("reserved-ip", "4.4.4.4", "", nil),
+		publicIPAddress("dynamic-ip", "", "", nil),
+		natAddress,
+	}
+	networkInterfaces := []*wrapper.AzureNetworkInterface{
+		{
+			AzureResource: wrapper.AzureResource{
+				ID:   resourceGroupID + "/providers/Microsoft.Network/networkInterfaces/vm-nic",
+				Name: "vm-nic",
+				Tags: map[string]string{"env": "staging", "nic": "primary"},
+			},
+			VirtualMachineID: resourceGroupID + "/providers/Microsoft.Compute/virtualMachines/web-1",
+		},
+		{
+			AzureResource: wrapper.AzureResource{
+				ID:   resourceGroupID + "/providers/Microsoft.Network/networkInterfaces/spare-nic",
+				Name: "spare-nic",
+			},
+		},
+	}
+	virtualMachines := []*wrapper.AzureResource{
+		{
+			// Azure does not keep the case of resource IDs consistent across resources.
+			ID:   "/subscriptions/sub-1/resourcegroups/WEB/providers/Microsoft.Compute/virtualMachines/web-1",
+			Name: "web-1",
+			Tags: map[string]string{"team": "web", "nic": "vm"},
+		},
+	}
+
+	testCases := []instancesTestCase{
+		{
+			desc: "Able to get every allocated public IP address without error",
+			setup: func(clientMock *mocks.AzureMock) {
+				clientMock.On("PublicIPAddresses", "sub-1").Return(addresses, nil)
+				clientMock.On("NetworkInterfaces", "sub-1").Return(networkInterfaces, nil)
+				clientMock.On("VirtualMachines", "sub-1").Return(virtualMachines, nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "Error returned by public IP address retrieval",
+			setup: func(clientMock *mocks.AzureMock) {
+				clientMock.On("PublicIPAddresses", "sub-1").Return(nil, fmt.Errorf("AuthorizationFailed"))
+			},
+			shouldError: true,
+		},
+		{
+			desc: "Error returned by virtual machine retrieval",
+			setup: func(clientMock *mocks.AzureMock) {
+				clientMock.On("PublicIPAddresses", "sub-1").Return(addresses, nil)
+				clientMock.On("NetworkInterfaces", "sub-1").Return(networkInterfaces, nil)
+				clientMock.On("VirtualMachines", "sub-1").Return(nil, fmt.Errorf("AuthorizationFailed"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		clientMock := mocks.AzureMock{}
+		testCase.setup(&clientMock)
+		a := azureSvc{client: &clientMock, subscriptions: []string{"sub-1"}, workers: 1}
+		serversMap := make(map[string]server.Server)
+
+		err := a.Instances(serversMap)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+			var subscriptionErrors inventory.Errors
+			assert.True(t, errors.As(err, &subscriptionErrors))
+			assert.Equal(t, "sub-1", subscriptionErrors[0].Task)
+			continue
+		}
+		assert.NoError(t, err)
+
+		t.Logf("TestInstances: addresses are named after the resource they are associated with")
+		assert.Equal(t, 5, len(serversMap))
+		assert.Equal(t, "web-1", serversMap["1.1.1.1"].Name)
+		assert.Equal(t, "spare-nic", serversMap["2.2.2.2"].Name)
+		assert.Equal(t, "web-lb", serversMap["3.3.3.3"].Name)
+		assert.Equal(t, "reserved-ip", serversMap["4.4.4.4"].Name)
+		assert.Equal(t, "azure", serversMap["4.4.4.4"].Provider)
+		assert.Equal(t, "outbound", serversMap["5.5.5.5"].Name)
+
+		t.Logf("TestInstances: tags of the address take precedence over those of the network interface and virtual machine")
+		assert.Equal(t, map[string]string{
+			"env":            "prod",
+			"nic":            "primary",
+			"team":           "web",
+			SubscriptionTag:  "sub-1",
+			ResourceGroupTag: "web",
+			LocationTag:      "eastus",
+			PublicIPTag:      "vm-ip",
+			AttachedTypeTag:  "virtual-machine",
+		}, serversMap["1.1.1.1"].Tags)
+		assert.Equal(t, "network-interface", serversMap["2.2.2.2"].Tags[AttachedTypeTag])
+		assert.Equal(t, "load-balancer", serversMap["3.3.3.3"].Tags[AttachedTypeTag])
+		assert.NotContains(t, serversMap["4.4.4.4"].Tags, AttachedTypeTag)
+		assert.Equal(t, "nat-gateway", serversMap["5.5.5.5"].Tags[AttachedTypeTag])
+
+		t.Logf("TestInstances: addresses are identified by the resource they are associated with")
+		assert.Equal(t, "/subscriptions/sub-1/resourcegroups/web/providers/microsoft.compute/virtualmachines/web-1",
+			serversMap["1.1.1.1"].ResourceID)
+		assert.Equal(t, "/subscriptions/sub-1/resourcegroups/web/providers/microsoft.network/loadbalancers/web-lb",
+			serversMap["3.3.3.3"].ResourceID)
+		assert.Equal(t, "/subscriptions/sub-1/resourcegroups/web/providers/microsoft.network/publicipaddresses/reserved-ip",
+			serversMap["4.4.4.4"].ResourceID)
+		assert.Equal(t, "/subscriptions/sub-1/resourcegroups/web/providers/microsoft.network/natgateways/outbound",
+			serversMap["5.5.5.5"].ResourceID)
+	}
+}
+
+func TestInstancesSubscriptions(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	t.Logf("TestInstancesSubscriptions: every readable subscription is inventoried when none is configured")
+	clientMock := mocks.AzureMock{}
+	clientMock.On("Subscriptions", mock.Anything).Return([]string{"sub-1", "sub-2"}, nil)
+	clientMock.On("PublicIPAddresses", "sub-1").Return([]*wrapper.AzurePublicIPAddress{
+		publicIPAddress("ip-1", "1.1.1.1", "", nil),
+	}, nil)
+	clientMock.On("PublicIPAddresses", "sub-2").Return(nil, fmt.Errorf("AuthorizationFailed"))
+	clientMock.On("NetworkInterfaces", mock.Anything).Return(nil, nil)
+	clientMock.On("VirtualMachines", mock.Anything).Return(nil, nil)
+
+	a := azureSvc{client: &clientMock, workers: 2}
+	serversMap := make(map[string]server.Server)
+	err := a.Instances(serversMap)
+
+	var subscriptionErrors inventory.Errors
+	assert.True(t, errors.As(err, &subscriptionErrors))
+	assert.Equal(t, 1, len(subscriptionErrors))
+	assert.Equal(t, "sub-2", subscriptionErrors[0].Task)
+	assert.Contains(t, serversMap, "1.1.1.1")
+
+	t.Logf("TestInstancesSubscriptions: an error listing subscriptions aborts the inventory")
+	clientMock = mocks.AzureMock{}
+	clientMock.On("Subscriptions", mock.Anything).Return(nil, fmt.Errorf("AuthorizationFailed"))
+	a = azureSvc{client: &clientMock, workers: 2}
+	assert.Error(t, a.Instances(make(map[string]server.Server)))
+}
+
+func TestParentResource(t *testing.T) {
+	resourceType, resourceID, name := parentResource(
+		resourceGroupID + "/providers/Microsoft.Network/loadBalancers/web-lb/frontendIPConfigurations/frontend")
+	assert.Equal(t, "Microsoft.Network/loadBalancers", resourceType)
+	assert.Equal(t, resourceGroupID+"/providers/Microsoft.Network/loadBalancers/web-lb", resourceID)
+	assert.Equal(t, "web-lb", name)
+
+	resourceType, _, _ = parentResource("/subscriptions/sub-1")
+	assert.Equal(t, "", resourceType)
+	assert.Equal(t, "web", resourceGroup(resourceGroupID+"/providers/Microsoft.Network/publicIPAddresses/ip"))
+}
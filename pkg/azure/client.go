@@ -0,0 +1,172 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+)
+
+// azureClient lists resources through the Azure Resource Manager clients of the Azure SDK.
+type azureClient struct {
+	credential azcore.TokenCredential
+	// options are passed to every client. They are nil outside of tests, which point them at a test server.
+	options *arm.ClientOptions
+}
+
+func newAzureClient(azureConfig config.AzureConfig) (*azureClient, error) {
+	if azureConfig.TenantID == "" || azureConfig.ClientID == "" || azureConfig.ClientSecret == "" {
+		return nil, fmt.Errorf("newAzureClient: tenant ID, client ID and client secret cannot be empty")
+	}
+
+	credential, err := azidentity.NewClientSecretCredential(azureConfig.TenantID, azureConfig.ClientID,
+		azureConfig.ClientSecret, nil)
+	if err != nil {
+		return nil, fmt.Errorf("newAzureClient: Error creating credential %s", err)
+	}
+	return &azureClient{credential: credential}, nil
+}
+
+// Subscriptions returns the subscriptions that are neither disabled nor deleted.
+func (c *azureClient) Subscriptions() ([]string, error) {
+	client, err := armsubscriptions.NewClient(c.credential, c.options)
+	if err != nil {
+		return nil, fmt.Errorf("Subscriptions: Error creating client %s", err)
+	}
+
+	var subscriptions []string
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("Subscriptions: Error listing subscriptions %s", describeError(err))
+		}
+		for _, subscription := range page.Value {
+			if subscription.State != nil && (*subscription.State == armsubscriptions.SubscriptionStateDisabled ||
+				*subscription.State == armsubscriptions.SubscriptionStateDeleted) {
+				continue
+			}
+			subscriptions = append(subscriptions, stringValue(subscription.SubscriptionID))
+		}
+	}
+	return subscriptions, nil
+}
+
+func (c *azureClient) PublicIPAddresses(subscriptionID string) ([]*wrapper.AzurePublicIPAddress, error) {
+	client, err := armnetwork.NewPublicIPAddressesClient(subscriptionID, c.credential, c.options)
+	if err != nil {
+		return nil, fmt.Errorf("PublicIPAddresses: Error creating client %s", err)
+	}
+
+	var addresses []*wrapper.AzurePublicIPAddress
+	pager := client.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("PublicIPAddresses: Error listing public IP addresses %s", describeError(err))
+		}
+		for _, item := range page.Value {
+			address := &wrapper.AzurePublicIPAddress{
+				AzureResource: resource(item.ID, item.Name, item.Location, item.Tags),
+			}
+			if properties := item.Properties; properties != nil {
+				address.IPAddress = stringValue(properties.IPAddress)
+				if properties.IPConfiguration != nil {
+					address.IPConfigurationID = stringValue(properties.IPConfiguration.ID)
+				}
+				if properties.NatGateway != nil {
+					address.NATGatewayID = stringValue(properties.NatGateway.ID)
+				}
+			}
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+func (c *azureClient) NetworkInterfaces(subscriptionID string) ([]*wrapper.AzureNetworkInterface, error) {
+	client, err := armnetwork.NewInterfacesClient(subscriptionID, c.credential, c.options)
+	if err != nil {
+		return nil, fmt.Errorf("NetworkInterfaces: Error creating client %s", err)
+	}
+
+	var networkInterfaces []*wrapper.AzureNetworkInterface
+	pager := client.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("NetworkInterfaces: Error listing network interfaces %s", describeError(err))
+		}
+		for _, item := range page.Value {
+			networkInterface := &wrapper.AzureNetworkInterface{
+				AzureResource: resource(item.ID, item.Name, item.Location, item.Tags),
+			}
+			if item.Properties != nil && item.Properties.VirtualMachine != nil {
+				networkInterface.VirtualMachineID = stringValue(item.Properties.VirtualMachine.ID)
+			}
+			networkInterfaces = append(networkInterfaces, networkInterface)
+		}
+	}
+	return networkInterfaces, nil
+}
+
+func (c *azureClient) VirtualMachines(subscriptionID string) ([]*wrapper.AzureResource, error) {
+	client, err := armcompute.NewVirtualMachinesClient(subscriptionID, c.credential, c.options)
+	if err != nil {
+		return nil, fmt.Errorf("VirtualMachines: Error creating client %s", err)
+	}
+
+	var virtualMachines []*wrapper.AzureResource
+	pager := client.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("VirtualMachines: Error listing virtual machines %s", describeError(err))
+		}
+		for _, item := range page.Value {
+			virtualMachine := resource(item.ID, item.Name, item.Location, item.Tags)
+			virtualMachines = append(virtualMachines, &virtualMachine)
+		}
+	}
+	return virtualMachines, nil
+}
+
+func resource(id *string, name *string, location *string, tags map[string]*string) wrapper.AzureResource {
+	r := wrapper.AzureResource{
+		ID:       stringValue(id),
+		Name:     stringValue(name),
+		Location: stringValue(location),
+	}
+	if len(tags) > 0 {
+		r.Tags = make(map[string]string)
+		for key, value := range tags {
+			r.Tags[key] = stringValue(value)
+		}
+	}
+	return r
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// describeError returns the code and status of the errors returned by Azure, whose messages span several lines with
+// the whole response.
+func describeError(err error) string {
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		return fmt.Sprintf("%s (%d)", responseError.ErrorCode, responseError.StatusCode)
+	}
+	return err.Error()
+}
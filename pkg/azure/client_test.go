@@ -0,0 +1,110 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Invoca/nmap-diff/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticCredential hands out a fixed token so the clients can be pointed at a test server.
+type staticCredential struct{}
+
+func (staticCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestClientList(t *testing.T) {
+	var testServer *httptest.Server
+	var requests []string
+	testServer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?page="+r.URL.Query().Get("page"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/subscriptions/sub-1/providers/Microsoft.Network/publicIPAddresses":
+			if r.URL.Query().Get("page") == "" {
+				w.Write([]byte(`{"value": [{"id": "/subscriptions/sub-1/resourceGroups/web/providers/Microsoft.Network/publicIPAddresses/ip-1",
+					"name": "ip-1", "location": "eastus", "tags": {"env": "prod"},
+					"properties": {"ipAddress": "1.1.1.1", "ipConfiguration": {"id": "/nic/ipconfig"}}}],
+					"nextLink": "` + testServer.URL + r.URL.Path + `?page=2"}`))
+				return
+			}
+			w.Write([]byte(`{"value": [{"name": "ip-2", "properties": {"ipAddress": "2.2.2.2", "natGateway": {"id": "/nat"}}},
+				{"name": "ip-3"}]}`))
+		case "/subscriptions/sub-1/providers/Microsoft.Network/networkInterfaces":
+			w.Write([]byte(`{"value": [{"id": "/nic", "name": "nic", "properties": {"virtualMachine": {"id": "/vm"}}}]}`))
+		case "/subscriptions":
+			w.Write([]byte(`{"value": [{"subscriptionId": "sub-1", "state": "Enabled"},
+				{"subscriptionId": "sub-2", "state": "Disabled"}, {"subscriptionId": "sub-3", "state": "PastDue"}]}`))
+		default:
+			w.Header().Set("x-ms-error-code", "AuthorizationFailed")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": {"code": "AuthorizationFailed", "message": "no access"}}`))
+		}
+	}))
+	defer testServer.Close()
+
+	client := azureClient{
+		credential: staticCredential{},
+		options: &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+			Cloud: cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Audience: "https://management.azure.com", Endpoint: testServer.URL},
+			}},
+			Transport: testServer.Client(),
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		}},
+	}
+
+	t.Logf("TestClientList: every page is listed")
+	addresses, err := client.PublicIPAddresses("sub-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/subscriptions/sub-1/providers/Microsoft.Network/publicIPAddresses?page=",
+		"/subscriptions/sub-1/providers/Microsoft.Network/publicIPAddresses?page=2",
+	}, requests)
+	assert.Equal(t, 3, len(addresses))
+	assert.Equal(t, "1.1.1.1", addresses[0].IPAddress)
+	assert.Equal(t, "/nic/ipconfig", addresses[0].IPConfigurationID)
+	assert.Equal(t, map[string]string{"env": "prod"}, addresses[0].Tags)
+	assert.Equal(t, "ip-2", addresses[1].Name)
+	assert.Equal(t, "", addresses[1].IPConfigurationID)
+
+	t.Logf("TestClientList: the NAT gateway of an address is recorded")
+	assert.Equal(t, "/nat", addresses[1].NATGatewayID)
+	assert.Equal(t, "", addresses[2].IPAddress)
+
+	t.Logf("TestClientList: the virtual machine of a network interface is recorded")
+	networkInterfaces, err := client.NetworkInterfaces("sub-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(networkInterfaces))
+	assert.Equal(t, "/vm", networkInterfaces[0].VirtualMachineID)
+
+	t.Logf("TestClientList: disabled subscriptions are skipped")
+	subscriptions, err := client.Subscriptions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sub-1", "sub-3"}, subscriptions)
+
+	t.Logf("TestClientList: the error returned by Azure is reported")
+	_, err = client.VirtualMachines("sub-1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "AuthorizationFailed (403)")
+}
+
+func TestNewAzureClient(t *testing.T) {
+	_, err := newAzureClient(config.AzureConfig{TenantID: "tenant", ClientID: "client"})
+	assert.Error(t, err)
+
+	client, err := newAzureClient(config.AzureConfig{TenantID: "tenant", ClientID: "client", ClientSecret: "secret"})
+	assert.NoError(t, err)
+	assert.NotNil(t, client.credential)
+	assert.Nil(t, client.options)
+}
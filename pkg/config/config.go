@@ -1,19 +1,274 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
 type BaseConfig struct {
-	IncludeAWS       bool
+	IncludeAWS bool
+	// AWSConfig selects the AWS resources that are inventoried. Every resource is inventoried when it is nil.
+	AWSConfig *AWSConfig
+	// BucketName is the S3 bucket scan results are stored in when StorageConfig is not set.
 	BucketName       string
 	PreviousFileName string
-	IncludeGCloud    bool
-	GCloudConfig     *GCloudConfig
-	SlackConfig      *SlackConfig
+	// StorageConfig selects where scan results are stored.
+	StorageConfig *StorageConfig
+	// HistoryConfig keeps every scan in the storage on top of the latest one in PreviousFileName when set.
+	HistoryConfig *HistoryConfig
+	IncludeGCloud bool
+	GCloudConfig  *GCloudConfig
+	IncludeAzure  bool
+	AzureConfig   *AzureConfig
+	// IncludeKubernetes inventories the services and ingresses of the clusters selected by KubernetesConfig.
+	IncludeKubernetes bool
+	KubernetesConfig  *KubernetesConfig
+	// StaticConfig adds the targets listed in files to the inventory when it lists some.
+	StaticConfig *StaticConfig
+	// TerraformConfig adds the public addresses of the resources of Terraform state files to the inventory when it lists
+	// some.
+	TerraformConfig *TerraformConfig
+	// DNSConfig adds the addresses the records of DNS zones point at to the inventory when it lists some zones.
+	DNSConfig *DNSConfig
+	// InventoryWorkers is the number of AWS regions, GCloud projects, Azure subscriptions and Kubernetes clusters
+	// inventoried at the same time. Defaults to DefaultInventoryWorkers when zero.
+	InventoryWorkers int
+	// InventoryErrorPolicy selects what happens when a region or zone cannot be inventoried: InventoryFailFast (the
+	// default) or InventoryBestEffort.
+	InventoryErrorPolicy string
+	// SlackConfig registers a slack notifier when SlackURL is set. More notifiers can be registered through Notifiers.
+	SlackConfig  *SlackConfig
+	Notifiers    []*NotifierConfig
+	ScanProfile  string
+	ScanProfiles map[string]*ScanProfile
+	// NotifyTransitions holds "previous->current" port state patterns, such as "closed->filtered" or "*->open|filtered",
+	// for the state transitions that should be notified on top of opened and closed ports.
+	NotifyTransitions []string
+	// BaselineNotification selects what is notified when no previous scan exists and the current scan becomes the
+	// baseline: BaselineNotifyNone (the default) or BaselineNotifySummary.
+	BaselineNotification string
+}
+
+// DefaultInventoryWorkers is the number of regions or projects inventoried at the same time unless configured.
+const DefaultInventoryWorkers = 8
+
+// InventoryWorkerCount returns the number of regions or projects to inventory at the same time.
+func (c BaseConfig) InventoryWorkerCount() int {
+	if c.InventoryWorkers > 0 {
+		return c.InventoryWorkers
+	}
+	return DefaultInventoryWorkers
+}
+
+// The policies for regions and zones that cannot be inventoried. InventoryFailFast aborts the run, while
+// InventoryBestEffort scans whatever was inventoried and reports the errors along with the changes.
+const (
+	InventoryFailFast   = "fail-fast"
+	InventoryBestEffort = "best-effort"
+)
+
+// ValidateInventoryErrorPolicy checks that InventoryErrorPolicy is empty or one of the known policies.
+func (c BaseConfig) ValidateInventoryErrorPolicy() error {
+	switch c.InventoryErrorPolicy {
+	case "", InventoryFailFast, InventoryBestEffort:
+		return nil
+	default:
+		return fmt.Errorf("ValidateInventoryErrorPolicy: unknown inventory error policy %s", c.InventoryErrorPolicy)
+	}
+}
+
+// The AWS resources that can be inventoried.
+const (
+	AWSInstances     = "instances"
+	AWSLoadBalancers = "load-balancers"
+	AWSElasticIPs    = "elastic-ips"
+	AWSNatGateways   = "nat-gateways"
+)
+
+type AWSConfig struct {
+	// Resources lists the resources to inventory. Every resource is inventoried when it is empty.
+	Resources []string
+	// Accounts lists the accounts to inventory. The account of the default credentials, or of the role in the
+	// ROLE_ARN environment variable, is inventoried when it is empty.
+	Accounts []*AWSAccount
+	// Regions and ExcludeRegions hold region names or patterns such as "ap-*". Every region enabled in an account is
+	// inventoried unless Regions is set, in which case only matching regions are, and minus those matching
+	// ExcludeRegions.
+	Regions        []string
+	ExcludeRegions []string
+}
+
+// AWSAccount is an account inventoried by assuming RoleARN.
+type AWSAccount struct {
+	RoleARN    string `json:"roleArn"`
+	ExternalID string `json:"externalId,omitempty"`
+	// Regions limits the inventory to the listed regions, in place of AWSConfig.Regions. ExcludeRegions skips regions
+	// on top of AWSConfig.ExcludeRegions.
+	Regions        []string `json:"regions,omitempty"`
+	ExcludeRegions []string `json:"excludeRegions,omitempty"`
+}
+
+// LoadAWSAccounts reads a JSON list of accounts from path.
+func LoadAWSAccounts(path string) ([]*AWSAccount, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadAWSAccounts: Error reading file %s", err)
+	}
+
+	var accounts []*AWSAccount
+	err = json.Unmarshal(fileBytes, &accounts)
+	if err != nil {
+		return nil, fmt.Errorf("LoadAWSAccounts: Error parsing file %s", err)
+	}
+
+	for index, account := range accounts {
+		if account == nil || account.RoleARN == "" {
+			return nil, fmt.Errorf("LoadAWSAccounts: account %d has no roleArn", index)
+		}
+	}
+	return accounts, nil
 }
 
+// The GCloud resources that can be inventoried.
+const (
+	GCloudInstances       = "instances"
+	GCloudForwardingRules = "forwarding-rules"
+	GCloudAddresses       = "addresses"
+)
+
 type GCloudConfig struct {
 	ServiceAccountPath string
-	ProjectName        string
+	// ProjectName, Projects and the projects found under Parent, such as "folders/123" or "organizations/456", are
+	// inventoried.
+	ProjectName string
+	Projects    []string
+	Parent      string
+	// Resources lists the resources to inventory. Every resource is inventoried when it is empty.
+	Resources []string
+}
+
+type AzureConfig struct {
+	// TenantID, ClientID and ClientSecret identify the service principal the inventory runs as.
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// Subscriptions lists the subscriptions to inventory. Every subscription the service principal can read is
+	// inventoried when it is empty.
+	Subscriptions []string
+}
+
+type KubernetesConfig struct {
+	// Kubeconfig is the path of the kubeconfig file. The file is found the same way kubectl does when it is empty.
+	Kubeconfig string
+	// Contexts lists the kubeconfig contexts of the clusters to inventory. The current context is inventoried when it is
+	// empty.
+	Contexts []string
+}
+
+type StaticConfig struct {
+	// Files lists YAML, JSON or CSV files of targets, told apart by their extension.
+	Files []string
+}
+
+type TerraformConfig struct {
+	// StateFiles lists local paths of state files, or s3://bucket/key and gs://bucket/key URLs of state files kept in a
+	// Terraform backend.
+	StateFiles []string
+}
+
+type DNSConfig struct {
+	// ZoneFiles lists zone files in the BIND format. Names that are not fully qualified are relative to their $ORIGIN.
+	ZoneFiles []string
+	// Route53Zones lists the IDs of Route53 hosted zones, read with the default AWS credentials.
+	Route53Zones []string
+	// CloudDNSZones lists Cloud DNS managed zones as project/zone, read with the GCloud service account.
+	CloudDNSZones []string
 }
 
 type SlackConfig struct {
 	SlackURL string
+	// Digest posts a single message per run summarizing every change instead of one message per port.
+	Digest bool
+	// GroupBy groups the digest by "change" (the default), "provider" or "tag:<key>".
+	GroupBy string
+	// Token and Channel post the digest through the Slack Web API instead of SlackURL, so digests too large for a
+	// single message continue in a thread.
+	Token   string
+	Channel string
+}
+
+// The storage types scan results can be stored in.
+const (
+	S3Storage    = "s3"
+	LocalStorage = "local"
+	GCSStorage   = "gcs"
+)
+
+// StorageConfig selects the backend scan results are stored in. Bucket is used by the s3 and gcs types and Directory
+// by the local type.
+type StorageConfig struct {
+	Type      string
+	Bucket    string
+	Directory string
+	// ServiceAccountPath is the credentials file used by the gcs type. Uses default if empty.
+	ServiceAccountPath string
+}
+
+// HistoryConfig stores every scan under Prefix along with an index of the scans.
+type HistoryConfig struct {
+	Prefix string
+	// KeepScans and KeepDays remove the oldest scans past the given count or age. Zero keeps every scan.
+	KeepScans int
+	KeepDays  int
+	// CompareTo diffs against the scan with this ID, or the latest scan taken at or before this RFC 3339 time,
+	// instead of the latest scan.
+	CompareTo string
+}
+
+// The notifier types that can be registered.
+const (
+	SlackNotifier   = "slack"
+	WebhookNotifier = "webhook"
+	LogNotifier     = "log"
+)
+
+// NotifierConfig registers a sink for the result of each run. Type selects the implementation, which reads its
+// settings from the matching config field.
+type NotifierConfig struct {
+	Type          string
+	SlackConfig   *SlackConfig
+	WebhookConfig *WebhookConfig
+}
+
+type WebhookConfig struct {
+	URL string
+}
+
+// The notifications that can be sent when a baseline is established.
+const (
+	BaselineNotifyNone    = "none"
+	BaselineNotifySummary = "summary"
+)
+
+// ValidateBaselineNotification checks that BaselineNotification is empty or one of the known notifications.
+func (c BaseConfig) ValidateBaselineNotification() error {
+	switch c.BaselineNotification {
+	case "", BaselineNotifyNone, BaselineNotifySummary:
+		return nil
+	default:
+		return fmt.Errorf("ValidateBaselineNotification: unknown baseline notification %s", c.BaselineNotification)
+	}
+}
+
+// ValidateNotifyTransitions checks that every transition pattern is in the "previous->current" form.
+func (c BaseConfig) ValidateNotifyTransitions() error {
+	for _, pattern := range c.NotifyTransitions {
+		parts := strings.SplitN(pattern, "->", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("ValidateNotifyTransitions: invalid transition pattern %s", pattern)
+		}
+	}
+	return nil
 }
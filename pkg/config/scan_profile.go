@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const DefaultScanProfileName = "default"
+
+// ScanProfile holds the nmap options used for a scan. Unset fields leave the nmap default in place.
+type ScanProfile struct {
+	// Ports is a port range in nmap syntax, e.g. "22,80,443" or "1-65535". Takes precedence over TopPorts.
+	Ports string `json:"ports,omitempty"`
+	// TopPorts scans the given number of most common ports.
+	TopPorts int `json:"topPorts,omitempty"`
+	// TimingTemplate is the nmap timing template between 0 (paranoid) and 5 (insane).
+	TimingTemplate *int `json:"timingTemplate,omitempty"`
+	// UDP adds a UDP scan alongside the TCP scan. UDP scans require nmap to run privileged.
+	UDP            bool     `json:"udp,omitempty"`
+	MinRate        int      `json:"minRate,omitempty"`
+	MaxRetries     *int     `json:"maxRetries,omitempty"`
+	ServiceVersion bool     `json:"serviceVersion,omitempty"`
+	ExtraArgs      []string `json:"extraArgs,omitempty"`
+}
+
+func intPointer(i int) *int {
+	return &i
+}
+
+// DefaultScanProfiles returns the scan profiles that are always available. The default profile matches the options
+// nmap-diff has always scanned with.
+func DefaultScanProfiles() map[string]*ScanProfile {
+	return map[string]*ScanProfile{
+		DefaultScanProfileName: {},
+		"quick": {
+			TopPorts:       100,
+			TimingTemplate: intPointer(4),
+		},
+		"full": {
+			Ports:          "1-65535",
+			TimingTemplate: intPointer(4),
+			MinRate:        1000,
+			MaxRetries:     intPointer(2),
+		},
+		"udp": {
+			TopPorts:       100,
+			TimingTemplate: intPointer(4),
+			UDP:            true,
+		},
+		"version": {
+			TimingTemplate: intPointer(4),
+			ServiceVersion: true,
+		},
+	}
+}
+
+// SelectedScanProfile returns the profile named by ScanProfile. Profiles in ScanProfiles take precedence over the
+// default profiles of the same name.
+func (c BaseConfig) SelectedScanProfile() (*ScanProfile, error) {
+	name := c.ScanProfile
+	if name == "" {
+		name = DefaultScanProfileName
+	}
+
+	if profile, ok := c.ScanProfiles[name]; ok && profile != nil {
+		return profile, nil
+	}
+
+	if profile, ok := DefaultScanProfiles()[name]; ok {
+		return profile, nil
+	}
+	return nil, fmt.Errorf("SelectedScanProfile: unknown scan profile %s", name)
+}
+
+// Validate checks that the profile only holds values nmap accepts.
+func (p *ScanProfile) Validate() error {
+	if p.TopPorts < 0 {
+		return fmt.Errorf("Validate: topPorts cannot be negative")
+	}
+	if p.TimingTemplate != nil && (*p.TimingTemplate < 0 || *p.TimingTemplate > 5) {
+		return fmt.Errorf("Validate: timingTemplate must be between 0 and 5")
+	}
+	if p.MinRate < 0 {
+		return fmt.Errorf("Validate: minRate cannot be negative")
+	}
+	if p.MaxRetries != nil && *p.MaxRetries < 0 {
+		return fmt.Errorf("Validate: maxRetries cannot be negative")
+	}
+	return nil
+}
+
+// LoadScanProfiles reads named scan profiles from a JSON file in the same format accepted by the server.
+func LoadScanProfiles(path string) (map[string]*ScanProfile, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadScanProfiles: Error reading file %s", err)
+	}
+
+	profiles := make(map[string]*ScanProfile)
+	err = json.Unmarshal(fileBytes, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("LoadScanProfiles: Error parsing file %s", err)
+	}
+	return profiles, nil
+}
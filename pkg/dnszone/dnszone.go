@@ -0,0 +1,388 @@
+package dnszone
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	clouddns "google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// ZoneTag holds the comma separated zones the records of an address are found in.
+	ZoneTag = "dnsZone"
+	// HostnamesTag holds the comma separated hostnames pointing at an address. The first of them names the addresses
+	// no other provider inventoried.
+	HostnamesTag = "dnsNames"
+	// OutsideInventoryTag is set to "true" on addresses that no other provider inventoried.
+	OutsideInventoryTag = "outsideInventory"
+
+	// maxCNAMEDepth bounds the CNAME records followed from a hostname, which stops loops between records.
+	maxCNAMEDepth = 8
+)
+
+// The record types that are turned into targets.
+const (
+	recordA     = "A"
+	recordAAAA  = "AAAA"
+	recordCNAME = "CNAME"
+)
+
+// record is an A, AAAA or CNAME record. name is a lowercase fully qualified hostname without the trailing dot, and
+// value is an address or, for CNAME records, a hostname in the same form.
+type record struct {
+	name       string
+	recordType string
+	value      string
+}
+
+// zoneSource lists the records of a zone. name identifies the zone in tags and inventory errors.
+type zoneSource interface {
+	name() string
+	records() ([]record, error)
+}
+
+type dnsZoneSvc struct {
+	sources []zoneSource
+	// lookupHost resolves the CNAME targets that are not part of the zones, such as those of cloud load balancers.
+	lookupHost func(host string) ([]string, error)
+}
+
+// New returns a service inventorying the addresses the records of the configured zone files, Route53 hosted zones
+// and Cloud DNS managed zones point at. Route53 is read with the default AWS credentials and Cloud DNS with the GCloud
+// service account.
+func New(configObject config.BaseConfig) (*dnsZoneSvc, error) {
+	dnsConfig := configObject.DNSConfig
+	if dnsConfig == nil || len(dnsConfig.ZoneFiles)+len(dnsConfig.Route53Zones)+len(dnsConfig.CloudDNSZones) == 0 {
+		return nil, fmt.Errorf("New: no DNS zone is configured")
+	}
+
+	d := dnsZoneSvc{lookupHost: net.LookupHost}
+	for _, path := range dnsConfig.ZoneFiles {
+		d.sources = append(d.sources, &zoneFile{path: path})
+	}
+
+	if len(dnsConfig.Route53Zones) > 0 {
+		route53Svc := route53.New(session.Must(session.NewSession()), aws.NewConfig().WithMaxRetries(10))
+		for _, zoneID := range dnsConfig.Route53Zones {
+			d.sources = append(d.sources, &route53Zone{route53Svc: route53Svc, zoneID: zoneID})
+		}
+	}
+
+	if len(dnsConfig.CloudDNSZones) > 0 {
+		var options []option.ClientOption
+		if configObject.GCloudConfig != nil && configObject.GCloudConfig.ServiceAccountPath != "" {
+			options = append(options, option.WithCredentialsFile(configObject.GCloudConfig.ServiceAccountPath))
+		}
+		dnsService, err := clouddns.NewService(context.Background(), options...)
+		if err != nil {
+			return nil, fmt.Errorf("New: Error creating dns.Service object %s", err)
+		}
+
+		for _, zone := range dnsConfig.CloudDNSZones {
+			parts := strings.SplitN(zone, "/", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("New: invalid Cloud DNS zone %s, expected project/zone", zone)
+			}
+			d.sources = append(d.sources, &cloudDNSZone{dnsService: dnsService, project: parts[0], zone: parts[1]})
+		}
+	}
+	return &d, nil
+}
+
+// Instances records every address the A, AAAA and CNAME records of the zones point at, tagged with the hostnames
+// pointing at it. Addresses already in serversMap keep the name, provider and tags they were inventoried with, while
+// the others are recorded as dns servers named after their first hostname and tagged with OutsideInventoryTag. The zones are read on every inventory, so it has
+// to run after the other providers. Zones that could not be read and hostnames that could not be resolved are
+// returned as inventory.Errors after the other addresses are recorded.
+func (d *dnsZoneSvc) Instances(serversMap map[string]server.Server) error {
+	var errs inventory.Errors
+	var records []record
+	zones := make(map[record]string)
+	for _, source := range d.sources {
+		log.Debug("Reading DNS zone " + source.name())
+		sourceRecords, err := source.records()
+		if err != nil {
+			errs = append(errs, &inventory.TaskError{Task: source.name(), Err: err})
+			continue
+		}
+		for _, r := range sourceRecords {
+			if _, ok := zones[r]; !ok {
+				zones[r] = source.name()
+				records = append(records, r)
+			}
+		}
+	}
+
+	hostnames, hostErrors := d.resolve(records)
+	errs = append(errs, hostErrors...)
+
+	for address, names := range hostnames {
+		addServer(address, names, zones, serversMap)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Instances: Error reading DNS zones %w", errs)
+	}
+	return nil
+}
+
+// resolvedName is a hostname pointing at an address through the record.
+type resolvedName struct {
+	hostname string
+	record   record
+}
+
+// resolve returns the hostnames pointing at every address, following CNAME records through the zones and resolving
+// the targets outside of them.
+func (d *dnsZoneSvc) resolve(records []record) (map[string][]resolvedName, inventory.Errors) {
+	byName := make(map[string][]record)
+	for _, r := range records {
+		byName[r.name] = append(byName[r.name], r)
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs inventory.Errors
+	hostnames := make(map[string][]resolvedName)
+	for _, name := range names {
+		for _, r := range byName[name] {
+			addresses, err := d.follow(r, byName, 0)
+			if err != nil {
+				errs = append(errs, &inventory.TaskError{Task: name, Err: err})
+				continue
+			}
+			for _, address := range addresses {
+				hostnames[address] = append(hostnames[address], resolvedName{hostname: name, record: r})
+			}
+		}
+	}
+	return hostnames, errs
+}
+
+// follow returns the addresses the record points at.
+func (d *dnsZoneSvc) follow(r record, byName map[string][]record, depth int) ([]string, error) {
+	if r.recordType != recordCNAME {
+		ip := net.ParseIP(r.value)
+		if ip == nil {
+			return nil, fmt.Errorf("follow: %s record of %s holds an invalid address %s", r.recordType, r.name, r.value)
+		}
+		return []string{ip.String()}, nil
+	}
+
+	if depth >= maxCNAMEDepth {
+		return nil, fmt.Errorf("follow: more than %d CNAME records followed from %s", maxCNAMEDepth, r.name)
+	}
+
+	targets, ok := byName[r.value]
+	if !ok {
+		addresses, err := d.lookupHost(r.value)
+		if err != nil {
+			return nil, fmt.Errorf("follow: Error resolving %s %s", r.value, err)
+		}
+		return addresses, nil
+	}
+
+	var addresses []string
+	for _, target := range targets {
+		targetAddresses, err := d.follow(target, byName, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, targetAddresses...)
+	}
+	return addresses, nil
+}
+
+// addServer tags the address with the hostnames pointing at it. Addresses no other provider inventoried are recorded
+// under the first of them.
+func addServer(address string, names []resolvedName, zones map[record]string, serversMap map[string]server.Server) {
+	var hostnames, zoneNames []string
+	seenHostnames := make(map[string]bool)
+	seenZones := make(map[string]bool)
+	for _, name := range names {
+		if !seenHostnames[name.hostname] {
+			seenHostnames[name.hostname] = true
+			hostnames = append(hostnames, name.hostname)
+		}
+		if zone := zones[name.record]; !seenZones[zone] {
+			seenZones[zone] = true
+			zoneNames = append(zoneNames, zone)
+		}
+	}
+
+	newServer, known := serversMap[address]
+	if known {
+		tags := make(map[string]string)
+		for key, value := range newServer.Tags {
+			tags[key] = value
+		}
+		newServer.Tags = tags
+	} else {
+		log.WithField("address", address).Info("DNS record " + hostnames[0] + " points outside of the inventory")
+		newServer = server.Server{
+			Name:     hostnames[0],
+			Address:  address,
+			Provider: "dns",
+			Tags:     map[string]string{OutsideInventoryTag: "true"},
+		}
+		// Zones and hostnames are reported when they cannot be read or resolved, so they are the scopes of the
+		// addresses only found through them.
+		newServer.Scopes = append(append([]string{}, zoneNames...), hostnames...)
+	}
+
+	newServer.Tags[HostnamesTag] = strings.Join(hostnames, ",")
+	newServer.Tags[ZoneTag] = strings.Join(zoneNames, ",")
+	serversMap[address] = newServer
+}
+
+// normalizeName returns the hostname in lowercase without the trailing dot.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// zoneFile is a zone file in the BIND format. Names that are not fully qualified are relative to its $ORIGIN.
+type zoneFile struct {
+	path string
+}
+
+func (z *zoneFile) name() string {
+	return z.path
+}
+
+func (z *zoneFile) records() ([]record, error) {
+	file, err := os.Open(z.path)
+	if err != nil {
+		return nil, fmt.Errorf("records: Error opening zone file %s", err)
+	}
+	defer file.Close()
+
+	var records []record
+	parser := dns.NewZoneParser(file, "", z.path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		name := normalizeName(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.A:
+			records = append(records, record{name: name, recordType: recordA, value: rr.A.String()})
+		case *dns.AAAA:
+			records = append(records, record{name: name, recordType: recordAAAA, value: rr.AAAA.String()})
+		case *dns.CNAME:
+			records = append(records, record{name: name, recordType: recordCNAME, value: normalizeName(rr.Target)})
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("records: Error parsing zone file %s", err)
+	}
+	return records, nil
+}
+
+// route53Zone is a Route53 hosted zone.
+type route53Zone struct {
+	route53Svc route53iface.Route53API
+	zoneID     string
+}
+
+func (z *route53Zone) name() string {
+	return "route53/" + z.zoneID
+}
+
+// records lists the record sets of the zone. Alias records are followed like CNAME records to their target, such as
+// a load balancer or a CloudFront distribution.
+func (z *route53Zone) records() ([]record, error) {
+	if z.route53Svc == nil {
+		return nil, fmt.Errorf("records: route53Svc is nil")
+	}
+
+	var records []record
+	err := z.route53Svc.ListResourceRecordSetsPages(&route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(z.zoneID)},
+		func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			for _, recordSet := range page.ResourceRecordSets {
+				recordType := aws.StringValue(recordSet.Type)
+				if recordType != recordA && recordType != recordAAAA && recordType != recordCNAME {
+					continue
+				}
+
+				// Route53 escapes the * of wildcard records.
+				name := normalizeName(strings.Replace(aws.StringValue(recordSet.Name), `\052`, "*", -1))
+				if recordSet.AliasTarget != nil {
+					records = append(records, record{name: name, recordType: recordCNAME,
+						value: normalizeName(aws.StringValue(recordSet.AliasTarget.DNSName))})
+					continue
+				}
+				for _, resourceRecord := range recordSet.ResourceRecords {
+					value := aws.StringValue(resourceRecord.Value)
+					if recordType == recordCNAME {
+						value = normalizeName(value)
+					}
+					records = append(records, record{name: name, recordType: recordType, value: value})
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("records: Error listing record sets of %s %s", z.zoneID, err)
+	}
+	return records, nil
+}
+
+// cloudDNSZone is a Cloud DNS managed zone.
+type cloudDNSZone struct {
+	dnsService *clouddns.Service
+	project    string
+	zone       string
+}
+
+func (z *cloudDNSZone) name() string {
+	return z.project + "/" + z.zone
+}
+
+func (z *cloudDNSZone) records() ([]record, error) {
+	if z.dnsService == nil {
+		return nil, fmt.Errorf("records: dnsService is nil")
+	}
+
+	var recordSets []*clouddns.ResourceRecordSet
+	err := z.dnsService.ResourceRecordSets.List(z.project, z.zone).Pages(context.Background(),
+		func(page *clouddns.ResourceRecordSetsListResponse) error {
+			recordSets = append(recordSets, page.Rrsets...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("records: Error listing record sets of %s %s", z.name(), err)
+	}
+	return cloudDNSRecords(recordSets), nil
+}
+
+// cloudDNSRecords returns the A, AAAA and CNAME records of the record sets.
+func cloudDNSRecords(recordSets []*clouddns.ResourceRecordSet) []record {
+	var records []record
+	for _, recordSet := range recordSets {
+		if recordSet.Type != recordA && recordSet.Type != recordAAAA && recordSet.Type != recordCNAME {
+			continue
+		}
+		for _, value := range recordSet.Rrdatas {
+			if recordSet.Type == recordCNAME {
+				value = normalizeName(value)
+			}
+			records = append(records, record{name: normalizeName(recordSet.Name), recordType: recordSet.Type, value: value})
+		}
+	}
+	return records
+}
@@ -0,0 +1,217 @@
+package dnszone
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	clouddns "google.golang.org/api/dns/v1"
+)
+
+const zoneFileContent = `$ORIGIN example.com.
+$TTL 300
+@       IN SOA ns1 hostmaster 1 7200 3600 1209600 300
+@       IN NS  ns1
+@       IN A   1.1.1.1
+WWW     IN CNAME @
+api     IN AAAA 2001:db8::1
+shop    IN CNAME shop.elb.amazonaws.com.
+mail    IN MX  10 mx.example.net.
+`
+
+// sourceMock is a zone returning fixed records.
+type sourceMock struct {
+	zone    string
+	zoneRRs []record
+	err     error
+}
+
+func (s *sourceMock) name() string {
+	return s.zone
+}
+
+func (s *sourceMock) records() ([]record, error) {
+	return s.zoneRRs, s.err
+}
+
+type route53Mock struct {
+	route53iface.Route53API
+	pages []*route53.ListResourceRecordSetsOutput
+	err   error
+}
+
+func (r *route53Mock) ListResourceRecordSetsPages(input *route53.ListResourceRecordSetsInput,
+	fn func(*route53.ListResourceRecordSetsOutput, bool) bool) error {
+	for index, page := range r.pages {
+		if !fn(page, index == len(r.pages)-1) {
+			break
+		}
+	}
+	return r.err
+}
+
+func lookupHost(host string) ([]string, error) {
+	if host == "shop.elb.amazonaws.com" {
+		return []string{"5.5.5.5", "6.6.6.6"}, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(config.BaseConfig{})
+	assert.Error(t, err)
+
+	_, err = New(config.BaseConfig{DNSConfig: &config.DNSConfig{}})
+	assert.Error(t, err)
+
+	_, err = New(config.BaseConfig{DNSConfig: &config.DNSConfig{CloudDNSZones: []string{"no-zone"}}})
+	assert.Error(t, err)
+
+	d, err := New(config.BaseConfig{DNSConfig: &config.DNSConfig{ZoneFiles: []string{"example.com.zone"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []zoneSource{&zoneFile{path: "example.com.zone"}}, d.sources)
+}
+
+func TestZoneFileRecords(t *testing.T) {
+	directory, err := ioutil.TempDir("", "nmap-diff-dnszone")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "example.com.zone")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(zoneFileContent), 0644))
+
+	records, err := (&zoneFile{path: path}).records()
+	assert.NoError(t, err)
+	assert.Equal(t, []record{
+		{name: "example.com", recordType: recordA, value: "1.1.1.1"},
+		{name: "www.example.com", recordType: recordCNAME, value: "example.com"},
+		{name: "api.example.com", recordType: recordAAAA, value: "2001:db8::1"},
+		{name: "shop.example.com", recordType: recordCNAME, value: "shop.elb.amazonaws.com"},
+	}, records)
+
+	invalidPath := filepath.Join(directory, "invalid.zone")
+	assert.NoError(t, ioutil.WriteFile(invalidPath, []byte("www IN A not-an-address\n"), 0644))
+	_, err = (&zoneFile{path: invalidPath}).records()
+	assert.Error(t, err)
+
+	_, err = (&zoneFile{path: filepath.Join(directory, "missing.zone")}).records()
+	assert.Error(t, err)
+}
+
+func TestRoute53ZoneRecords(t *testing.T) {
+	route53Svc := &route53Mock{pages: []*route53.ListResourceRecordSetsOutput{
+		{ResourceRecordSets: []*route53.ResourceRecordSet{
+			{Name: aws.String("example.com."), Type: aws.String("A"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("1.1.1.1")}, {Value: aws.String("2.2.2.2")}}},
+			{Name: aws.String("example.com."), Type: aws.String("TXT"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"v=spf1 -all"`)}}},
+		}},
+		{ResourceRecordSets: []*route53.ResourceRecordSet{
+			{Name: aws.String(`\052.example.com.`), Type: aws.String("CNAME"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("Example.com.")}}},
+			{Name: aws.String("shop.example.com."), Type: aws.String("A"),
+				AliasTarget: &route53.AliasTarget{DNSName: aws.String("dualstack.shop.elb.amazonaws.com.")}},
+		}},
+	}}
+
+	records, err := (&route53Zone{route53Svc: route53Svc, zoneID: "Z123"}).records()
+	assert.NoError(t, err)
+	assert.Equal(t, []record{
+		{name: "example.com", recordType: recordA, value: "1.1.1.1"},
+		{name: "example.com", recordType: recordA, value: "2.2.2.2"},
+		{name: "*.example.com", recordType: recordCNAME, value: "example.com"},
+		{name: "shop.example.com", recordType: recordCNAME, value: "dualstack.shop.elb.amazonaws.com"},
+	}, records)
+
+	_, err = (&route53Zone{route53Svc: &route53Mock{err: errors.New("AccessDenied")}, zoneID: "Z123"}).records()
+	assert.Error(t, err)
+
+	_, err = (&route53Zone{zoneID: "Z123"}).records()
+	assert.Error(t, err)
+}
+
+func TestCloudDNSRecords(t *testing.T) {
+	records := cloudDNSRecords([]*clouddns.ResourceRecordSet{
+		{Name: "example.com.", Type: "A", Rrdatas: []string{"1.1.1.1"}},
+		{Name: "example.com.", Type: "NS", Rrdatas: []string{"ns-cloud-a1.googledomains.com."}},
+		{Name: "api.example.com.", Type: "AAAA", Rrdatas: []string{"2001:db8::1"}},
+		{Name: "www.example.com.", Type: "CNAME", Rrdatas: []string{"example.com."}},
+	})
+	assert.Equal(t, []record{
+		{name: "example.com", recordType: recordA, value: "1.1.1.1"},
+		{name: "api.example.com", recordType: recordAAAA, value: "2001:db8::1"},
+		{name: "www.example.com", recordType: recordCNAME, value: "example.com"},
+	}, records)
+}
+
+func TestInstances(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	d := &dnsZoneSvc{
+		sources: []zoneSource{
+			&sourceMock{zone: "example.com.zone", zoneRRs: []record{
+				{name: "example.com", recordType: recordA, value: "1.1.1.1"},
+				{name: "www.example.com", recordType: recordCNAME, value: "example.com"},
+				{name: "api.example.com", recordType: recordAAAA, value: "2001:db8:0::1"},
+				{name: "shop.example.com", recordType: recordCNAME, value: "shop.elb.amazonaws.com"},
+				{name: "old.example.com", recordType: recordCNAME, value: "gone.example.net"},
+				{name: "loop.example.com", recordType: recordCNAME, value: "loop.example.com"},
+			}},
+			&sourceMock{zone: "route53/Z123", zoneRRs: []record{
+				{name: "example.com", recordType: recordA, value: "1.1.1.1"},
+				{name: "vpn.example.org", recordType: recordA, value: "1.1.1.1"},
+			}},
+			&sourceMock{zone: "project/zone", err: errors.New("forbidden")},
+		},
+		lookupHost: lookupHost,
+	}
+
+	serversMap := map[string]server.Server{
+		"1.1.1.1": {Name: "i-123", Address: "1.1.1.1", Provider: "aws", Tags: map[string]string{"team": "web"}},
+		"9.9.9.9": {Name: "i-456", Address: "9.9.9.9", Provider: "aws", Tags: map[string]string{}},
+	}
+	err := d.Instances(serversMap)
+
+	var errs inventory.Errors
+	assert.True(t, errors.As(err, &errs))
+	tasks := make([]string, len(errs))
+	for index, taskError := range errs {
+		tasks[index] = taskError.Task
+	}
+	assert.Equal(t, []string{"project/zone", "loop.example.com", "old.example.com"}, tasks)
+
+	assert.Equal(t, map[string]server.Server{
+		"1.1.1.1": {Name: "i-123", Address: "1.1.1.1", Provider: "aws", Tags: map[string]string{
+			"team":       "web",
+			HostnamesTag: "example.com,vpn.example.org,www.example.com",
+			ZoneTag:      "example.com.zone,route53/Z123",
+		}},
+		"2001:db8::1": {Name: "api.example.com", Address: "2001:db8::1", Provider: "dns", Tags: map[string]string{
+			OutsideInventoryTag: "true",
+			HostnamesTag:        "api.example.com",
+			ZoneTag:             "example.com.zone",
+		}, Scopes: []string{"example.com.zone", "api.example.com"}},
+		"5.5.5.5": {Name: "shop.example.com", Address: "5.5.5.5", Provider: "dns", Tags: map[string]string{
+			OutsideInventoryTag: "true",
+			HostnamesTag:        "shop.example.com",
+			ZoneTag:             "example.com.zone",
+		}, Scopes: []string{"example.com.zone", "shop.example.com"}},
+		"6.6.6.6": {Name: "shop.example.com", Address: "6.6.6.6", Provider: "dns", Tags: map[string]string{
+			OutsideInventoryTag: "true",
+			HostnamesTag:        "shop.example.com",
+			ZoneTag:             "example.com.zone",
+		}, Scopes: []string{"example.com.zone", "shop.example.com"}},
+		"9.9.9.9": {Name: "i-456", Address: "9.9.9.9", Provider: "aws", Tags: map[string]string{}},
+	}, serversMap)
+}
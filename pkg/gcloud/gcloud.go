@@ -2,87 +2,321 @@ package gcloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
+	"sort"
+	"strings"
 
 	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
 	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/cloudresourcemanager/v3"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+const (
+	// NetworkTagsTag holds the comma separated network tags of an instance. Labels are recorded under their own keys.
+	NetworkTagsTag = "networkTags"
+	// ProjectTag holds the ID of the project every resource belongs to.
+	ProjectTag = "projectId"
+
+	// unreachableWarning is set on the list of a zone that could not be listed.
+	unreachableWarning = "UNREACHABLE"
+)
+
 type GCloudInterface interface {
 	Instances(serversMap map[string]server.Server) error
 }
 
 type gCloudSvc struct {
+	projects []*project
+	// resources holds the resources to inventory.
+	resources map[string]bool
+	// workers is the number of projects inventoried at the same time.
+	workers int
+}
+
+// project is an inventoried GCloud project.
+type project struct {
+	id             string
 	computeService wrapper.GCloudWrapper
 }
 
 func New(config config.BaseConfig) (*gCloudSvc, error) {
-	gCloudWrapper, err := createGCloudInterface(config)
+	g, err := newGCloudSvc(config)
 	if err != nil {
 		return nil, fmt.Errorf("New: Error Creating gCloudSvc Interface %s", err)
 	}
+	return g, nil
+}
+
+// newGCloudSvc returns a service inventorying the configured projects along with the projects found under the
+// configured folder or organization.
+func newGCloudSvc(baseConfig config.BaseConfig, options ...option.ClientOption) (*gCloudSvc, error) {
+	var err error
+	gCloudConfig := baseConfig.GCloudConfig
+	if gCloudConfig == nil {
+		return nil, fmt.Errorf("newGCloudSvc: GCloudConfig cannot be nil")
+	}
 
 	g := gCloudSvc{}
-	g.computeService = gCloudWrapper
+	g.workers = baseConfig.InventoryWorkerCount()
+	g.resources, err = selectResources(gCloudConfig)
+	if err != nil {
+		return nil, fmt.Errorf("newGCloudSvc: %s", err)
+	}
 
-	return &g, nil
-}
+	if gCloudConfig.ServiceAccountPath != "" {
+		options = append(options, option.WithCredentialsFile(gCloudConfig.ServiceAccountPath))
+	}
 
-func createGCloudInterface(baseConfig config.BaseConfig) (*gCloudWrapper, error) {
-	options := option.WithCredentialsFile(baseConfig.GCloudConfig.ServiceAccountPath)
+	projectIDs := append([]string{gCloudConfig.ProjectName}, gCloudConfig.Projects...)
+	if gCloudConfig.Parent != "" {
+		resourceManager, err := cloudresourcemanager.NewService(context.Background(), options...)
+		if err != nil {
+			return nil, fmt.Errorf("newGCloudSvc: Error creating cloudresourcemanager.Service object %s", err)
+		}
 
-	computeService, err := compute.NewService(context.Background(), options)
-	if err != nil {
-		return nil, fmt.Errorf("SetupRunner: Error creating compute.Service object %s", err)
+		discovered, err := discoverProjects(&projectLister{resourceManager: resourceManager}, gCloudConfig.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("newGCloudSvc: %s", err)
+		}
+		projectIDs = append(projectIDs, discovered...)
 	}
 
-	gCloudInterface, err := newCloudWrapper(computeService, baseConfig.GCloudConfig.ProjectName)
+	computeService, err := compute.NewService(context.Background(), options...)
 	if err != nil {
-		return nil, fmt.Errorf("SetupRunner: Error creating gCloudSvc wrapper %s", err)
+		return nil, fmt.Errorf("newGCloudSvc: Error creating compute.Service object %s", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, projectID := range projectIDs {
+		if projectID == "" || seen[projectID] {
+			continue
+		}
+		seen[projectID] = true
+
+		gCloudWrapper, err := newCloudWrapper(computeService, projectID)
+		if err != nil {
+			return nil, fmt.Errorf("newGCloudSvc: Error creating gCloudSvc wrapper %s", err)
+		}
+		g.projects = append(g.projects, &project{id: projectID, computeService: gCloudWrapper})
+	}
+
+	if len(g.projects) == 0 {
+		return nil, fmt.Errorf("newGCloudSvc: no project to inventory, a project or a parent must be set")
 	}
-	return gCloudInterface, nil
+	return &g, nil
 }
 
+// selectResources returns the resources to inventory, which are all of them unless gCloudConfig lists some.
+func selectResources(gCloudConfig *config.GCloudConfig) (map[string]bool, error) {
+	known := []string{config.GCloudInstances, config.GCloudForwardingRules, config.GCloudAddresses}
+	resources := make(map[string]bool)
+	if gCloudConfig == nil || len(gCloudConfig.Resources) == 0 {
+		for _, resource := range known {
+			resources[resource] = true
+		}
+		return resources, nil
+	}
+
+	for _, resource := range gCloudConfig.Resources {
+		valid := false
+		for _, k := range known {
+			valid = valid || resource == k
+		}
+		if !valid {
+			return nil, fmt.Errorf("selectResources: unknown GCloud resource %s", resource)
+		}
+		resources[resource] = true
+	}
+	return resources, nil
+}
+
+// Instances records every external address of the selected resources of every project, inventorying several
+// projects at the same time. Projects, zones and regions that could not be listed are returned as inventory.Errors
+// after the resources of the others are recorded.
 func (g *gCloudSvc) Instances(serversMap map[string]server.Server) error {
-	regionNames, err := g.computeService.Zones()
-	if err != nil {
-		return fmt.Errorf("Instances: Error Getting zones %s", err)
+	// Every task only writes its own entry, so the scopes that could not be listed need no locking.
+	scopeErrors := make([]inventory.Errors, len(g.projects))
+
+	tasks := make([]inventory.Task, len(g.projects))
+	for index, p := range g.projects {
+		index, p := index, p
+		tasks[index] = inventory.Task{
+			Name: p.id,
+			Run: func(projectServers map[string]server.Server) error {
+				var err error
+				scopeErrors[index], err = g.getProjectResources(p, projectServers)
+				return err
+			},
+		}
 	}
 
-	for _, region := range regionNames {
-		instances, err := g.computeService.InstancesInRegion(region)
-		if err != nil {
-			return fmt.Errorf("Instances: Error listing Instances %s", err)
-		}
-		log.Debug(len(instances))
-
-		for _, instance := range instances {
-			if len(instance.NetworkInterfaces) > 0 {
-				if len(instance.NetworkInterfaces[0].AccessConfigs) > 0 {
-					newServer := server.Server{}
-					newServer.Tags = make(map[string]string)
-					newServer.Name = instance.Name
-					newServer.Address = instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
-					for index, key := range instance.Tags.Items {
-						newServer.Tags[strconv.FormatInt(int64(index), 10)] = key
-					}
-					serversMap[newServer.Address] = newServer
-				} else {
-					continue
-				}
-			} else {
-				continue
-			}
+	var errs inventory.Errors
+	err := inventory.Run(g.workers, tasks, serversMap)
+	if err != nil && !errors.As(err, &errs) {
+		return fmt.Errorf("Instances: %s", err)
+	}
+
+	for index, p := range g.projects {
+		for _, scopeError := range scopeErrors[index] {
+			errs = append(errs, &inventory.TaskError{Task: p.id + "/" + scopeError.Task, Err: scopeError.Err})
 		}
 	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Instances: Error listing resources %w", errs)
+	}
 	return nil
 }
 
+// getProjectResources records the selected resources of the project and returns the zones and regions that could not
+// be listed. Reserved addresses are recorded last so that those used by another resource keep the entry of that
+// resource. Projects where the Compute Engine API is disabled have no resources and are skipped. Resources are only
+// recorded in serversMap once every getter succeeded, so a project skipped or failing midway records nothing.
+func (g *gCloudSvc) getProjectResources(p *project, serversMap map[string]server.Server) (inventory.Errors, error) {
+	getters := []struct {
+		resource string
+		get      func(p *project, serversMap map[string]server.Server) (inventory.Errors, error)
+	}{
+		{config.GCloudInstances, g.getInstances},
+		{config.GCloudForwardingRules, g.getForwardingRules},
+		{config.GCloudAddresses, g.getAddresses},
+	}
+
+	var scopeErrors inventory.Errors
+	projectServers := make(map[string]server.Server)
+	for _, getter := range getters {
+		if !g.resources[getter.resource] {
+			continue
+		}
+
+		errs, err := getter.get(p, projectServers)
+		if computeDisabled(err) {
+			log.Info("Skipping project ", p.id, " where the Compute Engine API is disabled")
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getProjectResources: %s", err)
+		}
+		scopeErrors = append(scopeErrors, errs...)
+	}
+
+	// The zones and regions of the servers are named with their project, the same as those that could not be listed.
+	for address, s := range projectServers {
+		s.Tags[ProjectTag] = p.id
+		for index, scope := range s.Scopes {
+			s.Scopes[index] = p.id + "/" + scope
+		}
+		serversMap[address] = s
+	}
+	return scopeErrors, nil
+}
+
+// computeDisabled returns whether the error was returned because the Compute Engine API is disabled in the project.
+func computeDisabled(err error) bool {
+	var apiError *googleapi.Error
+	if !errors.As(err, &apiError) || apiError.Code != 403 {
+		return false
+	}
+	for _, item := range apiError.Errors {
+		if item.Reason == "accessNotConfigured" {
+			return true
+		}
+	}
+	return false
+}
+
+// getInstances records the instances of every zone and returns the zones that could not be listed.
+func (g *gCloudSvc) getInstances(p *project, serversMap map[string]server.Server) (inventory.Errors, error) {
+	scopedLists, err := p.computeService.AggregatedInstances()
+	if err != nil {
+		return nil, fmt.Errorf("getInstances: %w", err)
+	}
+
+	scopes := make([]string, 0, len(scopedLists))
+	for scope := range scopedLists {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	var zoneErrors inventory.Errors
+	for _, scope := range scopes {
+		scopedList := scopedLists[scope]
+		zone := scopeName(scope)
+		if scopedList.Warning != nil && scopedList.Warning.Code == unreachableWarning {
+			zoneErrors = append(zoneErrors, &inventory.TaskError{Task: zone, Err: fmt.Errorf("%s", scopedList.Warning.Message)})
+			continue
+		}
+
+		log.Debug(len(scopedList.Instances), " instances in ", zone)
+		for _, instance := range scopedList.Instances {
+			addInstance(instance, zone, serversMap)
+		}
+	}
+	return zoneErrors, nil
+}
+
+// scopeName returns the zone or region of an aggregated list key such as "zones/us-east1-b".
+func scopeName(scope string) string {
+	return scope[strings.LastIndex(scope, "/")+1:]
+}
+
+// addInstance records an instance under every external IPv4 and IPv6 address of every network interface, with its zone
+// as scope. Instances without an external address are skipped since they cannot be scanned.
+func addInstance(instance *compute.Instance, zone string, serversMap map[string]server.Server) {
+	addresses := externalAddresses(instance)
+	if len(addresses) == 0 {
+		log.Debug("Skipping instance ", instance.Name, " without an external address")
+		return
+	}
+
+	for _, address := range addresses {
+		newServer := server.Server{}
+		newServer.Tags = make(map[string]string)
+		newServer.Name = instance.Name
+		newServer.Provider = "gcloud"
+		newServer.ResourceID = instance.SelfLink
+		newServer.Address = address
+		newServer.Scopes = []string{zone}
+		for key, value := range instance.Labels {
+			newServer.Tags[key] = value
+		}
+		if instance.Tags != nil && len(instance.Tags.Items) > 0 {
+			newServer.Tags[NetworkTagsTag] = strings.Join(instance.Tags.Items, ",")
+		}
+		serversMap[newServer.Address] = newServer
+	}
+}
+
+// externalAddresses returns the external addresses of the instance in the order its interfaces are attached.
+func externalAddresses(instance *compute.Instance) []string {
+	var addresses []string
+	seen := make(map[string]bool)
+	add := func(address string) {
+		if address == "" || seen[address] {
+			return
+		}
+		seen[address] = true
+		addresses = append(addresses, address)
+	}
+
+	for _, networkInterface := range instance.NetworkInterfaces {
+		for _, accessConfig := range networkInterface.AccessConfigs {
+			add(accessConfig.NatIP)
+		}
+		for _, accessConfig := range networkInterface.Ipv6AccessConfigs {
+			add(accessConfig.ExternalIpv6)
+		}
+	}
+	return addresses
+}
+
 type gCloudWrapper struct {
 	computeService *compute.Service
 	project        string
@@ -100,47 +334,109 @@ func newCloudWrapper(computeService *compute.Service, project string) (*gCloudWr
 	return &gCloudWrapper{computeService: computeService, project: project}, nil
 }
 
-func (g *gCloudWrapper) Zones() ([]string, error) {
-	var regionNames []string
+// AggregatedInstances lists the instances of every zone with a single paginated call.
+func (g *gCloudWrapper) AggregatedInstances() (map[string]compute.InstancesScopedList, error) {
 	if g.computeService == nil {
-		return nil, fmt.Errorf("Zones: computeService cannot be nil")
+		return nil, fmt.Errorf("AggregatedInstances: computeService cannot be nil")
 	}
 
-	listRegionsCall := g.computeService.Zones.List(g.project)
-	regions, err := listRegionsCall.Do()
-
+	scopedLists := make(map[string]compute.InstancesScopedList)
+	err := g.computeService.Instances.AggregatedList(g.project).Pages(context.Background(),
+		func(page *compute.InstanceAggregatedList) error {
+			for scope, scopedList := range page.Items {
+				merged := scopedLists[scope]
+				merged.Instances = append(merged.Instances, scopedList.Instances...)
+				if scopedList.Warning != nil && scopedList.Warning.Code == unreachableWarning {
+					merged.Warning = scopedList.Warning
+				}
+				scopedLists[scope] = merged
+			}
+			return nil
+		})
 	if err != nil {
-		return nil, fmt.Errorf("Zones: Error Getting zones %s", err)
-	}
-	if regions == nil {
-		return nil, fmt.Errorf("Zones: No Zones Available")
+		return nil, fmt.Errorf("AggregatedInstances: Error listing instances %w", err)
 	}
+	return scopedLists, nil
+}
 
-	for _, region := range regions.Items {
-		regionNames = append(regionNames, region.Name)
+func (g *gCloudWrapper) AggregatedForwardingRules() (map[string]compute.ForwardingRulesScopedList, error) {
+	if g.computeService == nil {
+		return nil, fmt.Errorf("AggregatedForwardingRules: computeService cannot be nil")
 	}
 
-	return regionNames, nil
+	scopedLists := make(map[string]compute.ForwardingRulesScopedList)
+	err := g.computeService.ForwardingRules.AggregatedList(g.project).Pages(context.Background(),
+		func(page *compute.ForwardingRuleAggregatedList) error {
+			for scope, scopedList := range page.Items {
+				merged := scopedLists[scope]
+				merged.ForwardingRules = append(merged.ForwardingRules, scopedList.ForwardingRules...)
+				if scopedList.Warning != nil && scopedList.Warning.Code == unreachableWarning {
+					merged.Warning = scopedList.Warning
+				}
+				scopedLists[scope] = merged
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("AggregatedForwardingRules: Error listing forwarding rules %w", err)
+	}
+	return scopedLists, nil
 }
 
-func (g *gCloudWrapper) InstancesInRegion(region string) ([]compute.Instance, error) {
-	var instances []compute.Instance
+func (g *gCloudWrapper) GlobalForwardingRules() ([]*compute.ForwardingRule, error) {
+	if g.computeService == nil {
+		return nil, fmt.Errorf("GlobalForwardingRules: computeService cannot be nil")
+	}
 
-	if region == "" {
-		return nil, fmt.Errorf("InstancesInRegion: region cannot be nil")
+	var forwardingRules []*compute.ForwardingRule
+	err := g.computeService.GlobalForwardingRules.List(g.project).Pages(context.Background(),
+		func(page *compute.ForwardingRuleList) error {
+			forwardingRules = append(forwardingRules, page.Items...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("GlobalForwardingRules: Error listing forwarding rules %w", err)
 	}
+	return forwardingRules, nil
+}
+
+func (g *gCloudWrapper) AggregatedAddresses() (map[string]compute.AddressesScopedList, error) {
 	if g.computeService == nil {
-		return nil, fmt.Errorf("InstancesInRegion: computeService cannot be nil")
+		return nil, fmt.Errorf("AggregatedAddresses: computeService cannot be nil")
 	}
 
-	listInstancesCall := g.computeService.Instances.List(g.project, region)
-	gcloudInstances, err := listInstancesCall.Do()
+	scopedLists := make(map[string]compute.AddressesScopedList)
+	err := g.computeService.Addresses.AggregatedList(g.project).Pages(context.Background(),
+		func(page *compute.AddressAggregatedList) error {
+			for scope, scopedList := range page.Items {
+				merged := scopedLists[scope]
+				merged.Addresses = append(merged.Addresses, scopedList.Addresses...)
+				if scopedList.Warning != nil && scopedList.Warning.Code == unreachableWarning {
+					merged.Warning = scopedList.Warning
+				}
+				scopedLists[scope] = merged
+			}
+			return nil
+		})
 	if err != nil {
-		return nil, fmt.Errorf("InstancesIPsInRegion: Error getting instances %s", err)
+		return nil, fmt.Errorf("AggregatedAddresses: Error listing addresses %w", err)
 	}
+	return scopedLists, nil
+}
 
-	for _, computeInstance := range gcloudInstances.Items {
-		instances = append(instances, *computeInstance)
+func (g *gCloudWrapper) GlobalAddresses() ([]*compute.Address, error) {
+	if g.computeService == nil {
+		return nil, fmt.Errorf("GlobalAddresses: computeService cannot be nil")
+	}
+
+	var addresses []*compute.Address
+	err := g.computeService.GlobalAddresses.List(g.project).Pages(context.Background(),
+		func(page *compute.AddressList) error {
+			addresses = append(addresses, page.Items...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("GlobalAddresses: Error listing addresses %w", err)
 	}
-	return instances, nil
+	return addresses, nil
 }
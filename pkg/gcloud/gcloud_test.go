@@ -1,16 +1,24 @@
 package gcloud
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
 	"github.com/Invoca/nmap-diff/pkg/mocks"
 	"github.com/Invoca/nmap-diff/pkg/server"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
 type getInstancesTestCase struct {
@@ -20,78 +28,79 @@ type getInstancesTestCase struct {
 }
 
 func TestGetInstances(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
 
 	serviceMock := mocks.GCloudMock{}
 	gcloud := gCloudSvc{}
-	gcloud.computeService = &serviceMock
+	gcloud.projects = []*project{{id: "astral-projection", computeService: &serviceMock}}
+	gcloud.resources = map[string]bool{config.GCloudInstances: true}
 	serversMap := make(map[string]server.Server)
 
-	regions := []string{
-		"Never",
-		"Eat",
-		"Soggy",
-		"Waffles",
-	}
-
-	resp := []compute.Instance{
-		{
-			Name: "Instance 1",
-			NetworkInterfaces: []*compute.NetworkInterface{
+	resp := map[string]compute.InstancesScopedList{
+		"zones/us-east1-b": {
+			Instances: []*compute.Instance{
 				{
-					AccessConfigs: []*compute.AccessConfig{
+					Name: "Instance 1",
+					NetworkInterfaces: []*compute.NetworkInterface{
 						{
-							NatIP: "1.1.1.1",
+							AccessConfigs: []*compute.AccessConfig{
+								{
+									NatIP: "1.1.1.1",
+								},
+							},
+							Ipv6AccessConfigs: []*compute.AccessConfig{
+								{
+									ExternalIpv6: "2600:1900:4000::1",
+								},
+							},
+						},
+						{
+							AccessConfigs: []*compute.AccessConfig{
+								{
+									NatIP: "1.1.1.2",
+								},
+							},
+						},
+					},
+					Labels: map[string]string{
+						"team": "web",
+					},
+					Tags: &compute.Tags{
+						Items: []string{
+							"http-server",
+							"https-server",
 						},
 					},
 				},
-			},
-			Tags: &compute.Tags{
-				Items: []string{
-					"Tag1",
-				},
-			},
-		},
-		{
-			Name: "Instance 2",
-			NetworkInterfaces: []*compute.NetworkInterface{
 				{
-					AccessConfigs: []*compute.AccessConfig{
+					Name: "Instance 2",
+					NetworkInterfaces: []*compute.NetworkInterface{
 						{
-							NatIP: "2.2.2.2",
+							NetworkIP: "10.0.0.2",
 						},
 					},
 				},
 			},
-			Tags: &compute.Tags{
-				Items: []string{
-					"Tag2",
-				},
-			},
-		},
-		{
-			Name: "Instance 3",
-			Tags: &compute.Tags{
-				Items: []string{
-					"Tag2",
-				},
-			},
 		},
-		{
-			Name: "Instance 4",
-			NetworkInterfaces: []*compute.NetworkInterface{
+		"zones/us-west1-a": {
+			Instances: []*compute.Instance{
 				{
-					AccessConfigs: []*compute.AccessConfig{
+					Name:     "Instance 3",
+					SelfLink: "https://www.googleapis.com/compute/v1/projects/astral-projection/zones/us-west1-a/instances/instance-3",
+					NetworkInterfaces: []*compute.NetworkInterface{
 						{
-							NatIP: "0",
+							AccessConfigs: []*compute.AccessConfig{
+								{
+									NatIP: "3.3.3.3",
+								},
+							},
 						},
 					},
 				},
 			},
-			Tags: &compute.Tags{
-				Items: []string{
-					"Tag3",
-				},
-			},
+		},
+		"zones/europe-west1-b": {
+			Warning: &compute.InstancesScopedListWarning{Code: "NO_RESULTS_ON_PAGE"},
 		},
 	}
 
@@ -100,26 +109,227 @@ func TestGetInstances(t *testing.T) {
 			desc: "Able to get all instances without error",
 			setup: func() {
 				serviceMock.Reset()
-				serviceMock.On("Zones", mock.Anything).Return(regions, nil)
-				serviceMock.On("InstancesInRegion", mock.Anything).Return(resp, nil)
+				serviceMock.On("AggregatedInstances", mock.Anything).Return(resp, nil)
 			},
 			shouldError: false,
 		},
 		{
-			desc: "Error returned by region retrieval",
+			desc: "Error returned by instance retrieval",
 			setup: func() {
 				serviceMock.Reset()
-				serviceMock.On("Zones", mock.Anything).Return(regions, fmt.Errorf("error"))
-				serviceMock.On("InstancesInRegion", mock.Anything).Return(nil, nil)
+				serviceMock.On("AggregatedInstances", mock.Anything).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		err := gcloud.Instances(serversMap)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	t.Logf("TestGetInstances: every external address of every interface is recorded")
+	var addresses []string
+	for address := range serversMap {
+		addresses = append(addresses, address)
+	}
+	assert.ElementsMatch(t, []string{"1.1.1.1", "1.1.1.2", "2600:1900:4000::1", "3.3.3.3"}, addresses)
+	assert.Equal(t, "Instance 1", serversMap["2600:1900:4000::1"].Name)
+	assert.Equal(t, map[string]string{
+		"team":         "web",
+		NetworkTagsTag: "http-server,https-server",
+		ProjectTag:     "astral-projection",
+	}, serversMap["1.1.1.2"].Tags)
+	assert.Equal(t, map[string]string{ProjectTag: "astral-projection"}, serversMap["3.3.3.3"].Tags)
+	assert.Equal(t, "gcloud:https://www.googleapis.com/compute/v1/projects/astral-projection/zones/us-west1-a/instances/instance-3",
+		serversMap["3.3.3.3"].Identity())
+
+	t.Logf("TestGetInstances: unreachable zones are returned after recording the other zones")
+	serviceMock.Reset()
+	unreachable := map[string]compute.InstancesScopedList{
+		"zones/us-west1-a": resp["zones/us-west1-a"],
+		"zones/asia-east1-a": {
+			Warning: &compute.InstancesScopedListWarning{Code: "UNREACHABLE", Message: "zone unavailable"},
+		},
+	}
+	serviceMock.On("AggregatedInstances", mock.Anything).Return(unreachable, nil)
+	serversMap = make(map[string]server.Server)
+	err := gcloud.Instances(serversMap)
+	var zoneErrors inventory.Errors
+	assert.True(t, errors.As(err, &zoneErrors))
+	assert.Equal(t, 1, len(zoneErrors))
+	assert.Equal(t, "astral-projection/asia-east1-a", zoneErrors[0].Task)
+	assert.Contains(t, serversMap, "3.3.3.3")
+
+	t.Logf("TestGetInstances: instances are recorded with their project and zone as scopes")
+	assert.Equal(t, []string{"astral-projection", "astral-projection/us-west1-a"}, serversMap["3.3.3.3"].Scopes)
+}
+
+func TestAggregatedInstances(t *testing.T) {
+	pages := []compute.InstanceAggregatedList{
+		{
+			Items: map[string]compute.InstancesScopedList{
+				"zones/us-east1-b": {Instances: []*compute.Instance{{Name: "Instance 1"}}},
+			},
+			NextPageToken: "page-2",
+		},
+		{
+			Items: map[string]compute.InstancesScopedList{
+				"zones/us-east1-b":   {Instances: []*compute.Instance{{Name: "Instance 2"}}},
+				"zones/asia-east1-a": {Warning: &compute.InstancesScopedListWarning{Code: "UNREACHABLE"}},
+			},
+		},
+	}
+
+	var requests []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.Query().Get("pageToken"))
+		page := pages[0]
+		if r.URL.Query().Get("pageToken") == "page-2" {
+			page = pages[1]
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer testServer.Close()
+
+	baseConfig := config.BaseConfig{GCloudConfig: &config.GCloudConfig{ProjectName: "astral-projection"}}
+	gcloud, err := newGCloudSvc(baseConfig, option.WithEndpoint(testServer.URL+"/compute/v1/"),
+		option.WithoutAuthentication())
+	assert.NoError(t, err)
+
+	scopedLists, err := gcloud.projects[0].computeService.AggregatedInstances()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"/compute/v1/projects/astral-projection/aggregated/instances?",
+		"/compute/v1/projects/astral-projection/aggregated/instances?page-2",
+	}, requests)
+	assert.Equal(t, 2, len(scopedLists["zones/us-east1-b"].Instances))
+	assert.Equal(t, "UNREACHABLE", scopedLists["zones/asia-east1-a"].Warning.Code)
+}
+
+func TestInstancesProjects(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	instances := func(address string) map[string]compute.InstancesScopedList {
+		return map[string]compute.InstancesScopedList{
+			"zones/us-east1-b": {
+				Instances: []*compute.Instance{
+					{
+						Name: "Instance " + address,
+						NetworkInterfaces: []*compute.NetworkInterface{
+							{AccessConfigs: []*compute.AccessConfig{{NatIP: address}}},
+						},
+					},
+				},
+			},
+		}
+	}
+	disabled := &googleapi.Error{
+		Code:   403,
+		Errors: []googleapi.ErrorItem{{Reason: "accessNotConfigured"}},
+	}
+
+	webMock := mocks.GCloudMock{}
+	webMock.On("AggregatedInstances", mock.Anything).Return(instances("1.1.1.1"), nil)
+	dataMock := mocks.GCloudMock{}
+	dataMock.On("AggregatedInstances", mock.Anything).Return(instances("2.2.2.2"), nil)
+	sandboxMock := mocks.GCloudMock{}
+	sandboxMock.On("AggregatedInstances", mock.Anything).Return(nil, fmt.Errorf("AggregatedInstances: Error listing instances %w", disabled))
+
+	gcloud := gCloudSvc{
+		projects: []*project{
+			{id: "web", computeService: &webMock},
+			{id: "data", computeService: &dataMock},
+			{id: "sandbox", computeService: &sandboxMock},
+		},
+		resources: map[string]bool{config.GCloudInstances: true},
+		workers:   2,
+	}
+
+	t.Logf("TestInstancesProjects: projects where the Compute Engine API is disabled are skipped")
+	serversMap := make(map[string]server.Server)
+	assert.NoError(t, gcloud.Instances(serversMap))
+	assert.Equal(t, 2, len(serversMap))
+	assert.Equal(t, "web", serversMap["1.1.1.1"].Tags[ProjectTag])
+	assert.Equal(t, "data", serversMap["2.2.2.2"].Tags[ProjectTag])
+
+	t.Logf("TestInstancesProjects: projects that could not be listed are returned after recording the others")
+	sandboxMock.Reset()
+	sandboxMock.On("AggregatedInstances", mock.Anything).Return(nil, &googleapi.Error{Code: 403, Message: "forbidden"})
+	serversMap = make(map[string]server.Server)
+	err := gcloud.Instances(serversMap)
+	var projectErrors inventory.Errors
+	assert.True(t, errors.As(err, &projectErrors))
+	assert.Equal(t, 1, len(projectErrors))
+	assert.Equal(t, "sandbox", projectErrors[0].Task)
+	assert.Equal(t, 2, len(serversMap))
+
+	t.Logf("TestInstancesProjects: resources listed before the Compute Engine API is found disabled are not recorded")
+	sandboxMock.Reset()
+	sandboxMock.On("AggregatedInstances", mock.Anything).Return(instances("3.3.3.3"), nil)
+	sandboxMock.On("GlobalForwardingRules", mock.Anything).Return(nil, fmt.Errorf("getForwardingRules: %w", disabled))
+	gcloud.resources[config.GCloudForwardingRules] = true
+	webMock.On("GlobalForwardingRules", mock.Anything).Return([]*compute.ForwardingRule{}, nil)
+	webMock.On("AggregatedForwardingRules", mock.Anything).Return(map[string]compute.ForwardingRulesScopedList{}, nil)
+	dataMock.On("GlobalForwardingRules", mock.Anything).Return([]*compute.ForwardingRule{}, nil)
+	dataMock.On("AggregatedForwardingRules", mock.Anything).Return(map[string]compute.ForwardingRulesScopedList{}, nil)
+	serversMap = make(map[string]server.Server)
+	assert.NoError(t, gcloud.Instances(serversMap))
+	assert.Equal(t, 2, len(serversMap))
+	assert.NotContains(t, serversMap, "3.3.3.3")
+}
+
+type discoverProjectsTestCase struct {
+	desc        string
+	setup       func(listerMock *mocks.ProjectListerMock)
+	projects    []string
+	shouldError bool
+}
+
+func TestDiscoverProjects(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	testCases := []discoverProjectsTestCase{
+		{
+			desc: "Projects of nested folders are discovered",
+			setup: func(listerMock *mocks.ProjectListerMock) {
+				listerMock.On("Projects", "organizations/456").Return([]string{"web"}, nil)
+				listerMock.On("Folders", "organizations/456").Return([]string{"folders/1", "folders/2"}, nil)
+				listerMock.On("Projects", "folders/1").Return([]string{"data"}, nil)
+				listerMock.On("Folders", "folders/1").Return([]string{"folders/3"}, nil)
+				listerMock.On("Projects", "folders/3").Return([]string{"analytics"}, nil)
+				listerMock.On("Folders", "folders/3").Return(nil, nil)
+				listerMock.On("Projects", "folders/2").Return(nil, nil)
+				listerMock.On("Folders", "folders/2").Return(nil, nil)
+			},
+			projects:    []string{"web", "data", "analytics"},
+			shouldError: false,
+		},
+		{
+			desc: "Error returned by folder listing",
+			setup: func(listerMock *mocks.ProjectListerMock) {
+				listerMock.On("Projects", "organizations/456").Return([]string{"web"}, nil)
+				listerMock.On("Folders", "organizations/456").Return(nil, fmt.Errorf("error"))
 			},
 			shouldError: true,
 		},
 		{
-			desc: "Error returned by instance retrieval",
-			setup: func() {
-				serviceMock.Reset()
-				serviceMock.On("Zones", mock.Anything).Return(regions, nil)
-				serviceMock.On("InstancesInRegion", mock.Anything).Return(resp, fmt.Errorf("error"))
+			desc: "Error returned by project listing",
+			setup: func(listerMock *mocks.ProjectListerMock) {
+				listerMock.On("Projects", "organizations/456").Return(nil, fmt.Errorf("error"))
 			},
 			shouldError: true,
 		},
@@ -131,14 +341,16 @@ func TestGetInstances(t *testing.T) {
 			"shouldError": testCase.shouldError,
 		}).Debug("Starting testCase " + strconv.Itoa(index))
 
-		testCase.setup()
+		listerMock := mocks.ProjectListerMock{}
+		testCase.setup(&listerMock)
 
-		err := gcloud.Instances(serversMap)
+		projects, err := discoverProjects(&listerMock, "organizations/456")
 
 		if testCase.shouldError {
 			assert.Error(t, err)
 		} else {
 			assert.NoError(t, err)
+			assert.Equal(t, testCase.projects, projects)
 		}
 	}
 }
@@ -0,0 +1,65 @@
+package gcloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	"google.golang.org/api/cloudresourcemanager/v3"
+)
+
+// discoverProjects returns the IDs of the projects under the parent and under every folder nested in it.
+func discoverProjects(lister wrapper.ProjectLister, parent string) ([]string, error) {
+	projects, err := lister.Projects(parent)
+	if err != nil {
+		return nil, fmt.Errorf("discoverProjects: Error listing projects of %s %s", parent, err)
+	}
+
+	folders, err := lister.Folders(parent)
+	if err != nil {
+		return nil, fmt.Errorf("discoverProjects: Error listing folders of %s %s", parent, err)
+	}
+
+	for _, folder := range folders {
+		folderProjects, err := discoverProjects(lister, folder)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, folderProjects...)
+	}
+	return projects, nil
+}
+
+type projectLister struct {
+	resourceManager *cloudresourcemanager.Service
+}
+
+func (p *projectLister) Folders(parent string) ([]string, error) {
+	var folders []string
+	err := p.resourceManager.Folders.List().Parent(parent).Pages(context.Background(),
+		func(response *cloudresourcemanager.ListFoldersResponse) error {
+			for _, folder := range response.Folders {
+				folders = append(folders, folder.Name)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("Folders: Error listing folders %s", err)
+	}
+	return folders, nil
+}
+
+func (p *projectLister) Projects(parent string) ([]string, error) {
+	var projects []string
+	err := p.resourceManager.Projects.List().Parent(parent).Pages(context.Background(),
+		func(response *cloudresourcemanager.ListProjectsResponse) error {
+			for _, item := range response.Projects {
+				projects = append(projects, item.ProjectId)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("Projects: Error listing projects %s", err)
+	}
+	return projects, nil
+}
@@ -0,0 +1,161 @@
+package gcloud
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+)
+
+// The tags every resource other than instances is recorded with. TargetTag holds the name of the target proxy, pool
+// or backend service of a forwarding rule and RegionTag is "global" for global resources.
+const (
+	ResourceTypeTag = "resourceType"
+	TargetTag       = "target"
+	RegionTag       = "region"
+
+	forwardingRuleResourceType = "forwarding-rule"
+	addressResourceType        = "address"
+
+	globalRegion = "global"
+
+	externalScheme        = "EXTERNAL"
+	externalManagedScheme = "EXTERNAL_MANAGED"
+	// Addresses are external unless their type says otherwise.
+	internalAddressType = "INTERNAL"
+)
+
+// newResource returns a server for a resource other than an instance, tagged with its type and region. Regional
+// resources are recorded with their region as scope.
+func newResource(name string, address string, resourceType string, region string, labels map[string]string) server.Server {
+	newServer := server.Server{
+		Name:     name,
+		Address:  address,
+		Provider: "gcloud",
+		Tags:     make(map[string]string),
+	}
+	for key, value := range labels {
+		newServer.Tags[key] = value
+	}
+	newServer.Tags[ResourceTypeTag] = resourceType
+	newServer.Tags[RegionTag] = region
+	if region != globalRegion {
+		newServer.Scopes = []string{region}
+	}
+	return newServer
+}
+
+// getForwardingRules records the address of every external global and regional forwarding rule and returns the
+// regions that could not be listed.
+func (g *gCloudSvc) getForwardingRules(p *project, serversMap map[string]server.Server) (inventory.Errors, error) {
+	globalRules, err := p.computeService.GlobalForwardingRules()
+	if err != nil {
+		return nil, fmt.Errorf("getForwardingRules: %w", err)
+	}
+	for _, forwardingRule := range globalRules {
+		addForwardingRule(forwardingRule, globalRegion, serversMap)
+	}
+
+	scopedLists, err := p.computeService.AggregatedForwardingRules()
+	if err != nil {
+		return nil, fmt.Errorf("getForwardingRules: %w", err)
+	}
+
+	scopes := make([]string, 0, len(scopedLists))
+	for scope := range scopedLists {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	var regionErrors inventory.Errors
+	for _, scope := range scopes {
+		scopedList := scopedLists[scope]
+		region := scopeName(scope)
+		if scopedList.Warning != nil && scopedList.Warning.Code == unreachableWarning {
+			regionErrors = append(regionErrors, &inventory.TaskError{Task: region, Err: fmt.Errorf("%s", scopedList.Warning.Message)})
+			continue
+		}
+
+		for _, forwardingRule := range scopedList.ForwardingRules {
+			addForwardingRule(forwardingRule, region, serversMap)
+		}
+	}
+	return regionErrors, nil
+}
+
+// addForwardingRule records the forwarding rule if it is reachable from the internet.
+func addForwardingRule(forwardingRule *compute.ForwardingRule, region string, serversMap map[string]server.Server) {
+	if forwardingRule.LoadBalancingScheme != externalScheme && forwardingRule.LoadBalancingScheme != externalManagedScheme {
+		return
+	}
+	if forwardingRule.IPAddress == "" {
+		return
+	}
+
+	newServer := newResource(forwardingRule.Name, forwardingRule.IPAddress, forwardingRuleResourceType, region, forwardingRule.Labels)
+	newServer.ResourceID = forwardingRule.SelfLink
+	target := forwardingRule.Target
+	if target == "" {
+		target = forwardingRule.BackendService
+	}
+	if target != "" {
+		newServer.Tags[TargetTag] = scopeName(target)
+	}
+	serversMap[newServer.Address] = newServer
+}
+
+// getAddresses records the reserved external addresses that no other resource was recorded under, such as unused
+// ones, and returns the regions that could not be listed. Addresses used by an instance or forwarding rule keep the
+// entry of that resource.
+func (g *gCloudSvc) getAddresses(p *project, serversMap map[string]server.Server) (inventory.Errors, error) {
+	globalAddresses, err := p.computeService.GlobalAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("getAddresses: %w", err)
+	}
+	for _, address := range globalAddresses {
+		addAddress(address, globalRegion, serversMap)
+	}
+
+	scopedLists, err := p.computeService.AggregatedAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("getAddresses: %w", err)
+	}
+
+	scopes := make([]string, 0, len(scopedLists))
+	for scope := range scopedLists {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	var regionErrors inventory.Errors
+	for _, scope := range scopes {
+		scopedList := scopedLists[scope]
+		region := scopeName(scope)
+		if scopedList.Warning != nil && scopedList.Warning.Code == unreachableWarning {
+			regionErrors = append(regionErrors, &inventory.TaskError{Task: region, Err: fmt.Errorf("%s", scopedList.Warning.Message)})
+			continue
+		}
+
+		for _, address := range scopedList.Addresses {
+			addAddress(address, region, serversMap)
+		}
+	}
+	return regionErrors, nil
+}
+
+func addAddress(address *compute.Address, region string, serversMap map[string]server.Server) {
+	if address.AddressType == internalAddressType || address.Address == "" {
+		return
+	}
+	if _, ok := serversMap[address.Address]; ok {
+		log.Debug("Address ", address.Address, " is already recorded")
+		return
+	}
+
+	newServer := newResource(address.Name, address.Address, addressResourceType, region, nil)
+	newServer.ResourceID = address.SelfLink
+	serversMap[address.Address] = newServer
+}
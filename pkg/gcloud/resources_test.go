@@ -0,0 +1,177 @@
+package gcloud
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/mocks"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestGetForwardingRulesAndAddresses(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	serviceMock := mocks.GCloudMock{}
+	gcloud := gCloudSvc{}
+	gcloud.projects = []*project{{id: "astral-projection", computeService: &serviceMock}}
+	gcloud.resources = map[string]bool{
+		config.GCloudInstances:       true,
+		config.GCloudForwardingRules: true,
+		config.GCloudAddresses:       true,
+	}
+
+	instances := map[string]compute.InstancesScopedList{
+		"zones/us-east1-b": {
+			Instances: []*compute.Instance{
+				{
+					Name: "Instance 1",
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{AccessConfigs: []*compute.AccessConfig{{NatIP: "1.1.1.1"}}},
+					},
+				},
+			},
+		},
+	}
+
+	globalRules := []*compute.ForwardingRule{
+		{
+			Name:                "web-https",
+			IPAddress:           "2.2.2.2",
+			LoadBalancingScheme: "EXTERNAL",
+			Target:              "https://www.googleapis.com/compute/v1/projects/p/global/targetHttpsProxies/web-proxy",
+			Labels:              map[string]string{"team": "web"},
+		},
+	}
+
+	regionalRules := map[string]compute.ForwardingRulesScopedList{
+		"regions/us-east1": {
+			ForwardingRules: []*compute.ForwardingRule{
+				{
+					Name:                "nlb",
+					IPAddress:           "3.3.3.3",
+					LoadBalancingScheme: "EXTERNAL",
+					Target:              "https://www.googleapis.com/compute/v1/projects/p/regions/us-east1/targetPools/pool",
+				},
+				{
+					Name:                "internal",
+					IPAddress:           "10.0.0.1",
+					LoadBalancingScheme: "INTERNAL",
+					BackendService:      "https://www.googleapis.com/compute/v1/projects/p/regions/us-east1/backendServices/db",
+				},
+			},
+		},
+		"regions/asia-east1": {
+			Warning: &compute.ForwardingRulesScopedListWarning{Code: "UNREACHABLE", Message: "region unavailable"},
+		},
+	}
+
+	globalAddresses := []*compute.Address{
+		{Name: "web-ip", Address: "2.2.2.2", AddressType: "EXTERNAL", Status: "IN_USE"},
+	}
+
+	regionalAddresses := map[string]compute.AddressesScopedList{
+		"regions/us-east1": {
+			Addresses: []*compute.Address{
+				{Name: "instance-ip", Address: "1.1.1.1", AddressType: "EXTERNAL", Status: "IN_USE"},
+				{Name: "spare-ip", Address: "4.4.4.4", AddressType: "EXTERNAL", Status: "RESERVED"},
+				{Name: "internal-ip", Address: "10.0.0.2", AddressType: "INTERNAL", Status: "RESERVED"},
+			},
+		},
+	}
+
+	testCases := []getInstancesTestCase{
+		{
+			desc: "Able to get every resource, returning the regions that could not be listed",
+			setup: func() {
+				serviceMock.Reset()
+				serviceMock.On("AggregatedInstances", mock.Anything).Return(instances, nil)
+				serviceMock.On("GlobalForwardingRules", mock.Anything).Return(globalRules, nil)
+				serviceMock.On("AggregatedForwardingRules", mock.Anything).Return(regionalRules, nil)
+				serviceMock.On("GlobalAddresses", mock.Anything).Return(globalAddresses, nil)
+				serviceMock.On("AggregatedAddresses", mock.Anything).Return(regionalAddresses, nil)
+			},
+			shouldError: true,
+		},
+		{
+			desc: "Error returned by forwarding rule retrieval",
+			setup: func() {
+				serviceMock.Reset()
+				serviceMock.On("AggregatedInstances", mock.Anything).Return(instances, nil)
+				serviceMock.On("GlobalForwardingRules", mock.Anything).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+		{
+			desc: "Error returned by address retrieval",
+			setup: func() {
+				serviceMock.Reset()
+				serviceMock.On("AggregatedInstances", mock.Anything).Return(instances, nil)
+				serviceMock.On("GlobalForwardingRules", mock.Anything).Return(globalRules, nil)
+				serviceMock.On("AggregatedForwardingRules", mock.Anything).Return(regionalRules, nil)
+				serviceMock.On("GlobalAddresses", mock.Anything).Return(globalAddresses, nil)
+				serviceMock.On("AggregatedAddresses", mock.Anything).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	serversMap := make(map[string]server.Server)
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		serversMap = make(map[string]server.Server)
+		err := gcloud.Instances(serversMap)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		if index == 0 {
+			assert.Contains(t, err.Error(), "astral-projection/asia-east1: region unavailable")
+
+			t.Logf("TestGetForwardingRulesAndAddresses: external forwarding rules are recorded with their target and region")
+			assert.Equal(t, map[string]string{
+				"team":          "web",
+				ResourceTypeTag: "forwarding-rule",
+				RegionTag:       "global",
+				TargetTag:       "web-proxy",
+				ProjectTag:      "astral-projection",
+			}, serversMap["2.2.2.2"].Tags)
+			assert.Equal(t, "web-https", serversMap["2.2.2.2"].Name)
+			assert.Equal(t, "pool", serversMap["3.3.3.3"].Tags[TargetTag])
+			assert.Equal(t, "us-east1", serversMap["3.3.3.3"].Tags[RegionTag])
+
+			t.Logf("TestGetForwardingRulesAndAddresses: only unused external addresses get an entry of their own")
+			assert.Equal(t, 4, len(serversMap))
+			assert.Equal(t, "Instance 1", serversMap["1.1.1.1"].Name)
+			assert.Equal(t, "spare-ip", serversMap["4.4.4.4"].Name)
+			assert.Equal(t, "address", serversMap["4.4.4.4"].Tags[ResourceTypeTag])
+		}
+	}
+}
+
+func TestSelectResources(t *testing.T) {
+	resources, err := selectResources(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(resources))
+
+	resources, err = selectResources(&config.GCloudConfig{Resources: []string{config.GCloudForwardingRules}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{config.GCloudForwardingRules: true}, resources)
+
+	_, err = selectResources(&config.GCloudConfig{Resources: []string{"buckets"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,242 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// IDFormat is the layout of scan IDs, which are the UTC time the scan was taken at.
+	IDFormat = "20060102T150405Z"
+
+	defaultPrefix = "history"
+	indexName     = "index.json"
+)
+
+// recordMutex serializes the updates of the index. The server runs every scan request with a history of its own, so
+// the lock is shared by every history of the process. Processes sharing a store are not serialized, since the stores
+// offer no conditional writes.
+var recordMutex sync.Mutex
+
+// Entry describes a single scan in the history.
+type Entry struct {
+	ID      string    `json:"id"`
+	Key     string    `json:"key"`
+	TakenAt time.Time `json:"takenAt"`
+}
+
+// index is the manifest of every scan in the history, sorted from oldest to newest.
+type index struct {
+	Scans []Entry `json:"scans"`
+}
+
+type history struct {
+	scanStore wrapper.ScanStore
+	prefix    string
+	keepScans int
+	keepDays  int
+	now       func() time.Time
+}
+
+// New creates a history that keeps every scan in scanStore under historyConfig.Prefix.
+func New(scanStore wrapper.ScanStore, historyConfig *config.HistoryConfig) (*history, error) {
+	if scanStore == nil {
+		return nil, fmt.Errorf("New: scanStore cannot be nil")
+	}
+	if historyConfig == nil {
+		return nil, fmt.Errorf("New: historyConfig cannot be nil")
+	}
+	if historyConfig.KeepScans < 0 || historyConfig.KeepDays < 0 {
+		return nil, fmt.Errorf("New: KeepScans and KeepDays cannot be negative")
+	}
+
+	h := history{}
+	h.scanStore = scanStore
+	h.prefix = historyConfig.Prefix
+	if h.prefix == "" {
+		h.prefix = defaultPrefix
+	}
+	h.keepScans = historyConfig.KeepScans
+	h.keepDays = historyConfig.KeepDays
+	h.now = time.Now
+	return &h, nil
+}
+
+func (h *history) indexKey() string {
+	return path.Join(h.prefix, indexName)
+}
+
+// Entries returns every scan in the history, sorted from oldest to newest.
+func (h *history) Entries() ([]Entry, error) {
+	i, err := h.loadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("Entries: %s", err)
+	}
+	return i.Scans, nil
+}
+
+// loadIndex returns the manifest of the history. A missing manifest is treated as an empty history.
+func (h *history) loadIndex() (*index, error) {
+	data, err := h.scanStore.Load(h.indexKey())
+	if errors.Is(err, wrapper.ErrNotFound) {
+		log.Debug("loadIndex: No history index found, starting a new one")
+		return &index{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loadIndex: Error loading index %s", err)
+	}
+
+	i := index{}
+	err = json.Unmarshal(data, &i)
+	if err != nil {
+		return nil, fmt.Errorf("loadIndex: Error parsing index %s", err)
+	}
+	return &i, nil
+}
+
+func (h *history) saveIndex(i *index) error {
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("saveIndex: Error encoding index %s", err)
+	}
+
+	err = h.scanStore.Save(h.indexKey(), data)
+	if err != nil {
+		return fmt.Errorf("saveIndex: Error saving index %s", err)
+	}
+	return nil
+}
+
+// Find returns the scan with the given ID, or the latest scan taken at or before the given RFC 3339 time.
+func (h *history) Find(ref string) (*Entry, error) {
+	i, err := h.loadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("Find: %s", err)
+	}
+
+	for index := range i.Scans {
+		if i.Scans[index].ID == ref {
+			return &i.Scans[index], nil
+		}
+	}
+
+	at, err := time.Parse(time.RFC3339, ref)
+	if err != nil {
+		at, err = time.Parse(IDFormat, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Find: %s is neither a scan ID nor an RFC 3339 time", ref)
+	}
+
+	for index := len(i.Scans) - 1; index >= 0; index-- {
+		if !i.Scans[index].TakenAt.After(at) {
+			return &i.Scans[index], nil
+		}
+	}
+	return nil, fmt.Errorf("Find: no scan was taken at or before %s", ref)
+}
+
+func (h *history) Load(ref string) ([]byte, error) {
+	entry, err := h.Find(ref)
+	if err != nil {
+		return nil, fmt.Errorf("Load: %s", err)
+	}
+
+	log.Debug("Loading scan " + entry.ID + " from history")
+	data, err := h.scanStore.Load(entry.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Load: Error loading scan %s %s", entry.ID, err)
+	}
+	return data, nil
+}
+
+func (h *history) Record(data []byte, takenAt time.Time) error {
+	recordMutex.Lock()
+	defer recordMutex.Unlock()
+
+	i, err := h.loadIndex()
+	if err != nil {
+		return fmt.Errorf("Record: %s", err)
+	}
+
+	entry := Entry{ID: h.newID(i, takenAt), TakenAt: takenAt.UTC()}
+	entry.Key = path.Join(h.prefix, "scans", entry.ID+".xml")
+
+	err = h.scanStore.Save(entry.Key, data)
+	if err != nil {
+		return fmt.Errorf("Record: Error saving scan %s", err)
+	}
+
+	i.Scans = append(i.Scans, entry)
+	sort.SliceStable(i.Scans, func(a, b int) bool { return i.Scans[a].TakenAt.Before(i.Scans[b].TakenAt) })
+
+	expired := h.expired(i)
+	i.Scans = i.Scans[len(expired):]
+
+	// The index is saved before removing expired scans, so it never lists a scan that no longer exists.
+	err = h.saveIndex(i)
+	if err != nil {
+		return fmt.Errorf("Record: %s", err)
+	}
+
+	for _, e := range expired {
+		log.Debug("Removing expired scan " + e.ID + " from history")
+		err = h.scanStore.Delete(e.Key)
+		if err != nil {
+			log.WithField("error", err).Warn("Record: Unable to remove expired scan " + e.ID)
+		}
+	}
+	return nil
+}
+
+// newID returns the ID of a scan taken at takenAt, adding a suffix when another scan was taken in the same second.
+func (h *history) newID(i *index, takenAt time.Time) string {
+	base := takenAt.UTC().Format(IDFormat)
+	id := base
+	for n := 1; h.contains(i, id); n++ {
+		id = base + "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+func (h *history) contains(i *index, id string) bool {
+	for _, e := range i.Scans {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// expired returns the oldest scans that fall outside of the retention rules. The newest scan is always kept.
+func (h *history) expired(i *index) []Entry {
+	count := 0
+	if h.keepScans > 0 && len(i.Scans) > h.keepScans {
+		count = len(i.Scans) - h.keepScans
+	}
+
+	if h.keepDays > 0 {
+		cutoff := h.now().Add(-time.Duration(h.keepDays) * 24 * time.Hour)
+		for count < len(i.Scans)-1 && i.Scans[count].TakenAt.Before(cutoff) {
+			count++
+		}
+	}
+
+	if count > len(i.Scans)-1 {
+		count = len(i.Scans) - 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	return i.Scans[:count]
+}
@@ -0,0 +1,163 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/store"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHistory(t *testing.T, historyConfig *config.HistoryConfig) (*history, func()) {
+	directory, err := ioutil.TempDir("", "nmap-diff-history")
+	assert.NoError(t, err)
+
+	scanStore, err := store.NewLocal(&config.StorageConfig{Directory: directory})
+	assert.NoError(t, err)
+
+	h, err := New(scanStore, historyConfig)
+	assert.NoError(t, err)
+	return h, func() { os.RemoveAll(directory) }
+}
+
+func TestRecordAndLoad(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	h, cleanup := newTestHistory(t, &config.HistoryConfig{})
+	defer cleanup()
+
+	first := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+
+	assert.NoError(t, h.Record([]byte("first"), first))
+	assert.NoError(t, h.Record([]byte("second"), second))
+	assert.NoError(t, h.Record([]byte("second again"), second))
+
+	entries, err := h.Entries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20200901T120000Z", "20200902T120000Z", "20200902T120000Z-1"},
+		[]string{entries[0].ID, entries[1].ID, entries[2].ID})
+
+	testCases := []struct {
+		desc        string
+		ref         string
+		expected    string
+		shouldError bool
+	}{
+		{desc: "Load a scan by ID", ref: "20200901T120000Z", expected: "first"},
+		{desc: "Load the latest scan taken at or before a time", ref: "2020-09-02T00:00:00Z", expected: "first"},
+		{desc: "Load the latest scan taken in the same second", ref: "2020-09-03T00:00:00Z", expected: "second again"},
+		{desc: "Error if no scan was taken before the time", ref: "2020-08-01T00:00:00Z", shouldError: true},
+		{desc: "Error if the reference is not an ID or a time", ref: "yesterday", shouldError: true},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		data, err := h.Load(testCase.ref)
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, string(data))
+		}
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	h, cleanup := newTestHistory(t, &config.HistoryConfig{})
+	defer cleanup()
+
+	// Scans recorded at the same time by concurrent runs, each with a history of its own, must all be indexed.
+	takenAt := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			other := *h
+			assert.NoError(t, other.Record([]byte("scan "+strconv.Itoa(n)), takenAt.Add(time.Duration(n)*time.Minute)))
+		}(n)
+	}
+	wg.Wait()
+
+	entries, err := h.Entries()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(entries))
+}
+
+func TestRetention(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	start := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc          string
+		historyConfig *config.HistoryConfig
+		daysAfter     int
+		expected      []string
+	}{
+		{
+			desc:          "Keep every scan without retention rules",
+			historyConfig: &config.HistoryConfig{},
+			daysAfter:     4,
+			expected:      []string{"20200901T120000Z", "20200902T120000Z", "20200903T120000Z", "20200904T120000Z"},
+		},
+		{
+			desc:          "Keep the newest scans",
+			historyConfig: &config.HistoryConfig{KeepScans: 2},
+			daysAfter:     4,
+			expected:      []string{"20200903T120000Z", "20200904T120000Z"},
+		},
+		{
+			desc:          "Keep the scans of the last days",
+			historyConfig: &config.HistoryConfig{KeepDays: 2},
+			daysAfter:     4,
+			expected:      []string{"20200903T120000Z", "20200904T120000Z"},
+		},
+		{
+			desc:          "Keep the newest scan even if it is older than the retention period",
+			historyConfig: &config.HistoryConfig{KeepDays: 1, KeepScans: 3},
+			daysAfter:     10,
+			expected:      []string{"20200904T120000Z"},
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc": testCase.desc,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		h, cleanup := newTestHistory(t, testCase.historyConfig)
+		h.now = func() time.Time { return start.Add(time.Duration(testCase.daysAfter) * 24 * time.Hour) }
+
+		for day := 0; day < 4; day++ {
+			assert.NoError(t, h.Record([]byte("scan"), start.Add(time.Duration(day)*24*time.Hour)))
+		}
+
+		entries, err := h.Entries()
+		assert.NoError(t, err)
+
+		var ids []string
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		assert.Equal(t, testCase.expected, ids)
+
+		_, err = h.Load("20200901T120000Z")
+		assert.Equal(t, len(testCase.expected) == 4, err == nil)
+
+		cleanup()
+	}
+}
@@ -0,0 +1,92 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+)
+
+// Task inventories a single region or zone into its own servers map.
+type Task struct {
+	Name string
+	Run  func(serversMap map[string]server.Server) error
+}
+
+// TaskError is the error returned by a single task.
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (e *TaskError) Error() string {
+	return e.Task + ": " + e.Err.Error()
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Errors holds the error of every failed task, in the order the tasks were given.
+type Errors []*TaskError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for index, taskError := range e {
+		messages[index] = taskError.Error()
+	}
+	return fmt.Sprintf("%d tasks failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Run runs the tasks with at most workers of them at a time. Once every task finished, the servers they found are
+// merged into serversMap in the order the tasks were given, so an address found by several tasks is recorded as the
+// last of them found it, the same as when the tasks run one after another. The name of the task is added to the
+// Scopes of its servers. The servers of failed tasks are not merged, and their errors are returned together as
+// Errors.
+func Run(workers int, tasks []Task, serversMap map[string]server.Server) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]map[string]server.Server, len(tasks))
+	taskErrors := make([]error, len(tasks))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(tasks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				log.Debug("Inventorying " + tasks[index].Name)
+				results[index] = make(map[string]server.Server)
+				taskErrors[index] = tasks[index].Run(results[index])
+			}
+		}()
+	}
+
+	for index := range tasks {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	var errs Errors
+	for index, task := range tasks {
+		if taskErrors[index] != nil {
+			errs = append(errs, &TaskError{Task: task.Name, Err: taskErrors[index]})
+			continue
+		}
+		for address, s := range results[index] {
+			s.Scopes = append([]string{task.Name}, s.Scopes...)
+			serversMap[address] = s
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
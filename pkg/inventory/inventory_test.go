@@ -0,0 +1,161 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type runTestCase struct {
+	desc        string
+	workers     int
+	tasks       []Task
+	expected    map[string]string
+	failed      []string
+	shouldError bool
+}
+
+// task returns a task that records each address with the name of the task, after a delay so that tasks overlap.
+func task(name string, addresses ...string) Task {
+	return Task{
+		Name: name,
+		Run: func(serversMap map[string]server.Server) error {
+			time.Sleep(time.Millisecond)
+			for _, address := range addresses {
+				serversMap[address] = server.Server{Name: name, Address: address}
+			}
+			return nil
+		},
+	}
+}
+
+func failingTask(name string, addresses ...string) Task {
+	t := task(name, addresses...)
+	return Task{
+		Name: name,
+		Run: func(serversMap map[string]server.Server) error {
+			_ = t.Run(serversMap)
+			return fmt.Errorf("error")
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	testCases := []runTestCase{
+		{
+			desc:        "servers of every task are merged",
+			workers:     4,
+			tasks:       []Task{task("us-east-1", "1.1.1.1"), task("us-west-2", "2.2.2.2", "3.3.3.3")},
+			expected:    map[string]string{"1.1.1.1": "us-east-1", "2.2.2.2": "us-west-2", "3.3.3.3": "us-west-2"},
+			shouldError: false,
+		},
+		{
+			desc:        "an address found by several tasks is recorded as the last task found it",
+			workers:     8,
+			tasks:       []Task{task("a", "1.1.1.1"), task("b", "1.1.1.1"), task("c", "2.2.2.2"), task("d", "1.1.1.1")},
+			expected:    map[string]string{"1.1.1.1": "d", "2.2.2.2": "c"},
+			shouldError: false,
+		},
+		{
+			desc:        "a worker count below one runs the tasks one at a time",
+			workers:     0,
+			tasks:       []Task{task("a", "1.1.1.1"), task("b", "2.2.2.2")},
+			expected:    map[string]string{"1.1.1.1": "a", "2.2.2.2": "b"},
+			shouldError: false,
+		},
+		{
+			desc:        "the errors of every failed task are returned and their servers are not merged",
+			workers:     2,
+			tasks:       []Task{failingTask("a", "1.1.1.1"), task("b", "2.2.2.2"), failingTask("c", "3.3.3.3")},
+			expected:    map[string]string{"2.2.2.2": "b"},
+			failed:      []string{"a", "c"},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		serversMap := make(map[string]server.Server)
+		err := Run(testCase.workers, testCase.tasks, serversMap)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+			var errs Errors
+			assert.True(t, errors.As(err, &errs))
+			var failed []string
+			for _, taskError := range errs {
+				failed = append(failed, taskError.Task)
+			}
+			assert.Equal(t, testCase.failed, failed)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		found := make(map[string]string)
+		for address, s := range serversMap {
+			found[address] = s.Name
+		}
+		assert.Equal(t, testCase.expected, found)
+	}
+}
+
+func TestRunBoundsWorkers(t *testing.T) {
+	var running, maxRunning int32
+	var tasks []Task
+	for index := 0; index < 20; index++ {
+		tasks = append(tasks, Task{
+			Name: strconv.Itoa(index),
+			Run: func(serversMap map[string]server.Server) error {
+				current := atomic.AddInt32(&running, 1)
+				for {
+					seen := atomic.LoadInt32(&maxRunning)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+						break
+					}
+				}
+				time.Sleep(2 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			},
+		})
+	}
+
+	err := Run(3, tasks, make(map[string]server.Server))
+	assert.NoError(t, err)
+	assert.True(t, maxRunning <= 3)
+	assert.True(t, maxRunning > 1)
+}
+
+func TestRunRecordsScopes(t *testing.T) {
+	tasks := []Task{
+		task("us-east-1", "1.1.1.1"),
+		{
+			Name: "us-west-2",
+			Run: func(serversMap map[string]server.Server) error {
+				serversMap["2.2.2.2"] = server.Server{Address: "2.2.2.2", Scopes: []string{"lb.example.com"}}
+				return nil
+			},
+		},
+	}
+
+	serversMap := make(map[string]server.Server)
+	err := Run(2, tasks, serversMap)
+	assert.NoError(t, err)
+
+	t.Logf("TestRunRecordsScopes: the name of the task is added to the scopes of its servers")
+	assert.Equal(t, []string{"us-east-1"}, serversMap["1.1.1.1"].Scopes)
+	assert.Equal(t, []string{"us-west-2", "lb.example.com"}, serversMap["2.2.2.2"].Scopes)
+}
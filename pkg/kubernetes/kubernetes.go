@@ -0,0 +1,285 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	ContextTag   = "kubeContext"
+	NamespaceTag = "namespace"
+	ServiceTag   = "service"
+	IngressTag   = "ingress"
+	// ResourceTypeTag holds how the address is exposed: load-balancer, external-ip, ingress or node.
+	ResourceTypeTag = "resourceType"
+	// NodePortServicesTag holds the comma separated namespace/name of the services exposed on the node ports of a
+	// node. Labels of services and ingresses are recorded under their own keys.
+	NodePortServicesTag = "nodePortServices"
+)
+
+// NodePortRange is the default range Kubernetes assigns the node ports of services from.
+const NodePortRange = "30000-32767"
+
+// The ways addresses are exposed.
+const (
+	loadBalancerType = "load-balancer"
+	externalIPType   = "external-ip"
+	ingressType      = "ingress"
+	nodeType         = "node"
+)
+
+type kubernetesSvc struct {
+	clusters []*cluster
+	// workers is the number of clusters inventoried at the same time.
+	workers int
+	// lookupHost resolves the hostnames of load balancers, such as those of AWS load balancers.
+	lookupHost func(host string) ([]string, error)
+}
+
+// cluster is an inventoried cluster, named after its kubeconfig context.
+type cluster struct {
+	context   string
+	clientset kubernetes.Interface
+}
+
+// New returns a service inventorying the clusters of the configured kubeconfig contexts.
+func New(configObject config.BaseConfig) (*kubernetesSvc, error) {
+	kubernetesConfig := config.KubernetesConfig{}
+	if configObject.KubernetesConfig != nil {
+		kubernetesConfig = *configObject.KubernetesConfig
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubernetesConfig.Kubeconfig
+
+	contexts := kubernetesConfig.Contexts
+	if len(contexts) == 0 {
+		rawConfig, err := loadingRules.Load()
+		if err != nil {
+			return nil, fmt.Errorf("New: Error loading kubeconfig %s", err)
+		}
+		if rawConfig.CurrentContext == "" {
+			return nil, fmt.Errorf("New: the kubeconfig has no current context, contexts must be set")
+		}
+		contexts = []string{rawConfig.CurrentContext}
+	}
+
+	k := kubernetesSvc{workers: configObject.InventoryWorkerCount(), lookupHost: net.LookupHost}
+	for _, contextName := range contexts {
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+			&clientcmd.ConfigOverrides{CurrentContext: contextName})
+		restConfig, err := clientConfig.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("New: Error loading context %s %s", contextName, err)
+		}
+
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("New: Error creating client of context %s %s", contextName, err)
+		}
+		k.clusters = append(k.clusters, &cluster{context: contextName, clientset: clientset})
+	}
+	return &k, nil
+}
+
+// Instances records the external addresses of the load balancer services, external IPs, ingresses and node ports of
+// every cluster, inventorying several clusters at the same time. Addresses already inventoried by another provider,
+// such as the nodes and load balancers of EKS and GKE clusters, keep their entry, which carries the identity of the
+// resource, and are still scanned for the node ports of nodes. Clusters that could not be listed and hostnames that
+// could not be resolved are returned as inventory.Errors after the others are recorded.
+func (k *kubernetesSvc) Instances(serversMap map[string]server.Server) error {
+	// Every task only writes its own entry, so the hostnames that could not be resolved need no locking.
+	hostErrors := make([]inventory.Errors, len(k.clusters))
+
+	tasks := make([]inventory.Task, len(k.clusters))
+	for index, c := range k.clusters {
+		index, c := index, c
+		tasks[index] = inventory.Task{
+			Name: c.context,
+			Run: func(clusterServers map[string]server.Server) error {
+				var err error
+				hostErrors[index], err = k.getClusterResources(c, clusterServers)
+				return err
+			},
+		}
+	}
+
+	var errs inventory.Errors
+	clustersMap := make(map[string]server.Server)
+	err := inventory.Run(k.workers, tasks, clustersMap)
+	if err != nil && !errors.As(err, &errs) {
+		return fmt.Errorf("Instances: %s", err)
+	}
+
+	for address, s := range clustersMap {
+		existing, ok := serversMap[address]
+		if !ok {
+			serversMap[address] = s
+			continue
+		}
+		if existing.ExtraPorts == "" {
+			existing.ExtraPorts = s.ExtraPorts
+		}
+		serversMap[address] = existing
+	}
+
+	for index, c := range k.clusters {
+		for _, hostError := range hostErrors[index] {
+			errs = append(errs, &inventory.TaskError{Task: c.context + "/" + hostError.Task, Err: hostError.Err})
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Instances: Error listing resources %w", errs)
+	}
+	return nil
+}
+
+// getClusterResources records the external addresses of the cluster and returns the load balancer hostnames that
+// could not be resolved. An address used by several resources keeps the entry of the first one, services coming
+// before ingresses, so that the address of an ingress controller is recorded as its service.
+func (k *kubernetesSvc) getClusterResources(c *cluster, serversMap map[string]server.Server) (inventory.Errors, error) {
+	var hostErrors inventory.Errors
+	add := func(address string, newServer server.Server) {
+		if _, ok := serversMap[address]; ok {
+			return
+		}
+		newServer.Address = address
+		newServer.Provider = "kubernetes"
+		newServer.Tags[ContextTag] = c.context
+		serversMap[address] = newServer
+	}
+	addIngress := func(ingress corev1.LoadBalancerIngress, objectMeta metav1.ObjectMeta, newServer func() server.Server) {
+		if ingress.IP != "" {
+			add(ingress.IP, newServer())
+			return
+		}
+		if ingress.Hostname == "" {
+			return
+		}
+
+		scope := objectMeta.Namespace + "/" + objectMeta.Name
+		addresses, err := k.lookupHost(ingress.Hostname)
+		if err != nil {
+			hostErrors = append(hostErrors, &inventory.TaskError{Task: scope, Err: err})
+			return
+		}
+		for _, address := range addresses {
+			// Hostnames that cannot be resolved are reported with the cluster and the resource, which is recorded as
+			// the scope of the addresses they resolve to.
+			resolved := newServer()
+			resolved.Scopes = []string{c.context + "/" + scope}
+			add(address, resolved)
+		}
+	}
+
+	services, err := c.clientset.CoreV1().Services(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getClusterResources: Error listing services %s", err)
+	}
+
+	var nodePortServices []string
+	for _, service := range services.Items {
+		service := service
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			addIngress(ingress, service.ObjectMeta, func() server.Server {
+				return newServer(service.ObjectMeta, ServiceTag, loadBalancerType)
+			})
+		}
+		for _, address := range service.Spec.ExternalIPs {
+			add(address, newServer(service.ObjectMeta, ServiceTag, externalIPType))
+		}
+		if hasNodePorts(&service) {
+			nodePortServices = append(nodePortServices, service.Namespace+"/"+service.Name)
+		}
+	}
+
+	ingresses, err := c.clientset.NetworkingV1().Ingresses(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Info("Skipping ingresses of ", c.context, " which does not serve networking.k8s.io/v1")
+	} else if err != nil {
+		return nil, fmt.Errorf("getClusterResources: Error listing ingresses %s", err)
+	} else {
+		for _, ingress := range ingresses.Items {
+			ingress := ingress
+			for _, loadBalancerIngress := range ingress.Status.LoadBalancer.Ingress {
+				addIngress(loadBalancerIngress, ingress.ObjectMeta, func() server.Server {
+					return newServer(ingress.ObjectMeta, IngressTag, ingressType)
+				})
+			}
+		}
+	}
+
+	if len(nodePortServices) == 0 {
+		return hostErrors, nil
+	}
+
+	nodes, err := c.clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getClusterResources: Error listing nodes %s", err)
+	}
+
+	sort.Strings(nodePortServices)
+	for _, node := range nodes.Items {
+		for _, nodeAddress := range node.Status.Addresses {
+			if nodeAddress.Type != corev1.NodeExternalIP {
+				continue
+			}
+			add(nodeAddress.Address, server.Server{
+				Name:       node.Name,
+				ResourceID: string(node.UID),
+				ExtraPorts: NodePortRange,
+				Tags: map[string]string{
+					ResourceTypeTag:     nodeType,
+					NodePortServicesTag: strings.Join(nodePortServices, ","),
+				},
+			})
+		}
+	}
+	return hostErrors, nil
+}
+
+// newServer returns the entry of a service or ingress, named namespace/name, identified by its UID and tagged with its
+// labels.
+func newServer(objectMeta metav1.ObjectMeta, kindTag string, resourceType string) server.Server {
+	newServer := server.Server{
+		Name:       objectMeta.Namespace + "/" + objectMeta.Name,
+		ResourceID: string(objectMeta.UID),
+		Tags:       make(map[string]string),
+	}
+	for key, value := range objectMeta.Labels {
+		newServer.Tags[key] = value
+	}
+	newServer.Tags[NamespaceTag] = objectMeta.Namespace
+	newServer.Tags[kindTag] = objectMeta.Name
+	newServer.Tags[ResourceTypeTag] = resourceType
+	return newServer
+}
+
+// hasNodePorts returns whether the service listens on the node ports of every node, which NodePort services and, by
+// default, LoadBalancer services do.
+func hasNodePorts(service *corev1.Service) bool {
+	if service.Spec.Type != corev1.ServiceTypeNodePort && service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return false
+	}
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			return true
+		}
+	}
+	return false
+}
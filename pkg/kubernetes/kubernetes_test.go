@@ -0,0 +1,219 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func service(namespace string, name string, serviceType corev1.ServiceType, labels map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec:       corev1.ServiceSpec{Type: serviceType},
+	}
+}
+
+func TestInstances(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	web := service("web", "frontend", corev1.ServiceTypeLoadBalancer, map[string]string{"app": "frontend"})
+	web.UID = "5b1f3c2e-service"
+	web.Spec.Ports = []corev1.ServicePort{{Port: 443, NodePort: 30443}}
+	web.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "1.1.1.1"}}
+
+	ingressController := service("ingress-nginx", "controller", corev1.ServiceTypeLoadBalancer, nil)
+	ingressController.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{
+		{Hostname: "abc.elb.amazonaws.com"},
+		{Hostname: "missing.elb.amazonaws.com"},
+	}
+
+	legacy := service("legacy", "ftp", corev1.ServiceTypeNodePort, nil)
+	legacy.Spec.Ports = []corev1.ServicePort{{Port: 21, NodePort: 30021}}
+
+	external := service("legacy", "smtp", corev1.ServiceTypeClusterIP, nil)
+	external.Spec.ExternalIPs = []string{"4.4.4.4"}
+
+	internal := service("kube-system", "kube-dns", corev1.ServiceTypeClusterIP, nil)
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "storefront", Labels: map[string]string{"team": "shop"}},
+		Status: networkingv1.IngressStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{Hostname: "abc.elb.amazonaws.com"}, {IP: "5.5.5.5"}},
+		}},
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", UID: "9d4e7a10-node"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			{Type: corev1.NodeExternalIP, Address: "6.6.6.6"},
+		}},
+	}
+
+	k := kubernetesSvc{
+		clusters: []*cluster{
+			{context: "prod", clientset: fake.NewSimpleClientset(web, ingressController, legacy, external, internal, ingress, node)},
+		},
+		workers: 1,
+		lookupHost: func(host string) ([]string, error) {
+			if host == "abc.elb.amazonaws.com" {
+				return []string{"2.2.2.2", "3.3.3.3"}, nil
+			}
+			return nil, fmt.Errorf("no such host")
+		},
+	}
+
+	serversMap := make(map[string]server.Server)
+	err := k.Instances(serversMap)
+
+	t.Logf("TestInstances: hostnames that cannot be resolved are returned after recording the other addresses")
+	var hostErrors inventory.Errors
+	assert.True(t, errors.As(err, &hostErrors))
+	assert.Equal(t, 1, len(hostErrors))
+	assert.Equal(t, "prod/ingress-nginx/controller", hostErrors[0].Task)
+
+	var addresses []string
+	for address := range serversMap {
+		addresses = append(addresses, address)
+	}
+	assert.ElementsMatch(t, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5", "6.6.6.6"}, addresses)
+
+	t.Logf("TestInstances: services are tagged with their namespace, name and labels")
+	assert.Equal(t, "web/frontend", serversMap["1.1.1.1"].Name)
+	assert.Equal(t, "kubernetes", serversMap["1.1.1.1"].Provider)
+	assert.Equal(t, "kubernetes:5b1f3c2e-service", serversMap["1.1.1.1"].Identity())
+	assert.Equal(t, map[string]string{
+		"app":           "frontend",
+		NamespaceTag:    "web",
+		ServiceTag:      "frontend",
+		ResourceTypeTag: "load-balancer",
+		ContextTag:      "prod",
+	}, serversMap["1.1.1.1"].Tags)
+	assert.Equal(t, "external-ip", serversMap["4.4.4.4"].Tags[ResourceTypeTag])
+
+	t.Logf("TestInstances: the address an ingress shares with its controller is recorded as the service")
+	assert.Equal(t, "ingress-nginx/controller", serversMap["2.2.2.2"].Name)
+	assert.Equal(t, "shop/storefront", serversMap["5.5.5.5"].Name)
+	assert.Equal(t, "storefront", serversMap["5.5.5.5"].Tags[IngressTag])
+	assert.Equal(t, "shop", serversMap["5.5.5.5"].Tags["team"])
+
+	t.Logf("TestInstances: addresses are recorded with their cluster as scope, and with their resource when resolved")
+	assert.Equal(t, []string{"prod"}, serversMap["1.1.1.1"].Scopes)
+	assert.Equal(t, []string{"prod", "prod/ingress-nginx/controller"}, serversMap["2.2.2.2"].Scopes)
+
+	t.Logf("TestInstances: nodes with an external address are recorded with the services on their node ports")
+	assert.Equal(t, "node-1", serversMap["6.6.6.6"].Name)
+	assert.Equal(t, "9d4e7a10-node", serversMap["6.6.6.6"].ResourceID)
+	assert.Equal(t, "legacy/ftp,web/frontend", serversMap["6.6.6.6"].Tags[NodePortServicesTag])
+
+	t.Logf("TestInstances: only nodes are scanned for the node port range")
+	assert.Equal(t, NodePortRange, serversMap["6.6.6.6"].ExtraPorts)
+	assert.Equal(t, "", serversMap["1.1.1.1"].ExtraPorts)
+
+	t.Logf("TestInstances: addresses inventoried by a cloud provider keep their entry and are scanned for node ports")
+	loadBalancer := server.Server{Name: "a1b2c3", Address: "2.2.2.2", Provider: "aws", ResourceID: "arn:aws:elasticloadbalancing"}
+	instance := server.Server{Name: "i-123", Address: "6.6.6.6", Provider: "aws", ResourceID: "i-123"}
+	serversMap = map[string]server.Server{loadBalancer.Address: loadBalancer, instance.Address: instance}
+	k.Instances(serversMap)
+	assert.Equal(t, loadBalancer, serversMap["2.2.2.2"])
+	instance.ExtraPorts = NodePortRange
+	assert.Equal(t, instance, serversMap["6.6.6.6"])
+	assert.Equal(t, "kubernetes", serversMap["3.3.3.3"].Provider)
+}
+
+func TestInstancesClusterErrors(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	forbidden := fake.NewSimpleClientset()
+	forbidden.PrependReactor("list", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("services is forbidden")
+	})
+
+	noNodePorts := service("web", "frontend", corev1.ServiceTypeLoadBalancer, nil)
+	noNodePorts.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "1.1.1.1"}}
+	reachable := fake.NewSimpleClientset(noNodePorts)
+
+	k := kubernetesSvc{
+		clusters: []*cluster{
+			{context: "staging", clientset: forbidden},
+			{context: "prod", clientset: reachable},
+		},
+		workers: 2,
+	}
+
+	t.Logf("TestInstancesClusterErrors: clusters that could not be listed are returned after recording the others")
+	serversMap := make(map[string]server.Server)
+	err := k.Instances(serversMap)
+	var clusterErrors inventory.Errors
+	assert.True(t, errors.As(err, &clusterErrors))
+	assert.Equal(t, 1, len(clusterErrors))
+	assert.Equal(t, "staging", clusterErrors[0].Task)
+	assert.Contains(t, serversMap, "1.1.1.1")
+
+	t.Logf("TestInstancesClusterErrors: nodes are not listed without node port services")
+	for _, action := range reachable.Actions() {
+		assert.NotEqual(t, "nodes", action.GetResource().Resource)
+	}
+}
+
+func TestNew(t *testing.T) {
+	directory, err := ioutil.TempDir("", "nmap-diff-kubernetes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	kubeconfig := filepath.Join(directory, "kubeconfig")
+	assert.NoError(t, ioutil.WriteFile(kubeconfig, []byte(`
+apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: prod
+  cluster: {server: "https://prod.example.com"}
+- name: staging
+  cluster: {server: "https://staging.example.com"}
+contexts:
+- name: prod
+  context: {cluster: prod, user: admin}
+- name: staging
+  context: {cluster: staging, user: admin}
+users:
+- name: admin
+  user: {token: secret}
+`), 0600))
+
+	t.Logf("TestNew: the current context is inventoried by default")
+	k, err := New(config.BaseConfig{KubernetesConfig: &config.KubernetesConfig{Kubeconfig: kubeconfig}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(k.clusters))
+	assert.Equal(t, "prod", k.clusters[0].context)
+
+	t.Logf("TestNew: every configured context is inventoried")
+	k, err = New(config.BaseConfig{KubernetesConfig: &config.KubernetesConfig{
+		Kubeconfig: kubeconfig,
+		Contexts:   []string{"prod", "staging"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(k.clusters))
+
+	t.Logf("TestNew: unknown contexts are rejected")
+	_, err = New(config.BaseConfig{KubernetesConfig: &config.KubernetesConfig{
+		Kubeconfig: kubeconfig,
+		Contexts:   []string{"dev"},
+	}})
+	assert.Error(t, err)
+}
@@ -5,8 +5,16 @@ import (
 	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
 
 type MockAWSWrapper struct {
@@ -18,6 +26,26 @@ type MockEC2API struct {
 	ResettableMock
 }
 
+type MockELBV2API struct {
+	elbv2iface.ELBV2API
+	ResettableMock
+}
+
+type MockELBAPI struct {
+	elbiface.ELBAPI
+	ResettableMock
+}
+
+type MockSTSAPI struct {
+	stsiface.STSAPI
+	ResettableMock
+}
+
+type MockIAMAPI struct {
+	iamiface.IAMAPI
+	ResettableMock
+}
+
 type MockS3API struct {
 	s3iface.S3API
 	ResettableMock
@@ -33,6 +61,74 @@ func (m *MockEC2API) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.
 	}
 }
 
+// DescribeInstancesPages calls fn with every page returned by the mock, which is a []*ec2.DescribeInstancesOutput.
+func (m *MockEC2API) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	fmt.Println("DescribeInstancesPages Mock")
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		pages := args.Get(0).([]*ec2.DescribeInstancesOutput)
+		for index, page := range pages {
+			if !fn(page, index == len(pages)-1) {
+				break
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+// DescribeNatGatewaysPages calls fn with every page returned by the mock, which is a []*ec2.DescribeNatGatewaysOutput.
+func (m *MockEC2API) DescribeNatGatewaysPages(input *ec2.DescribeNatGatewaysInput, fn func(*ec2.DescribeNatGatewaysOutput, bool) bool) error {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		pages := args.Get(0).([]*ec2.DescribeNatGatewaysOutput)
+		for index, page := range pages {
+			if !fn(page, index == len(pages)-1) {
+				break
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockEC2API) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	args := m.Called(input)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).(*ec2.DescribeAddressesOutput), args.Error(1)
+	}
+}
+
+// DescribeLoadBalancersPages calls fn with every page returned by the mock, which is a
+// []*elbv2.DescribeLoadBalancersOutput.
+func (m *MockELBV2API) DescribeLoadBalancersPages(input *elbv2.DescribeLoadBalancersInput, fn func(*elbv2.DescribeLoadBalancersOutput, bool) bool) error {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		pages := args.Get(0).([]*elbv2.DescribeLoadBalancersOutput)
+		for index, page := range pages {
+			if !fn(page, index == len(pages)-1) {
+				break
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+// DescribeLoadBalancersPages calls fn with every page returned by the mock, which is a
+// []*elb.DescribeLoadBalancersOutput.
+func (m *MockELBAPI) DescribeLoadBalancersPages(input *elb.DescribeLoadBalancersInput, fn func(*elb.DescribeLoadBalancersOutput, bool) bool) error {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		pages := args.Get(0).([]*elb.DescribeLoadBalancersOutput)
+		for index, page := range pages {
+			if !fn(page, index == len(pages)-1) {
+				break
+			}
+		}
+	}
+	return args.Error(1)
+}
+
 func (m *MockEC2API) DescribeRegions(input *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
 	fmt.Println("DescribeRegionsInput Mock")
 	args := m.Called(input)
@@ -63,6 +159,15 @@ func (m *MockS3API) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, er
 	}
 }
 
+func (m *MockS3API) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	args := m.Called(input)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+	}
+}
+
 func (m *MockAWSWrapper) Instances(map[string]server.Server) error {
 	args := m.Called(nil)
 	if args.Get(0) == nil {
@@ -72,20 +177,20 @@ func (m *MockAWSWrapper) Instances(map[string]server.Server) error {
 	}
 }
 
-func (m *MockAWSWrapper) UploadObjectToS3(fileData []byte, s3Key string) error {
-	args := m.Called(nil)
+func (m *MockSTSAPI) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	args := m.Called(input)
 	if args.Get(0) == nil {
-		return args.Error(0)
+		return nil, args.Error(1)
 	} else {
-		return args.Error(0)
+		return args.Get(0).(*sts.GetCallerIdentityOutput), args.Error(1)
 	}
 }
 
-func (m *MockAWSWrapper) GetFileFromS3(s3Key string) ([]byte, error) {
-	args := m.Called(nil)
+func (m *MockIAMAPI) ListAccountAliases(input *iam.ListAccountAliasesInput) (*iam.ListAccountAliasesOutput, error) {
+	args := m.Called(input)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	} else {
-		return args.Get(0).([]byte), args.Error(1)
+		return args.Get(0).(*iam.ListAccountAliasesOutput), args.Error(1)
 	}
 }
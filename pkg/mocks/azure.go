@@ -0,0 +1,55 @@
+package mocks
+
+import (
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+)
+
+type AzureMock struct {
+	ResettableMock
+}
+
+func (a *AzureMock) Subscriptions() ([]string, error) {
+	args := a.Called(nil)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]string), args.Error(1)
+	}
+}
+
+func (a *AzureMock) PublicIPAddresses(subscriptionID string) ([]*wrapper.AzurePublicIPAddress, error) {
+	args := a.Called(subscriptionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]*wrapper.AzurePublicIPAddress), args.Error(1)
+	}
+}
+
+func (a *AzureMock) NetworkInterfaces(subscriptionID string) ([]*wrapper.AzureNetworkInterface, error) {
+	args := a.Called(subscriptionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]*wrapper.AzureNetworkInterface), args.Error(1)
+	}
+}
+
+func (a *AzureMock) VirtualMachines(subscriptionID string) ([]*wrapper.AzureResource, error) {
+	args := a.Called(subscriptionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]*wrapper.AzureResource), args.Error(1)
+	}
+}
+
+type AzureInterfaceMock struct {
+	ResettableMock
+}
+
+func (a *AzureInterfaceMock) Instances(serversMap map[string]server.Server) error {
+	args := a.Called(nil)
+	return args.Error(0)
+}
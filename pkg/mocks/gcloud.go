@@ -15,23 +15,49 @@ type GCloudMock struct {
 	ResettableMock
 }
 
-func (g *GCloudMock) Zones() ([]string, error) {
-	fmt.Println("Zones() Mock")
+func (g *GCloudMock) AggregatedInstances() (map[string]compute.InstancesScopedList, error) {
+	fmt.Println("AggregatedInstances() Mock")
 	args := g.Called(nil)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	} else {
-		return args.Get(0).([]string), args.Error(1)
+		return args.Get(0).(map[string]compute.InstancesScopedList), args.Error(1)
+	}
+}
+
+func (g *GCloudMock) AggregatedForwardingRules() (map[string]compute.ForwardingRulesScopedList, error) {
+	args := g.Called(nil)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).(map[string]compute.ForwardingRulesScopedList), args.Error(1)
+	}
+}
+
+func (g *GCloudMock) GlobalForwardingRules() ([]*compute.ForwardingRule, error) {
+	args := g.Called(nil)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]*compute.ForwardingRule), args.Error(1)
+	}
+}
+
+func (g *GCloudMock) AggregatedAddresses() (map[string]compute.AddressesScopedList, error) {
+	args := g.Called(nil)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).(map[string]compute.AddressesScopedList), args.Error(1)
 	}
 }
 
-func (g *GCloudMock) InstancesInRegion(region string) ([]compute.Instance, error) {
-	fmt.Println("InstancesIPsInRegion() Mock")
-	args := g.Called(region)
+func (g *GCloudMock) GlobalAddresses() ([]*compute.Address, error) {
+	args := g.Called(nil)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	} else {
-		return args.Get(0).([]compute.Instance), args.Error(1)
+		return args.Get(0).([]*compute.Address), args.Error(1)
 	}
 }
 
@@ -47,3 +73,25 @@ func (g *GCloudInterfaceMock) Instances(serversMap map[string]server.Server) err
 		return args.Get(0).(error)
 	}
 }
+
+type ProjectListerMock struct {
+	ResettableMock
+}
+
+func (p *ProjectListerMock) Folders(parent string) ([]string, error) {
+	args := p.Called(parent)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]string), args.Error(1)
+	}
+}
+
+func (p *ProjectListerMock) Projects(parent string) ([]string, error) {
+	args := p.Called(parent)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]string), args.Error(1)
+	}
+}
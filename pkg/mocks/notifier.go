@@ -0,0 +1,12 @@
+package mocks
+
+import "github.com/Invoca/nmap-diff/pkg/wrapper"
+
+type NotifierMock struct {
+	ResettableMock
+}
+
+func (n *NotifierMock) Notify(report wrapper.Report) error {
+	args := n.Called(nil)
+	return args.Error(0)
+}
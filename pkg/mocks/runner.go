@@ -0,0 +1,12 @@
+package mocks
+
+import "github.com/Invoca/nmap-diff/pkg/config"
+
+type RunnerMock struct {
+	ResettableMock
+}
+
+func (r *RunnerMock) Execute(configObject config.BaseConfig) error {
+	args := r.Called(nil)
+	return args.Error(0)
+}
@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"context"
+	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	"github.com/Ullaakut/nmap"
 	log "github.com/sirupsen/logrus"
@@ -15,7 +16,7 @@ type ScannerMock struct {
 	ResettableMock
 }
 
-func (s *ScannerMock) Run([]string, context.Context) (*nmap.Run, []string, error) {
+func (s *ScannerMock) Run([]string, string, context.Context) (*nmap.Run, []string, error) {
 	args := s.Called(nil)
 	if args.Get(0) == nil {
 		return nil, []string{}, args.Error(1)
@@ -41,17 +42,23 @@ func (n *NmapScannerMock) ParsePreviousScan(scanBytes []byte) error {
 	return args.Error(0)
 }
 
-func (n *NmapScannerMock) StartScan(ipAddresses []string) error {
+func (n *NmapScannerMock) StartScan(ipAddresses []string, servers map[string]server.Server) error {
 	log.Debug("StartScan Called")
 	args := n.Called(nil)
 	return args.Error(0)
 }
 
-func (n *NmapScannerMock) DiffScans() map[string]wrapper.PortMap {
+func (n *NmapScannerMock) CarryForward(ipAddresses []string, inventoryErrors []wrapper.InventoryError) error {
+	log.Debug("CarryForward Called")
+	args := n.Called(nil)
+	return args.Error(0)
+}
+
+func (n *NmapScannerMock) DiffScans() wrapper.ScanDiff {
 	args := n.Called(nil)
 	if args.Get(0) == nil {
-		return nil
+		return wrapper.NewScanDiff()
 	} else {
-		return args.Get(0).(map[string]wrapper.PortMap)
+		return args.Get(0).(wrapper.ScanDiff)
 	}
 }
@@ -0,0 +1,44 @@
+package mocks
+
+import "time"
+
+type ScanStoreMock struct {
+	ResettableMock
+}
+
+func (m *ScanStoreMock) Load(key string) ([]byte, error) {
+	args := m.Called(nil)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]byte), args.Error(1)
+	}
+}
+
+func (m *ScanStoreMock) Save(key string, data []byte) error {
+	args := m.Called(nil)
+	return args.Error(0)
+}
+
+func (m *ScanStoreMock) Delete(key string) error {
+	args := m.Called(nil)
+	return args.Error(0)
+}
+
+type ScanHistoryMock struct {
+	ResettableMock
+}
+
+func (m *ScanHistoryMock) Load(ref string) ([]byte, error) {
+	args := m.Called(nil)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	} else {
+		return args.Get(0).([]byte), args.Error(1)
+	}
+}
+
+func (m *ScanHistoryMock) Record(data []byte, takenAt time.Time) error {
+	args := m.Called(nil)
+	return args.Error(0)
+}
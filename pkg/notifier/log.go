@@ -0,0 +1,66 @@
+package notifier
+
+import (
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+)
+
+// logNotifier writes every change in the report to the log. It is useful when running without any other sink or for
+// shipping changes through a log pipeline.
+type logNotifier struct{}
+
+func (l *logNotifier) Notify(report wrapper.Report) error {
+	for _, inventoryError := range report.InventoryErrors {
+		log.WithFields(log.Fields{
+			"provider": inventoryError.Provider,
+			"scope":    inventoryError.Scope,
+			"error":    inventoryError.Message,
+		}).Warn("Inventory incomplete")
+	}
+	for _, host := range report.OutsideInventory {
+		hostEntry(host).Warn("Outside of the inventory")
+	}
+
+	if report.Baseline {
+		log.Info(report.BaselineSummary())
+		return nil
+	}
+
+	for _, host := range report.AddressChanges() {
+		hostEntry(host).WithField("previousAddress", host.PreviousAddress).Warn("Address changed")
+	}
+	for _, host := range report.ReassignedAddresses() {
+		hostEntry(host).WithFields(log.Fields{
+			"previousIdentity": host.PreviousIdentity,
+			"identity":         host.Identity(),
+		}).Warn("Address reassigned")
+	}
+	for _, host := range report.NewHosts() {
+		hostEntry(host).WithField("ports", host.OpenedPorts).Warn("New host")
+	}
+	for _, host := range report.OpenedPorts() {
+		hostEntry(host).WithField("ports", host.OpenedPorts).Warn("Opened ports")
+	}
+	for _, host := range report.ClosedPorts() {
+		hostEntry(host).WithField("ports", host.ClosedPorts).Warn("Closed ports")
+	}
+	for _, host := range report.ChangedServices() {
+		hostEntry(host).WithField("changes", host.ChangedServices).Warn("Changed services")
+	}
+	for _, host := range report.StateTransitions() {
+		hostEntry(host).WithField("transitions", host.StateTransitions).Warn("Port state transitions")
+	}
+	for _, host := range report.RemovedHosts() {
+		hostEntry(host).WithField("ports", host.ClosedPorts).Warn("Removed host")
+	}
+	return nil
+}
+
+func hostEntry(host server.Server) *log.Entry {
+	return log.WithFields(log.Fields{
+		"name":    host.Name,
+		"address": host.Address,
+		"tags":    host.Tags,
+	})
+}
@@ -0,0 +1,49 @@
+package notifier
+
+import (
+	"fmt"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/slack"
+	"github.com/Invoca/nmap-diff/pkg/webhook"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+)
+
+// New creates a notifier for every notifier registered in the config. SlackConfig is registered as a slack notifier
+// when its SlackURL or Token is set. No notifiers are returned when none are registered.
+func New(configObject config.BaseConfig) ([]wrapper.Notifier, error) {
+	notifierConfigs := configObject.Notifiers
+	if configObject.SlackConfig != nil && (configObject.SlackConfig.SlackURL != "" || configObject.SlackConfig.Token != "") {
+		notifierConfigs = append([]*config.NotifierConfig{{
+			Type:        config.SlackNotifier,
+			SlackConfig: configObject.SlackConfig,
+		}}, notifierConfigs...)
+	}
+
+	var notifiers []wrapper.Notifier
+	for index, notifierConfig := range notifierConfigs {
+		if notifierConfig == nil {
+			return nil, fmt.Errorf("New: notifier %d cannot be nil", index)
+		}
+
+		n, err := newNotifier(notifierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("New: Error creating %s notifier %s", notifierConfig.Type, err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+func newNotifier(notifierConfig *config.NotifierConfig) (wrapper.Notifier, error) {
+	switch notifierConfig.Type {
+	case config.SlackNotifier:
+		return slack.New(notifierConfig.SlackConfig)
+	case config.WebhookNotifier:
+		return webhook.New(notifierConfig.WebhookConfig)
+	case config.LogNotifier:
+		return &logNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("newNotifier: unknown notifier type %q", notifierConfig.Type)
+	}
+}
@@ -0,0 +1,75 @@
+package notifier
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type notifierTestCase struct {
+	desc          string
+	configObject  config.BaseConfig
+	expectedCount int
+	shouldError   bool
+}
+
+func TestNew(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	testCases := []notifierTestCase{
+		{
+			desc:          "No notifiers are created when none are registered",
+			configObject:  config.BaseConfig{SlackConfig: &config.SlackConfig{}},
+			expectedCount: 0,
+		},
+		{
+			desc:          "A slack notifier is created from SlackConfig",
+			configObject:  config.BaseConfig{SlackConfig: &config.SlackConfig{SlackURL: "http://test.com/aaa/bbb/ccc"}},
+			expectedCount: 1,
+		},
+		{
+			desc: "Every registered notifier is created",
+			configObject: config.BaseConfig{
+				SlackConfig: &config.SlackConfig{SlackURL: "http://test.com/aaa/bbb/ccc"},
+				Notifiers: []*config.NotifierConfig{
+					{Type: config.WebhookNotifier, WebhookConfig: &config.WebhookConfig{URL: "http://test.com/hook"}},
+					{Type: config.LogNotifier},
+				},
+			},
+			expectedCount: 3,
+		},
+		{
+			desc: "Error if a notifier type is unknown",
+			configObject: config.BaseConfig{
+				Notifiers: []*config.NotifierConfig{{Type: "carrier-pigeon"}},
+			},
+			shouldError: true,
+		},
+		{
+			desc: "Error if a notifier is missing its config",
+			configObject: config.BaseConfig{
+				Notifiers: []*config.NotifierConfig{{Type: config.WebhookNotifier}},
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		notifiers, err := New(testCase.configObject)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedCount, len(notifiers))
+		}
+	}
+}
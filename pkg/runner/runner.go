@@ -1,25 +1,62 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/Invoca/nmap-diff/pkg/aws"
+	"github.com/Invoca/nmap-diff/pkg/azure"
 	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/dnszone"
 	"github.com/Invoca/nmap-diff/pkg/gcloud"
+	"github.com/Invoca/nmap-diff/pkg/history"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/kubernetes"
+	"github.com/Invoca/nmap-diff/pkg/notifier"
 	"github.com/Invoca/nmap-diff/pkg/scanner"
 	"github.com/Invoca/nmap-diff/pkg/server"
-	"github.com/Invoca/nmap-diff/pkg/slack"
+	"github.com/Invoca/nmap-diff/pkg/static"
+	"github.com/Invoca/nmap-diff/pkg/store"
+	"github.com/Invoca/nmap-diff/pkg/terraform"
 	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	log "github.com/sirupsen/logrus"
 )
 
 type Runner struct {
-	awsSvc       wrapper.AwsSvc
-	gCloudSvc    wrapper.GCloudSvc
-	slackSvc     wrapper.SlackSvc
+	awsSvc    wrapper.AwsSvc
+	gCloudSvc wrapper.GCloudSvc
+	azureSvc  wrapper.AzureSvc
+	staticSvc wrapper.StaticSvc
+	// terraformSvc inventories the resources of Terraform state files.
+	terraformSvc wrapper.TerraformSvc
+	notifiers    []wrapper.Notifier
 	nmapSvc      wrapper.NmapSvc
+	scanStore    wrapper.ScanStore
+	// kubernetesSvc inventories the exposed services of Kubernetes clusters.
+	kubernetesSvc wrapper.KubernetesSvc
+	// dnsZoneSvc inventories the addresses DNS zones point at. It runs after the other providers to flag the addresses
+	// they did not inventory.
+	dnsZoneSvc wrapper.DNSZoneSvc
+	// scanHistory keeps every scan when history is configured. compareTo selects the scan in it to diff against.
+	scanHistory  wrapper.ScanHistory
+	compareTo    string
 	enableAWS    bool
 	enableGCloud bool
+	enableAzure  bool
+	enableStatic bool
+	// enableKubernetes is set when the clusters of KubernetesConfig are inventoried.
+	enableKubernetes bool
+	enableTerraform  bool
+	enableDNSZones   bool
+	// notifyTransitions holds the port state transition patterns that are notified.
+	notifyTransitions []string
+	// baselineNotification selects what is notified when the current scan becomes the baseline.
+	baselineNotification string
+	// bestEffortInventory scans whatever was inventoried when some regions or zones fail instead of aborting.
+	bestEffortInventory bool
 }
 
 func (r *Runner) Execute(configObject config.BaseConfig) error {
@@ -40,17 +77,61 @@ func newRunner(configObject config.BaseConfig) (*Runner, error) {
 	r := &Runner{}
 	r.enableAWS = configObject.IncludeAWS
 	r.enableGCloud = configObject.IncludeGCloud
-	log.Debug("Configuring AWS package")
+	r.enableAzure = configObject.IncludeAzure
+	r.enableKubernetes = configObject.IncludeKubernetes
+	r.enableStatic = configObject.StaticConfig != nil && len(configObject.StaticConfig.Files) > 0
+	r.enableTerraform = configObject.TerraformConfig != nil && len(configObject.TerraformConfig.StateFiles) > 0
+	r.enableDNSZones = configObject.DNSConfig != nil && len(configObject.DNSConfig.ZoneFiles)+
+		len(configObject.DNSConfig.Route53Zones)+len(configObject.DNSConfig.CloudDNSZones) > 0
 
-	r.awsSvc, err = aws.New(configObject)
+	err = configObject.ValidateNotifyTransitions()
 	if err != nil {
-		return nil, fmt.Errorf("newRunner: error configuring AWS %s", err)
+		return nil, fmt.Errorf("newRunner: %s", err)
 	}
+	r.notifyTransitions = configObject.NotifyTransitions
 
-	log.Debug("Configuring slack package")
-	r.slackSvc, err = slack.New(configObject)
+	err = configObject.ValidateBaselineNotification()
 	if err != nil {
-		return nil, fmt.Errorf("newRunner: Unable to create slack Interface %s", err)
+		return nil, fmt.Errorf("newRunner: %s", err)
+	}
+	r.baselineNotification = configObject.BaselineNotification
+
+	err = configObject.ValidateInventoryErrorPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("newRunner: %s", err)
+	}
+	r.bestEffortInventory = configObject.InventoryErrorPolicy == config.InventoryBestEffort
+
+	if r.enableAWS {
+		log.Debug("Configuring AWS package")
+		r.awsSvc, err = aws.New(configObject)
+		if err != nil {
+			return nil, fmt.Errorf("newRunner: error configuring AWS %s", err)
+		}
+	}
+
+	log.Debug("Configuring scan store")
+	r.scanStore, err = store.New(configObject)
+	if err != nil {
+		return nil, fmt.Errorf("newRunner: error configuring scan store %s", err)
+	}
+
+	if configObject.HistoryConfig != nil {
+		log.Debug("Configuring scan history")
+		r.scanHistory, err = history.New(r.scanStore, configObject.HistoryConfig)
+		if err != nil {
+			return nil, fmt.Errorf("newRunner: error configuring scan history %s", err)
+		}
+		r.compareTo = configObject.HistoryConfig.CompareTo
+	}
+
+	log.Debug("Configuring notifiers")
+	r.notifiers, err = notifier.New(configObject)
+	if err != nil {
+		return nil, fmt.Errorf("newRunner: Unable to create notifiers %s", err)
+	}
+	if len(r.notifiers) == 0 {
+		log.Warn("No notifiers are configured, changes will not be reported")
 	}
 
 	if r.enableGCloud {
@@ -61,30 +142,63 @@ func newRunner(configObject config.BaseConfig) (*Runner, error) {
 		}
 	}
 
-	r.nmapSvc = scanner.New()
-	return r, nil
-}
+	if r.enableTerraform {
+		log.Debug("Configuring terraform package")
+		r.terraformSvc, err = terraform.New(configObject)
+		if err != nil {
+			return nil, fmt.Errorf("newRunner: error configuring Terraform state files %s", err)
+		}
+	}
 
-func (r *Runner) run(configObject config.BaseConfig) error {
-	var err error
-	serversMap := make(map[string]server.Server)
+	if r.enableAzure {
+		log.Debug("Configuring azure package")
+		r.azureSvc, err = azure.New(configObject)
+		if err != nil {
+			return nil, fmt.Errorf("newRunner: error configuring Azure %s", err)
+		}
+	}
 
-	if r.enableAWS {
-		log.Debug("Fetching Instances From AWS")
-		err = r.awsSvc.Instances(serversMap)
+	if r.enableKubernetes {
+		log.Debug("Configuring kubernetes package")
+		r.kubernetesSvc, err = kubernetes.New(configObject)
 		if err != nil {
-			return fmt.Errorf("Run: Unable to run get AWS Instances: %s", err)
+			return nil, fmt.Errorf("newRunner: error configuring Kubernetes %s", err)
 		}
 	}
 
-	if r.enableGCloud {
-		log.Debug("Fetching Instances From GCloud")
-		err = r.gCloudSvc.Instances(serversMap)
+	if r.enableStatic {
+		log.Debug("Configuring static package")
+		r.staticSvc, err = static.New(configObject)
+		if err != nil {
+			return nil, fmt.Errorf("newRunner: error configuring targets files %s", err)
+		}
+	}
+
+	if r.enableDNSZones {
+		log.Debug("Configuring dnszone package")
+		r.dnsZoneSvc, err = dnszone.New(configObject)
 		if err != nil {
-			return fmt.Errorf("Run: Unable to run get Google Cloud Instances: %s", err)
+			return nil, fmt.Errorf("newRunner: error configuring DNS zones %s", err)
 		}
 	}
 
+	log.Debug("Configuring scanner package")
+	r.nmapSvc, err = scanner.New(configObject)
+	if err != nil {
+		return nil, fmt.Errorf("newRunner: error configuring scanner %s", err)
+	}
+	return r, nil
+}
+
+func (r *Runner) run(configObject config.BaseConfig) error {
+	var err error
+	serversMap := make(map[string]server.Server)
+
+	inventoryErrors, err := r.collectInventory(serversMap)
+	if err != nil {
+		return fmt.Errorf("Run: %s", err)
+	}
+
 	log.Debug("Parsing servers map to slice")
 	ipAddresses := make([]string, len(serversMap))
 	i := 0
@@ -93,25 +207,43 @@ func (r *Runner) run(configObject config.BaseConfig) error {
 		i += 1
 	}
 
-	log.Debug("Fetching previous scan from S3")
-	scanBytes, err := r.awsSvc.GetFileFromS3(configObject.PreviousFileName)
-	if err != nil {
+	// Without a previous scan, the current scan becomes the baseline for the next run.
+	establishBaseline := false
+	scanBytes, err := r.loadBaseline(configObject.PreviousFileName)
+	if errors.Is(err, wrapper.ErrNotFound) {
+		log.Info("No previous scan found, the current scan will be stored as the baseline")
+		establishBaseline = true
+	} else if err != nil {
 		return fmt.Errorf("Run: Error getting object %s", err)
 	}
 
-	log.Debug("Parsing results of previous scan")
-	err = r.nmapSvc.ParsePreviousScan(scanBytes)
-	if err != nil {
-		return fmt.Errorf("Run: Unable to parse previous results in scanner %s", err)
+	if !establishBaseline {
+		log.Debug("Parsing results of previous scan")
+		err = r.nmapSvc.ParsePreviousScan(scanBytes)
+		if err != nil {
+			return fmt.Errorf("Run: Unable to parse previous results in scanner %s", err)
+		}
 	}
 
 	log.Debug("Starting Scan")
-	err = r.nmapSvc.StartScan(ipAddresses)
+	scanStarted := time.Now()
+	err = r.nmapSvc.StartScan(ipAddresses, serversMap)
 
 	if err != nil {
 		return fmt.Errorf("Run: Unable to run nmap scan: %s", err)
 	}
 
+	// The hosts of the scopes that could not be inventoried were not scanned. They are carried forward from the
+	// previous scan so they are not reported as removed now and as new on the next complete run, while hosts that
+	// vanished from the scopes that were inventoried are still reported as removed.
+	if len(inventoryErrors) > 0 && !establishBaseline {
+		log.Debug("Carrying hosts that were not inventoried forward from the previous scan")
+		err = r.nmapSvc.CarryForward(ipAddresses, inventoryErrors)
+		if err != nil {
+			return fmt.Errorf("Run: Unable to carry the previous scan forward %s", err)
+		}
+	}
+
 	log.Debug("Analyzing the result of current scan and previous scan")
 	instancesExposed := r.nmapSvc.DiffScans()
 
@@ -120,31 +252,184 @@ func (r *Runner) run(configObject config.BaseConfig) error {
 		return fmt.Errorf("Run: Error Retrieving Current Scan")
 	}
 
-	log.Debug("Uploading current scan to S3")
-	err = r.awsSvc.UploadObjectToS3(currentScanSlice, configObject.PreviousFileName)
+	// A baseline missing the hosts of the scopes that could not be inventoried would report them all as new on the
+	// next run, so the baseline is only established from a complete inventory.
+	if establishBaseline && len(inventoryErrors) > 0 {
+		log.Warn("Inventory incomplete, the current scan is not stored as the baseline")
+	} else {
+		err = r.saveScan(configObject.PreviousFileName, currentScanSlice, scanStarted)
+		if err != nil {
+			return fmt.Errorf("Run: %s", err)
+		}
+	}
+
+	if establishBaseline && r.baselineNotification != config.BaselineNotifySummary && len(inventoryErrors) == 0 {
+		log.Debug("Baseline established, skipping notifications")
+		return nil
+	}
+
+	log.Debug("Notifying scan changes")
+	err = r.notify(wrapper.Report{
+		Servers:          serversMap,
+		Diff:             r.filterDiff(instancesExposed),
+		Baseline:         establishBaseline,
+		InventoryErrors:  inventoryErrors,
+		OutsideInventory: outsideInventory(serversMap),
+	})
 	if err != nil {
-		return fmt.Errorf("Run: Unable to upload object to S3 %s", err)
+		return fmt.Errorf("Run: Error notifying changes %s", err)
+	}
+
+	return nil
+}
+
+// outsideInventory returns the servers only found through DNS zones, sorted by address.
+func outsideInventory(serversMap map[string]server.Server) []server.Server {
+	var servers []server.Server
+	for _, s := range serversMap {
+		if s.Tags[dnszone.OutsideInventoryTag] == "true" {
+			servers = append(servers, s)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Address < servers[j].Address })
+	return servers
+}
+
+// collectInventory fills serversMap with the servers of every enabled provider. DNS zones come last, so the addresses
+// they point at are checked against what the other providers inventoried. Unless the inventory is best effort,
+// the first provider that fails aborts the run. Otherwise the errors are returned per scope along with whatever was
+// inventoried.
+func (r *Runner) collectInventory(serversMap map[string]server.Server) ([]wrapper.InventoryError, error) {
+	providers := []struct {
+		name      string
+		enabled   bool
+		instances func(serversMap map[string]server.Server) error
+	}{
+		{"aws", r.enableAWS, func(serversMap map[string]server.Server) error { return r.awsSvc.Instances(serversMap) }},
+		{"gcloud", r.enableGCloud, func(serversMap map[string]server.Server) error { return r.gCloudSvc.Instances(serversMap) }},
+		{"terraform", r.enableTerraform, func(serversMap map[string]server.Server) error { return r.terraformSvc.Instances(serversMap) }},
+		{"azure", r.enableAzure, func(serversMap map[string]server.Server) error { return r.azureSvc.Instances(serversMap) }},
+		{"kubernetes", r.enableKubernetes, func(serversMap map[string]server.Server) error { return r.kubernetesSvc.Instances(serversMap) }},
+		{"static", r.enableStatic, func(serversMap map[string]server.Server) error { return r.staticSvc.Instances(serversMap) }},
+		{"dns", r.enableDNSZones, func(serversMap map[string]server.Server) error { return r.dnsZoneSvc.Instances(serversMap) }},
 	}
 
-	log.Debug("Printing opened ports")
-	for host, portsMap := range instancesExposed {
-		if len(portsMap) == 0 {
+	var inventoryErrors []wrapper.InventoryError
+	for _, provider := range providers {
+		if !provider.enabled {
 			continue
 		}
 
-		//TODO: Refactor to remove the map to slice conversion.
-		portsSlice := make([]uint16, 0)
-		for port, _ := range portsMap {
-			if port != 0 {
-				portsSlice = append(portsSlice, port)
-			}
+		log.Debug("Fetching Instances From " + provider.name)
+		err := provider.instances(serversMap)
+		if err == nil {
+			continue
+		}
+		if !r.bestEffortInventory {
+			return nil, fmt.Errorf("collectInventory: Unable to get %s Instances: %s", provider.name, err)
+		}
+
+		for _, inventoryError := range providerErrors(provider.name, err) {
+			log.WithField("error", inventoryError.Message).Warn("Unable to inventory " + provider.name + " " + inventoryError.Scope)
+			inventoryErrors = append(inventoryErrors, inventoryError)
 		}
+	}
+	return inventoryErrors, nil
+}
+
+// providerErrors splits the error of a provider into the scopes that failed. Errors that are not tied to a scope
+// mean nothing was inventoried from the provider.
+func providerErrors(provider string, err error) []wrapper.InventoryError {
+	var taskErrors inventory.Errors
+	if !errors.As(err, &taskErrors) {
+		return []wrapper.InventoryError{{Provider: provider, Scope: wrapper.AllScopes, Message: err.Error()}}
+	}
+
+	inventoryErrors := make([]wrapper.InventoryError, len(taskErrors))
+	for index, taskError := range taskErrors {
+		inventoryErrors[index] = wrapper.InventoryError{Provider: provider, Scope: taskError.Task, Message: taskError.Err.Error()}
+	}
+	return inventoryErrors
+}
 
-		err = r.slackSvc.PrintOpenedPorts(serversMap[host], portsSlice)
+// saveScan stores the current scan as the scan the next run is diffed against, and records it in the history when
+// history is configured.
+func (r *Runner) saveScan(previousFileName string, currentScanSlice []byte, scanStarted time.Time) error {
+	log.Debug("Saving current scan")
+	err := r.scanStore.Save(previousFileName, currentScanSlice)
+	if err != nil {
+		return fmt.Errorf("saveScan: Unable to save current scan %s", err)
+	}
+
+	if r.scanHistory != nil {
+		log.Debug("Recording current scan in history")
+		err = r.scanHistory.Record(currentScanSlice, scanStarted)
+		if err != nil {
+			return fmt.Errorf("saveScan: Unable to record current scan in history %s", err)
+		}
+	}
+	return nil
+}
+
+// loadBaseline returns the scan to diff against, which is the latest scan unless a scan from the history is selected.
+func (r *Runner) loadBaseline(previousFileName string) ([]byte, error) {
+	if r.scanHistory != nil && r.compareTo != "" {
+		log.Debug("Loading scan " + r.compareTo + " from history")
+		scanBytes, err := r.scanHistory.Load(r.compareTo)
 		if err != nil {
-			return fmt.Errorf("Run: Error posting to slack %s", err)
+			return nil, fmt.Errorf("loadBaseline: %s", err)
 		}
+		return scanBytes, nil
 	}
 
+	log.Debug("Loading previous scan")
+	scanBytes, err := r.scanStore.Load(previousFileName)
+	if err != nil {
+		return nil, fmt.Errorf("loadBaseline: %w", err)
+	}
+	return scanBytes, nil
+}
+
+// notify sends the report to every notifier. A failing notifier does not stop the others from being notified, and
+// the errors of all of them are returned together.
+func (r *Runner) notify(report wrapper.Report) error {
+	var errorMessages []string
+	for _, n := range r.notifiers {
+		err := n.Notify(report)
+		if err != nil {
+			errorMessages = append(errorMessages, err.Error())
+		}
+	}
+
+	if len(errorMessages) > 0 {
+		return fmt.Errorf("notify: %d of %d notifiers failed: %s", len(errorMessages), len(r.notifiers),
+			strings.Join(errorMessages, "; "))
+	}
 	return nil
 }
+
+// filterDiff returns the diff with only the state transitions that match one of the configured patterns.
+func (r *Runner) filterDiff(diff wrapper.ScanDiff) wrapper.ScanDiff {
+	filtered := make(map[string][]server.StateTransition)
+	for host, transitions := range diff.StateTransitions {
+		if matched := r.filterTransitions(transitions); len(matched) > 0 {
+			filtered[host] = matched
+		}
+	}
+	diff.StateTransitions = filtered
+	return diff
+}
+
+// filterTransitions returns the transitions that match one of the configured patterns.
+func (r *Runner) filterTransitions(transitions []server.StateTransition) []server.StateTransition {
+	var matched []server.StateTransition
+	for _, transition := range transitions {
+		for _, pattern := range r.notifyTransitions {
+			if transition.Matches(pattern) {
+				matched = append(matched, transition)
+				break
+			}
+		}
+	}
+	return matched
+}
@@ -2,11 +2,14 @@ package runner
 
 import (
 	"fmt"
+	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	"strconv"
 	"testing"
 
 	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/dnszone"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
 	"github.com/Invoca/nmap-diff/pkg/mocks"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -25,15 +28,19 @@ func TestRun(t *testing.T) {
 	nmapMock := mocks.NmapScannerMock{}
 	awsMock := mocks.MockAWSWrapper{}
 	gcloudMock := mocks.GCloudInterfaceMock{}
-	slackMock := mocks.SlackInterfaceMock{}
+	storeMock := mocks.ScanStoreMock{}
+	notifierMock := mocks.NotifierMock{}
+	otherNotifierMock := mocks.NotifierMock{}
 
 	testRunner := Runner{
-		awsSvc:       &awsMock,
-		gCloudSvc:    &gcloudMock,
-		slackSvc:     &slackMock,
-		nmapSvc:      &nmapMock,
-		enableGCloud: true,
-		enableAWS:    true,
+		awsSvc:            &awsMock,
+		gCloudSvc:         &gcloudMock,
+		notifiers:         []wrapper.Notifier{&notifierMock, &otherNotifierMock},
+		nmapSvc:           &nmapMock,
+		scanStore:         &storeMock,
+		enableGCloud:      true,
+		enableAWS:         true,
+		notifyTransitions: []string{"closed->filtered"},
 	}
 
 	testCases := []runnerTestCase{
@@ -41,20 +48,55 @@ func TestRun(t *testing.T) {
 			desc: "Run without error if other packages return successfully",
 			setup: func() {
 				currentScanSlice := []byte{0x00}
-				instancesExposed := make(map[string]wrapper.PortMap)
+				instancesExposed := wrapper.NewScanDiff()
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
 				nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
 				nmapMock.On("StartScan", mock.Anything).Return(nil)
 				nmapMock.On("DiffScans", mock.Anything).Return(instancesExposed)
 				nmapMock.On("CurrentScanResults", mock.Anything).Return(currentScanSlice, nil)
-				awsMock.On("UploadObjectToS3", mock.Anything).Return(nil)
-				slackMock.On("PrintOpenedPorts", mock.Anything).Return(nil)
+				storeMock.On("Save", mock.Anything).Return(nil)
+				notifierMock.On("Notify", mock.Anything).Return(nil)
+				otherNotifierMock.On("Notify", mock.Anything).Return(nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "Run without error if every category of change is notified",
+			setup: func() {
+				currentScanSlice := []byte{0x00}
+				instancesExposed := wrapper.NewScanDiff()
+				instancesExposed.OpenedPorts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {}}
+				instancesExposed.ClosedPorts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 22}: {}}
+				instancesExposed.NewHosts["2.2.2.2"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 80}: {}}
+				instancesExposed.RemovedHosts["3.3.3.3"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 8080}: {}}
+				instancesExposed.ChangedServices["4.4.4.4"] = []server.ServiceChange{{Port: server.Port{Protocol: "tcp", ID: 443}}}
+				instancesExposed.StateTransitions["5.5.5.5"] = []server.StateTransition{
+					{Port: server.Port{Protocol: "tcp", ID: 25}, Previous: "closed", Current: "filtered"},
+				}
+				nmapMock.Reset()
+				awsMock.Reset()
+				storeMock.Reset()
+				gcloudMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
+				awsMock.On("Instances", mock.Anything).Return(nil)
+				gcloudMock.On("Instances", mock.Anything).Return(nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
+				nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
+				nmapMock.On("StartScan", mock.Anything).Return(nil)
+				nmapMock.On("DiffScans", mock.Anything).Return(instancesExposed)
+				nmapMock.On("CurrentScanResults", mock.Anything).Return(currentScanSlice, nil)
+				storeMock.On("Save", mock.Anything).Return(nil)
+				notifierMock.On("Notify", mock.Anything).Return(nil)
+				otherNotifierMock.On("Notify", mock.Anything).Return(nil)
 			},
 			shouldError: false,
 		},
@@ -63,8 +105,10 @@ func TestRun(t *testing.T) {
 			setup: func() {
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(fmt.Errorf("Error"))
 			},
 			shouldError: true,
@@ -74,23 +118,27 @@ func TestRun(t *testing.T) {
 			setup: func() {
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(fmt.Errorf("Error"))
 			},
 			shouldError: true,
 		},
 		{
-			desc: "Error if the previous report was not able to be fetched from S3",
+			desc: "Error if the previous report was not able to be loaded",
 			setup: func() {
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, fmt.Errorf("Error"))
+				storeMock.On("Load", mock.Anything).Return(nil, fmt.Errorf("Error"))
 			},
 			shouldError: true,
 		},
@@ -99,11 +147,13 @@ func TestRun(t *testing.T) {
 			setup: func() {
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
 				nmapMock.On("ParsePreviousScan", mock.Anything).Return(fmt.Errorf("Error"))
 			},
 			shouldError: true,
@@ -113,11 +163,13 @@ func TestRun(t *testing.T) {
 			setup: func() {
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
 				nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
 				nmapMock.On("StartScan", mock.Anything).Return(fmt.Errorf("Error"))
 			},
@@ -127,14 +179,16 @@ func TestRun(t *testing.T) {
 			desc: "Error if the byte slice of the current scan is not able to be retrieved",
 			setup: func() {
 				currentScanSlice := []byte{0x00}
-				instancesExposed := make(map[string]wrapper.PortMap)
+				instancesExposed := wrapper.NewScanDiff()
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
 				nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
 				nmapMock.On("StartScan", mock.Anything).Return(nil)
 				nmapMock.On("DiffScans", mock.Anything).Return(instancesExposed)
@@ -143,45 +197,49 @@ func TestRun(t *testing.T) {
 			shouldError: true,
 		},
 		{
-			desc: "Error if the current report is not able to be uploaded to S3",
+			desc: "Error if the current report is not able to be saved",
 			setup: func() {
 				currentScanSlice := []byte{0x00}
-				instancesExposed := make(map[string]wrapper.PortMap)
+				instancesExposed := wrapper.NewScanDiff()
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
 				nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
 				nmapMock.On("StartScan", mock.Anything).Return(nil)
 				nmapMock.On("DiffScans", mock.Anything).Return(instancesExposed)
 				nmapMock.On("CurrentScanResults", mock.Anything).Return(currentScanSlice, nil)
-				awsMock.On("UploadObjectToS3", mock.Anything).Return(fmt.Errorf("Error"))
+				storeMock.On("Save", mock.Anything).Return(fmt.Errorf("Error"))
 			},
 			shouldError: true,
 		},
 		{
-			desc: "Error if the opened ports are not able to be posted to slack",
+			desc: "Error if one of the notifiers is not able to notify",
 			setup: func() {
 				currentScanSlice := []byte{0x00}
-				instancesExposed := make(map[string]wrapper.PortMap)
-				instancesExposed["1.1.1.1"] = make(wrapper.PortMap)
-				instancesExposed["1.1.1.1"][1] = true
+				instancesExposed := wrapper.NewScanDiff()
+				instancesExposed.OpenedPorts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 1}: {}}
 				nmapMock.Reset()
 				awsMock.Reset()
+				storeMock.Reset()
 				gcloudMock.Reset()
-				slackMock.Reset()
+				notifierMock.Reset()
+				otherNotifierMock.Reset()
 				awsMock.On("Instances", mock.Anything).Return(nil)
 				gcloudMock.On("Instances", mock.Anything).Return(nil)
-				awsMock.On("GetFileFromS3", mock.Anything).Return(nil, nil)
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
 				nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
 				nmapMock.On("StartScan", mock.Anything).Return(nil)
 				nmapMock.On("DiffScans", mock.Anything).Return(instancesExposed)
 				nmapMock.On("CurrentScanResults", mock.Anything).Return(currentScanSlice, nil)
-				awsMock.On("UploadObjectToS3", mock.Anything).Return(nil)
-				slackMock.On("PrintOpenedPorts", mock.Anything).Return(fmt.Errorf("Error"))
+				storeMock.On("Save", mock.Anything).Return(nil)
+				notifierMock.On("Notify", mock.Anything).Return(fmt.Errorf("Error"))
+				otherNotifierMock.On("Notify", mock.Anything).Return(nil)
 			},
 			shouldError: true,
 		},
@@ -203,4 +261,355 @@ func TestRun(t *testing.T) {
 			assert.NoError(t, err)
 		}
 	}
+
+	t.Logf("TestRun: every notifier is notified even when one of them fails")
+	otherNotifierMock.AssertCalled(t, "Notify", nil)
+}
+
+func TestRunWithHistory(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	configObject := config.BaseConfig{}
+	nmapMock := mocks.NmapScannerMock{}
+	storeMock := mocks.ScanStoreMock{}
+	historyMock := mocks.ScanHistoryMock{}
+	notifierMock := mocks.NotifierMock{}
+
+	testRunner := Runner{
+		notifiers:   []wrapper.Notifier{&notifierMock},
+		nmapSvc:     &nmapMock,
+		scanStore:   &storeMock,
+		scanHistory: &historyMock,
+	}
+
+	setupScan := func() {
+		nmapMock.Reset()
+		storeMock.Reset()
+		historyMock.Reset()
+		notifierMock.Reset()
+		nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
+		nmapMock.On("StartScan", mock.Anything).Return(nil)
+		nmapMock.On("DiffScans", mock.Anything).Return(wrapper.NewScanDiff())
+		nmapMock.On("CurrentScanResults", mock.Anything).Return([]byte{0x00}, nil)
+		storeMock.On("Save", mock.Anything).Return(nil)
+		notifierMock.On("Notify", mock.Anything).Return(nil)
+	}
+
+	testCases := []struct {
+		desc        string
+		compareTo   string
+		setup       func()
+		shouldError bool
+	}{
+		{
+			desc: "Diff against the latest scan and record the current scan in history",
+			setup: func() {
+				setupScan()
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
+				historyMock.On("Record", mock.Anything).Return(nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc:      "Diff against a scan from history",
+			compareTo: "20200901T120000Z",
+			setup: func() {
+				setupScan()
+				historyMock.On("Load", mock.Anything).Return([]byte{0x00}, nil)
+				historyMock.On("Record", mock.Anything).Return(nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc:      "Error if the scan to compare to is not in history",
+			compareTo: "20200901T120000Z",
+			setup: func() {
+				setupScan()
+				historyMock.On("Load", mock.Anything).Return(nil, fmt.Errorf("Error"))
+			},
+			shouldError: true,
+		},
+		{
+			desc: "Error if the current scan is not able to be recorded in history",
+			setup: func() {
+				setupScan()
+				storeMock.On("Load", mock.Anything).Return(nil, nil)
+				historyMock.On("Record", mock.Anything).Return(fmt.Errorf("Error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+		testRunner.compareTo = testCase.compareTo
+
+		err := testRunner.run(configObject)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			historyMock.AssertExpectations(t)
+			storeMock.AssertExpectations(t)
+		}
+	}
+}
+
+func TestRunBaseline(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	configObject := config.BaseConfig{}
+	nmapMock := mocks.NmapScannerMock{}
+	storeMock := mocks.ScanStoreMock{}
+	notifierMock := mocks.NotifierMock{}
+
+	testRunner := Runner{
+		notifiers: []wrapper.Notifier{&notifierMock},
+		nmapSvc:   &nmapMock,
+		scanStore: &storeMock,
+	}
+
+	testCases := []struct {
+		desc                 string
+		baselineNotification string
+		shouldNotify         bool
+	}{
+		{
+			desc:                 "Store the baseline without notifying by default",
+			baselineNotification: "",
+			shouldNotify:         false,
+		},
+		{
+			desc:                 "Store the baseline without notifying when notifications are disabled",
+			baselineNotification: config.BaselineNotifyNone,
+			shouldNotify:         false,
+		},
+		{
+			desc:                 "Store the baseline and notify a summary",
+			baselineNotification: config.BaselineNotifySummary,
+			shouldNotify:         true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc": testCase.desc,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		nmapMock.Reset()
+		storeMock.Reset()
+		notifierMock.Reset()
+		storeMock.On("Load", mock.Anything).Return(nil, fmt.Errorf("Load: previous.xml %w", wrapper.ErrNotFound))
+		nmapMock.On("StartScan", mock.Anything).Return(nil)
+		nmapMock.On("DiffScans", mock.Anything).Return(wrapper.NewScanDiff())
+		nmapMock.On("CurrentScanResults", mock.Anything).Return([]byte{0x00}, nil)
+		storeMock.On("Save", mock.Anything).Return(nil)
+		notifierMock.On("Notify", mock.Anything).Return(nil)
+
+		testRunner.baselineNotification = testCase.baselineNotification
+
+		err := testRunner.run(configObject)
+		assert.NoError(t, err)
+
+		nmapMock.AssertNotCalled(t, "ParsePreviousScan", nil)
+		storeMock.AssertCalled(t, "Save", nil)
+		if testCase.shouldNotify {
+			notifierMock.AssertCalled(t, "Notify", nil)
+		} else {
+			notifierMock.AssertNotCalled(t, "Notify", nil)
+		}
+	}
+}
+
+func TestCollectInventory(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	regionErrors := fmt.Errorf("Instances: Error getting resources %w", inventory.Errors{
+		{Task: "123456789012/ap-east-1", Err: fmt.Errorf("AccessDenied")},
+		{Task: "123456789012/me-south-1", Err: fmt.Errorf("AccessDenied")},
+	})
+
+	testCases := []struct {
+		desc        string
+		bestEffort  bool
+		awsErr      error
+		gcloudErr   error
+		azureErr    error
+		expected    []wrapper.InventoryError
+		shouldError bool
+	}{
+		{
+			desc:        "Collect the inventory of every provider",
+			bestEffort:  false,
+			expected:    nil,
+			shouldError: false,
+		},
+		{
+			desc:        "Fail fast on the first provider error",
+			bestEffort:  false,
+			awsErr:      regionErrors,
+			shouldError: true,
+		},
+		{
+			desc:       "Collect the errors of every failed scope when the inventory is best effort",
+			bestEffort: true,
+			awsErr:     regionErrors,
+			gcloudErr:  fmt.Errorf("Instances: Error Getting zones"),
+			azureErr: fmt.Errorf("Instances: Error getting resources %w", inventory.Errors{
+				{Task: "sub-1", Err: fmt.Errorf("AuthorizationFailed")},
+			}),
+			expected: []wrapper.InventoryError{
+				{Provider: "aws", Scope: "123456789012/ap-east-1", Message: "AccessDenied"},
+				{Provider: "aws", Scope: "123456789012/me-south-1", Message: "AccessDenied"},
+				{Provider: "gcloud", Scope: "all", Message: "Instances: Error Getting zones"},
+				{Provider: "azure", Scope: "sub-1", Message: "AuthorizationFailed"},
+			},
+			shouldError: false,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		awsMock := mocks.MockAWSWrapper{}
+		gcloudMock := mocks.GCloudInterfaceMock{}
+		awsMock.On("Instances", mock.Anything).Return(testCase.awsErr)
+		gcloudMock.On("Instances", mock.Anything).Return(testCase.gcloudErr)
+		azureMock := mocks.AzureInterfaceMock{}
+		azureMock.On("Instances", mock.Anything).Return(testCase.azureErr)
+
+		testRunner := Runner{
+			awsSvc:              &awsMock,
+			gCloudSvc:           &gcloudMock,
+			azureSvc:            &azureMock,
+			enableAWS:           true,
+			enableGCloud:        true,
+			enableAzure:         true,
+			bestEffortInventory: testCase.bestEffort,
+		}
+
+		inventoryErrors, err := testRunner.collectInventory(make(map[string]server.Server))
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+			gcloudMock.AssertNotCalled(t, "Instances", nil)
+			azureMock.AssertNotCalled(t, "Instances", nil)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, inventoryErrors)
+		}
+	}
+
+	t.Logf("TestCollectInventory: the scan goes on with the partial inventory")
+	partialCases := []struct {
+		desc       string
+		loadErr    error
+		carried    bool
+		savedScans bool
+	}{
+		{
+			desc:       "Hosts that were not inventoried are carried forward from the previous scan",
+			carried:    true,
+			savedScans: true,
+		},
+		{
+			desc:       "A partial inventory is not stored as the baseline",
+			loadErr:    fmt.Errorf("Load: previous.xml %w", wrapper.ErrNotFound),
+			carried:    false,
+			savedScans: false,
+		},
+	}
+
+	for index, testCase := range partialCases {
+		log.WithFields(log.Fields{
+			"desc": testCase.desc,
+		}).Debug("Starting partial inventory testCase " + strconv.Itoa(index))
+
+		nmapMock := mocks.NmapScannerMock{}
+		storeMock := mocks.ScanStoreMock{}
+		notifierMock := mocks.NotifierMock{}
+		awsMock := mocks.MockAWSWrapper{}
+		gcloudMock := mocks.GCloudInterfaceMock{}
+		awsMock.On("Instances", mock.Anything).Return(regionErrors)
+		gcloudMock.On("Instances", mock.Anything).Return(nil)
+		if testCase.loadErr != nil {
+			storeMock.On("Load", mock.Anything).Return(nil, testCase.loadErr)
+		} else {
+			storeMock.On("Load", mock.Anything).Return([]byte{0x00}, nil)
+		}
+		nmapMock.On("ParsePreviousScan", mock.Anything).Return(nil)
+		nmapMock.On("StartScan", mock.Anything).Return(nil)
+		nmapMock.On("CarryForward", mock.Anything).Return(nil)
+		nmapMock.On("DiffScans", mock.Anything).Return(wrapper.NewScanDiff())
+		nmapMock.On("CurrentScanResults", mock.Anything).Return([]byte{0x00}, nil)
+		storeMock.On("Save", mock.Anything).Return(nil)
+		notifierMock.On("Notify", mock.Anything).Return(nil)
+
+		testRunner := Runner{
+			awsSvc:              &awsMock,
+			gCloudSvc:           &gcloudMock,
+			notifiers:           []wrapper.Notifier{&notifierMock},
+			nmapSvc:             &nmapMock,
+			scanStore:           &storeMock,
+			enableAWS:           true,
+			enableGCloud:        true,
+			bestEffortInventory: true,
+		}
+		err := testRunner.run(config.BaseConfig{})
+		assert.NoError(t, err)
+		gcloudMock.AssertCalled(t, "Instances", nil)
+		nmapMock.AssertCalled(t, "StartScan", nil)
+		notifierMock.AssertCalled(t, "Notify", nil)
+		if testCase.carried {
+			nmapMock.AssertCalled(t, "CarryForward", nil)
+		} else {
+			nmapMock.AssertNotCalled(t, "CarryForward", nil)
+		}
+		if testCase.savedScans {
+			storeMock.AssertCalled(t, "Save", nil)
+		} else {
+			storeMock.AssertNotCalled(t, "Save", nil)
+		}
+	}
+}
+
+func TestFilterDiff(t *testing.T) {
+	testRunner := Runner{
+		notifyTransitions: []string{"closed->filtered", "*->open|filtered"},
+	}
+
+	diff := wrapper.NewScanDiff()
+	diff.StateTransitions["1.1.1.1"] = []server.StateTransition{
+		{Port: server.Port{Protocol: "tcp", ID: 25}, Previous: "closed", Current: "filtered"},
+		{Port: server.Port{Protocol: "tcp", ID: 26}, Previous: "filtered", Current: "closed"},
+		{Port: server.Port{Protocol: "udp", ID: 161}, Previous: "filtered", Current: "open|filtered"},
+	}
+	diff.StateTransitions["2.2.2.2"] = []server.StateTransition{
+		{Port: server.Port{Protocol: "tcp", ID: 25}, Previous: "filtered", Current: "closed"},
+	}
+
+	filtered := testRunner.filterDiff(diff)
+
+	assert.Equal(t, []server.StateTransition{
+		{Port: server.Port{Protocol: "tcp", ID: 25}, Previous: "closed", Current: "filtered"},
+		{Port: server.Port{Protocol: "udp", ID: 161}, Previous: "filtered", Current: "open|filtered"},
+	}, filtered.StateTransitions["1.1.1.1"])
+	assert.NotContains(t, filtered.StateTransitions, "2.2.2.2")
+}
+
+func TestOutsideInventory(t *testing.T) {
+	serversMap := map[string]server.Server{
+		"1.1.1.1": {Name: "i-123", Address: "1.1.1.1", Provider: "aws", Tags: map[string]string{dnszone.HostnamesTag: "example.com"}},
+		"6.6.6.6": {Name: "vpn.example.com", Address: "6.6.6.6", Provider: "dns", Tags: map[string]string{dnszone.OutsideInventoryTag: "true"}},
+		"5.5.5.5": {Name: "shop.example.com", Address: "5.5.5.5", Provider: "dns", Tags: map[string]string{dnszone.OutsideInventoryTag: "true"}},
+	}
+
+	assert.Equal(t, []server.Server{serversMap["5.5.5.5"], serversMap["6.6.6.6"]}, outsideInventory(serversMap))
 }
@@ -2,75 +2,299 @@ package scanner
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	"github.com/Ullaakut/nmap"
 	log "github.com/sirupsen/logrus"
 )
 
+// hostCommentPrefix starts the comment of the hosts of a stored scan. It is followed by the identity, provider and
+// inventory scopes of the server behind the host, encoded as a URL query.
+const hostCommentPrefix = "nmap-diff:"
+
+// identityCommentPrefix starts the comment of the hosts of scans stored before the provider and scopes were recorded,
+// which only hold the identity.
+const identityCommentPrefix = "nmap-diff-identity:"
+
 type scanParser struct {
-	currentInstances    map[string]wrapper.PortMap
-	previousInstances   map[string]wrapper.PortMap
-	newInstancesExposed map[string]wrapper.PortMap
+	currentInstances  map[string]wrapper.PortMap
+	previousInstances map[string]wrapper.PortMap
+	// currentDefaults and previousDefaults hold the state of the ports nmap did not list for each host.
+	currentDefaults  map[string]portDefaults
+	previousDefaults map[string]portDefaults
+	// currentIdentities and previousIdentities hold the identity of the resource behind each host, when known.
+	currentIdentities  map[string]string
+	previousIdentities map[string]string
+	diff               wrapper.ScanDiff
 }
 
-func newParser(previousInstances map[string]wrapper.PortMap, currentInstances map[string]wrapper.PortMap) *scanParser {
+func newParser(previousInstances map[string]wrapper.PortMap, currentInstances map[string]wrapper.PortMap,
+	previousDefaults map[string]portDefaults, currentDefaults map[string]portDefaults,
+	previousIdentities map[string]string, currentIdentities map[string]string) *scanParser {
 	p := &scanParser{}
 	p.previousInstances = previousInstances
 	p.currentInstances = currentInstances
-	p.newInstancesExposed = make(map[string]wrapper.PortMap)
+	p.previousDefaults = previousDefaults
+	p.currentDefaults = currentDefaults
+	p.previousIdentities = previousIdentities
+	p.currentIdentities = currentIdentities
+	p.diff = wrapper.NewScanDiff()
 	return p
 }
 
-func (p *scanParser) ParseScans() map[string]wrapper.PortMap {
+func (p *scanParser) ParseScans() wrapper.ScanDiff {
+	previousHosts, found := p.pairHosts()
+
 	// Iterate through all instances found in  the current scan.
 	for host, ports := range p.currentInstances {
-		// Check if the instance was found in a previous scan. If that is the case, add all ports exposed on this
-		// instance since they were not found on the last scan. Otherwise compare the ports opened on the previous scan
-		// with the current scan.
-		if p.previousInstances[host] == nil {
-			p.newInstancesExposed[host] = ports
-		} else {
-			p.checkPortsAdded(host)
+		p.checkReassigned(host)
+
+		// Check if the instance was found in a previous scan. If that is not the case, add all ports exposed on this
+		// instance since they were not found on the last scan. Otherwise compare the ports on the previous scan with
+		// the current scan.
+		previousHost, ok := previousHosts[host]
+		if !ok {
+			if openPorts := ports.OpenPorts(); len(openPorts) > 0 {
+				p.diff.NewHosts[host] = openPorts
+			}
+			continue
+		}
+
+		if previousHost != host {
+			p.diff.AddressChanges[host] = server.AddressChange{
+				Identity: p.currentIdentities[host],
+				Previous: previousHost,
+				Current:  host,
+			}
+		}
+		p.checkPortsAdded(previousHost, host)
+		p.checkPortsRemoved(previousHost, host)
+		p.checkServicesChanged(previousHost, host)
+		p.checkStateTransitions(previousHost, host)
+	}
+
+	// Any instance that exposed ports on the previous scan but was not found on the current scan has vanished.
+	for host, ports := range p.previousInstances {
+		if found[host] {
+			continue
+		}
+		if openPorts := ports.OpenPorts(); len(openPorts) > 0 {
+			p.diff.RemovedHosts[host] = openPorts
 		}
 	}
 
-	return p.newInstancesExposed
+	return p.diff
 }
 
-// checkPortsAdded goes through all ports found on the current scan and checks to see if they were present on the last
-// scan.
-func (p *scanParser) checkPortsAdded(host string) {
+// pairHosts returns the address each host of the current scan had on the previous scan, along with the addresses of
+// the previous scan that were found again. Hosts are related by their identity when it belongs to a single host on
+// both scans, so that a resource whose address changed is still diffed against its previous ports. Other hosts are
+// related by address, unless the address belonged to a resource with another identity.
+func (p *scanParser) pairHosts() (map[string]string, map[string]bool) {
+	previousHosts := make(map[string]string)
+	found := make(map[string]bool)
+
+	previousByIdentity := uniqueIdentities(p.previousIdentities, p.previousInstances)
+	for identity, host := range uniqueIdentities(p.currentIdentities, p.currentInstances) {
+		if previousHost, ok := previousByIdentity[identity]; ok {
+			previousHosts[host] = previousHost
+			found[previousHost] = true
+		}
+	}
+
+	for host := range p.currentInstances {
+		if _, ok := previousHosts[host]; ok || found[host] || p.previousInstances[host] == nil {
+			continue
+		}
+		previousIdentity, currentIdentity := p.previousIdentities[host], p.currentIdentities[host]
+		if previousIdentity != "" && currentIdentity != "" && previousIdentity != currentIdentity {
+			continue
+		}
+		previousHosts[host] = host
+		found[host] = true
+	}
+	return previousHosts, found
+}
+
+// uniqueIdentities returns the host of every identity that belongs to a single host of the scan. Resources with
+// several addresses, such as instances with several network interfaces, are related by address instead.
+func uniqueIdentities(identities map[string]string, instances map[string]wrapper.PortMap) map[string]string {
+	hosts := make(map[string]string)
+	duplicates := make(map[string]bool)
+	for host, identity := range identities {
+		if identity == "" || instances[host] == nil {
+			continue
+		}
+		if _, ok := hosts[identity]; ok {
+			duplicates[identity] = true
+		}
+		hosts[identity] = host
+	}
+	for identity := range duplicates {
+		delete(hosts, identity)
+	}
+	return hosts
+}
+
+// checkReassigned records the address of the host when it belonged to a resource with another identity on the
+// previous scan.
+func (p *scanParser) checkReassigned(host string) {
+	if p.previousInstances[host] == nil {
+		return
+	}
+	previousIdentity, currentIdentity := p.previousIdentities[host], p.currentIdentities[host]
+	if previousIdentity != "" && currentIdentity != "" && previousIdentity != currentIdentity {
+		p.diff.ReassignedAddresses[host] = server.Reassignment{
+			Address:  host,
+			Previous: previousIdentity,
+			Current:  currentIdentity,
+		}
+	}
+}
+
+// stateOnScan returns the state of a port on a host. Ports nmap did not list take the state of the host's extraports
+// on the run that scanned them, or an empty string when it is unknown.
+func stateOnScan(instances map[string]wrapper.PortMap, defaults map[string]portDefaults, host string, port server.Port) string {
+	if listedState, ok := instances[host][port]; ok {
+		return listedState.State
+	}
+	return defaults[host].stateOf(port)
+}
+
+// checkPortsAdded goes through all ports open on the current scan and checks to see if they were open on the last
+// scan, where the host was found at previousHost.
+func (p *scanParser) checkPortsAdded(previousHost string, host string) {
 	portsAdded := make(wrapper.PortMap)
-	for port, _ := range p.currentInstances[host] {
-		if p.previousInstances[host][port] == false {
-			portsAdded[port] = true
+	for port, currentState := range p.currentInstances[host].OpenPorts() {
+		if stateOnScan(p.previousInstances, p.previousDefaults, previousHost, port) != server.PortOpen {
+			portsAdded[port] = currentState
 		}
 	}
-	// Check if any ports were removed from the instance.
 	if len(portsAdded) > 0 {
-		p.newInstancesExposed[host] = portsAdded
+		p.diff.OpenedPorts[host] = portsAdded
+	}
+}
+
+// checkPortsRemoved goes through all ports open on the last scan, where the host was found at previousHost, and checks
+// to see if they are still open on the current scan.
+func (p *scanParser) checkPortsRemoved(previousHost string, host string) {
+	portsRemoved := make(wrapper.PortMap)
+	for port, previousState := range p.previousInstances[previousHost].OpenPorts() {
+		if stateOnScan(p.currentInstances, p.currentDefaults, host, port) != server.PortOpen {
+			portsRemoved[port] = previousState
+		}
+	}
+	if len(portsRemoved) > 0 {
+		p.diff.ClosedPorts[host] = portsRemoved
+	}
+}
+
+// checkServicesChanged goes through all ports open on both scans and checks to see if the service detected on them
+// changed. Services are only compared when both scans ran version detection, since the service nmap guesses from the
+// port number never changes.
+func (p *scanParser) checkServicesChanged(previousHost string, host string) {
+	var servicesChanged []server.ServiceChange
+	for port, currentState := range p.currentInstances[host].OpenPorts() {
+		previousState, ok := p.previousInstances[previousHost][port]
+		if !ok || !previousState.Open() {
+			continue
+		}
+		previousService, currentService := previousState.Service, currentState.Service
+		if !previousService.Probed() || !currentService.Probed() {
+			continue
+		}
+		if previousService.Differs(currentService) {
+			servicesChanged = append(servicesChanged, server.ServiceChange{
+				Port:     port,
+				Previous: previousService,
+				Current:  currentService,
+			})
+		}
+	}
+	if len(servicesChanged) > 0 {
+		sort.Slice(servicesChanged, func(i, j int) bool { return servicesChanged[i].Port.Less(servicesChanged[j].Port) })
+		p.diff.ChangedServices[host] = servicesChanged
+	}
+}
+
+// checkStateTransitions goes through every port listed on either scan and records the ones whose state changed.
+// Transitions are only recorded when the state is known on both scans.
+func (p *scanParser) checkStateTransitions(previousHost string, host string) {
+	ports := make(map[server.Port]bool)
+	for port := range p.previousInstances[previousHost] {
+		ports[port] = true
+	}
+	for port := range p.currentInstances[host] {
+		ports[port] = true
+	}
+
+	var transitions []server.StateTransition
+	for port := range ports {
+		previousState := stateOnScan(p.previousInstances, p.previousDefaults, previousHost, port)
+		currentState := stateOnScan(p.currentInstances, p.currentDefaults, host, port)
+		if previousState == "" || currentState == "" || previousState == currentState {
+			continue
+		}
+		transitions = append(transitions, server.StateTransition{
+			Port:     port,
+			Previous: previousState,
+			Current:  currentState,
+		})
+	}
+	if len(transitions) > 0 {
+		sort.Slice(transitions, func(i, j int) bool { return transitions[i].Port.Less(transitions[j].Port) })
+		p.diff.StateTransitions[host] = transitions
 	}
 }
 
 type nmapWrapper struct {
 	interfaceName string
+	profile       *config.ScanProfile
 }
 
-func (n *nmapWrapper) Run(ipAddresses []string, ctx context.Context) (*nmap.Run, []string, error) {
-	var err error
-	var nmapRunCommand *nmap.Scanner
+// Run scans the addresses. nmap scans a single address family per run, so IPv6 addresses are scanned in a second run
+// whose hosts are merged into the result of the first one.
+func (n *nmapWrapper) Run(ipAddresses []string, ports string, ctx context.Context) (*nmap.Run, []string, error) {
+	ipv4Addresses, ipv6Addresses := splitAddressFamilies(ipAddresses)
+	if len(ipv6Addresses) == 0 {
+		return n.runFamily(ipAddresses, ports, false, ctx)
+	}
+	if len(ipv4Addresses) == 0 {
+		return n.runFamily(ipv6Addresses, ports, true, ctx)
+	}
 
-	if n.interfaceName != "" {
-		nmapRunCommand, err = n.runWithDevice(ipAddresses, ctx)
-	} else {
-		nmapRunCommand, err = n.runWithoutDevice(ipAddresses, ctx)
+	result, warnings, err := n.runFamily(ipv4Addresses, ports, false, ctx)
+	if err != nil {
+		return result, warnings, err
+	}
+
+	ipv6Result, ipv6Warnings, err := n.runFamily(ipv6Addresses, ports, true, ctx)
+	warnings = append(warnings, ipv6Warnings...)
+	if err != nil {
+		return ipv6Result, warnings, err
+	}
+
+	merged, err := mergeRuns(result, ipv6Result)
+	if err != nil {
+		return nil, warnings, fmt.Errorf("Unable to merge IPv4 and IPv6 scans: %v", err)
 	}
+	return merged, warnings, nil
+}
 
+func (n *nmapWrapper) runFamily(ipAddresses []string, ports string, ipv6 bool, ctx context.Context) (*nmap.Run, []string, error) {
+	nmapRunCommand, err := nmap.NewScanner(n.scanOptions(ipAddresses, ports, ipv6, ctx)...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Unable to create scanner: %v", err)
 	}
@@ -78,23 +302,106 @@ func (n *nmapWrapper) Run(ipAddresses []string, ctx context.Context) (*nmap.Run,
 	return nmapRunCommand.Run()
 }
 
-func (n *nmapWrapper) runWithDevice(ipAddresses []string, ctx context.Context) (*nmap.Scanner, error) {
-	return nmap.NewScanner(
-		nmap.WithTargets(ipAddresses...),
-		nmap.WithContext(ctx),
-		nmap.WithSkipHostDiscovery(),
-		nmap.WithInterface(n.interfaceName),
-		nmap.WithUnprivileged(),
-	)
+// splitAddressFamilies returns the IPv4 addresses, along with anything that is not an IP address, apart from the IPv6
+// addresses.
+func splitAddressFamilies(ipAddresses []string) ([]string, []string) {
+	var ipv4Addresses, ipv6Addresses []string
+	for _, address := range ipAddresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			ipv6Addresses = append(ipv6Addresses, address)
+		} else {
+			ipv4Addresses = append(ipv4Addresses, address)
+		}
+	}
+	return ipv4Addresses, ipv6Addresses
+}
+
+// mergeRuns returns the first run with the hosts of the second one added. The result is encoded and parsed again so
+// that the raw XML stored as the current scan lists every host.
+func mergeRuns(first *nmap.Run, second *nmap.Run) (*nmap.Run, error) {
+	merged := *first
+	merged.Hosts = append(append([]nmap.Host{}, first.Hosts...), second.Hosts...)
+	merged.Stats.Hosts.Up += second.Stats.Hosts.Up
+	merged.Stats.Hosts.Down += second.Stats.Hosts.Down
+	merged.Stats.Hosts.Total += second.Stats.Hosts.Total
+
+	data, err := xml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("mergeRuns: Error encoding scan %s", err)
+	}
+	return nmap.Parse(append([]byte(xml.Header), data...))
 }
 
-func (n *nmapWrapper) runWithoutDevice(ipAddresses []string, ctx context.Context) (*nmap.Scanner, error) {
-	return nmap.NewScanner(
+// scanOptions returns the nmap options for a scan of the given addresses, which must all be IPv6 addresses when ipv6
+// is set. Host discovery is always skipped since every target comes from an inventory and is expected to be up. Ports,
+// when set, replaces the ports of the profile.
+func (n *nmapWrapper) scanOptions(ipAddresses []string, ports string, ipv6 bool, ctx context.Context) []func(*nmap.Scanner) {
+	options := []func(*nmap.Scanner){
 		nmap.WithTargets(ipAddresses...),
 		nmap.WithContext(ctx),
 		nmap.WithSkipHostDiscovery(),
-		nmap.WithUnprivileged(),
-	)
+	}
+
+	if ipv6 {
+		options = append(options, nmap.WithIPv6Scanning())
+	}
+
+	if n.interfaceName != "" {
+		options = append(options, nmap.WithInterface(n.interfaceName))
+	}
+
+	profile := n.profile
+	if ports != "" {
+		portsProfile := config.ScanProfile{}
+		if profile != nil {
+			portsProfile = *profile
+		}
+		portsProfile.Ports = ports
+		portsProfile.TopPorts = 0
+		profile = &portsProfile
+	}
+
+	return append(options, nmap.WithCustomArguments(profileArguments(profile)...))
+}
+
+// profileArguments translates a scan profile into nmap arguments.
+func profileArguments(profile *config.ScanProfile) []string {
+	if profile == nil {
+		profile = &config.ScanProfile{}
+	}
+
+	var args []string
+
+	// UDP scans need raw sockets, so a SYN scan is used for TCP alongside it instead of running unprivileged.
+	if profile.UDP {
+		args = append(args, "-sS", "-sU")
+	} else {
+		args = append(args, "--unprivileged")
+	}
+
+	if profile.Ports != "" {
+		args = append(args, "-p", profile.Ports)
+	} else if profile.TopPorts > 0 {
+		args = append(args, "--top-ports", strconv.Itoa(profile.TopPorts))
+	}
+
+	if profile.TimingTemplate != nil {
+		args = append(args, "-T"+strconv.Itoa(*profile.TimingTemplate))
+	}
+
+	if profile.MinRate > 0 {
+		args = append(args, "--min-rate", strconv.Itoa(profile.MinRate))
+	}
+
+	if profile.MaxRetries != nil {
+		args = append(args, "--max-retries", strconv.Itoa(*profile.MaxRetries))
+	}
+
+	if profile.ServiceVersion {
+		args = append(args, "-sV")
+	}
+
+	return append(args, profile.ExtraArgs...)
 }
 
 type NmapSvc interface {
@@ -111,20 +418,44 @@ type nmapStruct struct {
 	nmapClientSvc     wrapper.NmapClientWrapper
 	currentInstances  map[string]wrapper.PortMap
 	previousInstances map[string]wrapper.PortMap
-	scanParser        *scanParser
-	currentScanSlice  []byte
+	currentDefaults   map[string]portDefaults
+	previousDefaults  map[string]portDefaults
+	// currentIdentities and previousIdentities hold the identity of the resource behind each host, keyed by address.
+	currentIdentities  map[string]string
+	previousIdentities map[string]string
+	// previousHosts holds the hosts of the previous scan as nmap reported them, keyed by address.
+	previousHosts    map[string]nmap.Host
+	scanParser       *scanParser
+	currentScanSlice []byte
 }
 
-func New() *nmapStruct {
+func New(configObject config.BaseConfig) (*nmapStruct, error) {
+	profile, err := configObject.SelectedScanProfile()
+	if err != nil {
+		return nil, fmt.Errorf("New: Error selecting scan profile %s", err)
+	}
+
+	err = profile.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("New: Invalid scan profile %s", err)
+	}
+
 	n := &nmapStruct{}
 	n.ctx, n.cancel = context.WithTimeout(context.Background(), 5*time.Hour)
 	n.nmapClientSvc = &nmapWrapper{
 		interfaceName: os.Getenv("NMAP_DEVICE"),
+		profile:       profile,
 	}
 	n.currentInstances = make(map[string]wrapper.PortMap)
 	n.previousInstances = make(map[string]wrapper.PortMap)
-	n.scanParser = newParser(n.previousInstances, n.currentInstances)
-	return n
+	n.currentDefaults = make(map[string]portDefaults)
+	n.previousDefaults = make(map[string]portDefaults)
+	n.currentIdentities = make(map[string]string)
+	n.previousIdentities = make(map[string]string)
+	n.previousHosts = make(map[string]nmap.Host)
+	n.scanParser = newParser(n.previousInstances, n.currentInstances, n.previousDefaults, n.currentDefaults,
+		n.previousIdentities, n.currentIdentities)
+	return n, nil
 }
 
 func (n *nmapStruct) ParsePreviousScan(scanBytes []byte) error {
@@ -134,7 +465,9 @@ func (n *nmapStruct) ParsePreviousScan(scanBytes []byte) error {
 	}
 
 	for _, host := range previousResult.Hosts {
-		if len(host.Ports) == 0 || len(host.Addresses) == 0 {
+		// Hosts without any open ports are still recorded so that closing every port is not reported as a vanished
+		// host.
+		if len(host.Addresses) == 0 {
 			continue
 		}
 
@@ -144,15 +477,220 @@ func (n *nmapStruct) ParsePreviousScan(scanBytes []byte) error {
 
 		for _, port := range host.Ports {
 			fmt.Printf("\tPort %d/%s %s %s\n", port.ID, port.Protocol, port.State, port.Service.Name)
-			if port.State.String() == "open" {
-				hostMap[port.ID] = true
-			}
+			hostMap[portKey(port)] = portState(port)
 		}
 		n.previousInstances[host.Addresses[0].Addr] = hostMap
+		n.previousDefaults[host.Addresses[0].Addr] = hostDefaults(host)
+		n.previousHosts[host.Addresses[0].Addr] = host
+		if identity := parseHostComment(host).identity; identity != "" {
+			n.previousIdentities[host.Addresses[0].Addr] = identity
+		}
 	}
 	return nil
 }
 
+// hostRecord holds what the comment of a host of a stored scan records about the server behind it.
+type hostRecord struct {
+	identity string
+	provider string
+	scopes   []string
+	// extraPorts is the port list the host was scanned for besides the scan profile, and extraState the state of the
+	// ports of that list nmap did not list.
+	extraPorts string
+	extraState string
+}
+
+// parseHostComment returns what the comment of the host records. Every field is empty for hosts scanned without a
+// comment, and only the identity is set for those of scans taken before the provider and scopes were recorded.
+func parseHostComment(host nmap.Host) hostRecord {
+	if strings.HasPrefix(host.Comment, identityCommentPrefix) {
+		return hostRecord{identity: strings.TrimPrefix(host.Comment, identityCommentPrefix)}
+	}
+	if !strings.HasPrefix(host.Comment, hostCommentPrefix) {
+		return hostRecord{}
+	}
+	values, err := url.ParseQuery(strings.TrimPrefix(host.Comment, hostCommentPrefix))
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to parse the comment of host " + host.Comment)
+		return hostRecord{}
+	}
+	return hostRecord{
+		identity:   values.Get("identity"),
+		provider:   values.Get("provider"),
+		scopes:     values["scope"],
+		extraPorts: values.Get("extraports"),
+		extraState: values.Get("extrastate"),
+	}
+}
+
+// hostComment returns the comment that records the identity, provider and scopes of the server, along with the state
+// of the extra ports nmap did not list, or an empty string when nothing is known about it.
+func hostComment(s server.Server, extraState string) string {
+	values := url.Values{}
+	if identity := s.Identity(); identity != "" {
+		values.Set("identity", identity)
+	}
+	if s.Provider != "" {
+		values.Set("provider", s.Provider)
+	}
+	for _, scope := range s.Scopes {
+		values.Add("scope", scope)
+	}
+	if s.ExtraPorts != "" {
+		values.Set("extraports", s.ExtraPorts)
+		if extraState != "" {
+			values.Set("extrastate", extraState)
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return hostCommentPrefix + values.Encode()
+}
+
+// recordHosts sets the comment of every host of the scan found in servers, so the next run can relate the hosts of
+// this scan by identity, tell which inventory scope they belong to and know the state of their extra ports, given by
+// extraStates. The scan is encoded again when a comment was recorded.
+func recordHosts(result *nmap.Run, servers map[string]server.Server, extraStates map[string]string) (*nmap.Run, error) {
+	recorded := false
+	for index, host := range result.Hosts {
+		if len(host.Addresses) == 0 {
+			continue
+		}
+		address := host.Addresses[0].Addr
+		if comment := hostComment(servers[address], extraStates[address]); comment != "" {
+			result.Hosts[index].Comment = comment
+			recorded = true
+		}
+	}
+	if !recorded {
+		return result, nil
+	}
+
+	data, err := xml.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("recordHosts: Error encoding scan %s", err)
+	}
+	return nmap.Parse(append([]byte(xml.Header), data...))
+}
+
+// inFailedScope returns true when the host belongs to a scope of inventoryErrors. Hosts whose provider is unknown, such
+// as those of scans taken before providers were recorded, cannot be related to a scope and are considered part of
+// every failed scope.
+func inFailedScope(record hostRecord, inventoryErrors []wrapper.InventoryError) bool {
+	if record.provider == "" {
+		return len(inventoryErrors) > 0
+	}
+	for _, inventoryError := range inventoryErrors {
+		if inventoryError.Provider != record.provider {
+			continue
+		}
+		if inventoryError.Scope == wrapper.AllScopes {
+			return true
+		}
+		for _, scope := range record.scopes {
+			if scope == inventoryError.Scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portKey identifies a port from the nmap output by both its protocol and number.
+func portKey(port nmap.Port) server.Port {
+	return server.Port{Protocol: port.Protocol, ID: port.ID}
+}
+
+// portState keeps the state of the port and the parts of the detected service that are compared between scans.
+func portState(port nmap.Port) server.PortState {
+	return server.PortState{
+		State: port.State.String(),
+		Service: server.Service{
+			Name:      port.Service.Name,
+			Product:   port.Service.Product,
+			Version:   port.Service.Version,
+			ExtraInfo: port.Service.ExtraInfo,
+			Method:    port.Service.Method,
+		},
+	}
+}
+
+// extraPortsState returns the state of the ports nmap did not list for the host. nmap only leaves ports out when they
+// share a state, so the state is only known when there is a single extraports entry.
+func extraPortsState(host nmap.Host) string {
+	if len(host.ExtraPorts) != 1 {
+		return ""
+	}
+	return host.ExtraPorts[0].State
+}
+
+// portDefaults holds the state of the ports nmap did not list for a host: that of the extraports of the scan, and
+// that of the extraports of the run of the extra ports of the host, which only applies to those ports.
+type portDefaults struct {
+	state      string
+	extraPorts string
+	extraState string
+}
+
+// hostDefaults returns the state of the ports nmap did not list for the host.
+func hostDefaults(host nmap.Host) portDefaults {
+	record := parseHostComment(host)
+	return portDefaults{state: extraPortsState(host), extraPorts: record.extraPorts, extraState: record.extraState}
+}
+
+// stateOf returns the state of the port when nmap did not list it, or an empty string when it is unknown.
+func (d portDefaults) stateOf(port server.Port) string {
+	if d.extraPorts != "" && inPortList(d.extraPorts, port) {
+		return d.extraState
+	}
+	return d.state
+}
+
+// inPortList returns whether the port is part of the nmap port list, such as "22,30000-32767" or "U:53,T:80-90".
+// Ports listed after a T:, U: or S: prefix only apply to TCP, UDP or SCTP, the others to every protocol.
+func inPortList(list string, port server.Port) bool {
+	protocol := ""
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if index := strings.Index(entry, ":"); index >= 0 {
+			switch strings.ToUpper(entry[:index]) {
+			case "T":
+				protocol = "tcp"
+			case "U":
+				protocol = "udp"
+			case "S":
+				protocol = "sctp"
+			}
+			entry = entry[index+1:]
+		}
+		if protocol != "" && protocol != port.Protocol {
+			continue
+		}
+
+		low, high := entry, entry
+		if index := strings.Index(entry, "-"); index >= 0 {
+			low, high = entry[:index], entry[index+1:]
+		}
+		first, last := uint64(1), uint64(65535)
+		var err error
+		if low != "" {
+			if first, err = strconv.ParseUint(low, 10, 16); err != nil {
+				continue
+			}
+		}
+		if high != "" {
+			if last, err = strconv.ParseUint(high, 10, 16); err != nil {
+				continue
+			}
+		}
+		if uint64(port.ID) >= first && uint64(port.ID) <= last {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *nmapStruct) CurrentScanResults() ([]byte, error) {
 	if n.currentScanSlice == nil {
 		return nil, fmt.Errorf("CurrentScanResults: currentScanSlice is nil")
@@ -160,7 +698,7 @@ func (n *nmapStruct) CurrentScanResults() ([]byte, error) {
 	return n.currentScanSlice, nil
 }
 
-func (n *nmapStruct) StartScan(ipAddresses []string) error {
+func (n *nmapStruct) StartScan(ipAddresses []string, servers map[string]server.Server) error {
 	defer n.cancel()
 
 	if n.nmapClientSvc == nil {
@@ -168,7 +706,7 @@ func (n *nmapStruct) StartScan(ipAddresses []string) error {
 	}
 
 	log.Debug("Starting Scan")
-	result, warnings, err := n.nmapClientSvc.Run(ipAddresses, n.ctx)
+	result, warnings, err := n.nmapClientSvc.Run(ipAddresses, "", n.ctx)
 
 	if warnings != nil {
 		log.Warn("Warnings: \n", warnings)
@@ -178,6 +716,16 @@ func (n *nmapStruct) StartScan(ipAddresses []string) error {
 		return fmt.Errorf("StartScan: unable to run nmap scan: %s", err)
 	}
 
+	result, extraStates, err := n.scanExtraPorts(result, servers)
+	if err != nil {
+		return fmt.Errorf("StartScan: %s", err)
+	}
+
+	result, err = recordHosts(result, servers, extraStates)
+	if err != nil {
+		return fmt.Errorf("StartScan: %s", err)
+	}
+
 	currentScan, err := ioutil.ReadAll(result.ToReader())
 	if err != nil {
 		return fmt.Errorf("StartScan: Error reading previous scan %s", err)
@@ -187,25 +735,165 @@ func (n *nmapStruct) StartScan(ipAddresses []string) error {
 
 	// Add all ports that are open
 	for _, host := range result.Hosts {
-		if len(host.Ports) == 0 || len(host.Addresses) == 0 {
+		if len(host.Addresses) == 0 {
 			continue
 		}
 		hostEntry := make(wrapper.PortMap)
 
 		for _, port := range host.Ports {
-			if port.State.String() == "open" {
-				hostEntry[port.ID] = true
-			}
+			hostEntry[portKey(port)] = portState(port)
 		}
 		n.currentInstances[host.Addresses[0].Addr] = hostEntry
+		n.currentDefaults[host.Addresses[0].Addr] = hostDefaults(host)
+		if identity := parseHostComment(host).identity; identity != "" {
+			n.currentIdentities[host.Addresses[0].Addr] = identity
+		}
 	}
 	return nil
 }
 
-// DiffScans takes a map of instances from a past scan and a current one. The function returns instances with ports
-// that are were opened and closed. It does this by comparing the two maps that are passed to the function and iterating
-// through each.
-func (n *nmapStruct) DiffScans() map[string]wrapper.PortMap {
+// scanExtraPorts scans the servers with ExtraPorts for those ports, in a run per port list, and adds the ports found
+// to the hosts of the result. Ports the result already lists are kept as they are. The state of the ports nmap did
+// not list on the run of the extra ports of each host is returned keyed by address, and is empty when it is unknown.
+func (n *nmapStruct) scanExtraPorts(result *nmap.Run, servers map[string]server.Server) (*nmap.Run, map[string]string, error) {
+	extraStates := make(map[string]string)
+	addressesByPorts := make(map[string][]string)
+	for address, s := range servers {
+		if s.ExtraPorts != "" {
+			addressesByPorts[s.ExtraPorts] = append(addressesByPorts[s.ExtraPorts], address)
+		}
+	}
+
+	portLists := make([]string, 0, len(addressesByPorts))
+	for ports := range addressesByPorts {
+		portLists = append(portLists, ports)
+	}
+	sort.Strings(portLists)
+
+	for _, ports := range portLists {
+		addresses := addressesByPorts[ports]
+		sort.Strings(addresses)
+
+		log.Debug("Scanning ports " + ports + " of " + strings.Join(addresses, ", "))
+		extraResult, warnings, err := n.nmapClientSvc.Run(addresses, ports, n.ctx)
+		if warnings != nil {
+			log.Warn("Warnings: \n", warnings)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("scanExtraPorts: unable to scan ports %s: %s", ports, err)
+		}
+
+		for _, host := range extraResult.Hosts {
+			if len(host.Addresses) > 0 {
+				extraStates[host.Addresses[0].Addr] = extraPortsState(host)
+			}
+		}
+		result, err = addPorts(result, extraResult)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scanExtraPorts: %s", err)
+		}
+	}
+	return result, extraStates, nil
+}
+
+// addPorts returns the first run with the ports of the hosts of the second run added to the host with the same
+// address. Ports the first run lists are kept, and hosts only found on the second run are left out.
+func addPorts(first *nmap.Run, second *nmap.Run) (*nmap.Run, error) {
+	merged := *first
+	merged.Hosts = append([]nmap.Host{}, first.Hosts...)
+
+	hostIndexes := make(map[string]int)
+	for index, host := range merged.Hosts {
+		if len(host.Addresses) > 0 {
+			hostIndexes[host.Addresses[0].Addr] = index
+		}
+	}
+
+	for _, host := range second.Hosts {
+		if len(host.Addresses) == 0 {
+			continue
+		}
+		index, ok := hostIndexes[host.Addresses[0].Addr]
+		if !ok {
+			continue
+		}
+
+		listed := make(map[server.Port]bool)
+		ports := append([]nmap.Port{}, merged.Hosts[index].Ports...)
+		for _, port := range ports {
+			listed[portKey(port)] = true
+		}
+		for _, port := range host.Ports {
+			if !listed[portKey(port)] {
+				ports = append(ports, port)
+			}
+		}
+		merged.Hosts[index].Ports = ports
+	}
+
+	data, err := xml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("addPorts: Error encoding scan %s", err)
+	}
+	return nmap.Parse(append([]byte(xml.Header), data...))
+}
+
+// CarryForward copies the hosts of the previous scan that belong to a scope of inventoryErrors and were not scanned,
+// because they are not part of ipAddresses, into the current scan. The hosts of the failed scopes are then neither
+// reported as removed nor dropped from the scan stored for the next run, while hosts of the scopes that were
+// inventoried are still reported as removed. Hosts that were scanned are never replaced.
+func (n *nmapStruct) CarryForward(ipAddresses []string, inventoryErrors []wrapper.InventoryError) error {
+	if n.currentScanSlice == nil {
+		return fmt.Errorf("CarryForward: currentScanSlice is nil")
+	}
+
+	scanned := make(map[string]bool)
+	for _, address := range ipAddresses {
+		scanned[address] = true
+	}
+
+	var addresses []string
+	for address, host := range n.previousHosts {
+		if !scanned[address] && n.currentInstances[address] == nil && inFailedScope(parseHostComment(host), inventoryErrors) {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	sort.Strings(addresses)
+
+	result, err := nmap.Parse(n.currentScanSlice)
+	if err != nil {
+		return fmt.Errorf("CarryForward: Error parsing current scan %s", err)
+	}
+	carried := nmap.Run{}
+	for _, address := range addresses {
+		log.Debug("Carrying " + address + " forward from the previous scan")
+		carried.Hosts = append(carried.Hosts, n.previousHosts[address])
+		n.currentInstances[address] = n.previousInstances[address]
+		n.currentDefaults[address] = n.previousDefaults[address]
+		if identity, ok := n.previousIdentities[address]; ok {
+			n.currentIdentities[address] = identity
+		}
+	}
+
+	merged, err := mergeRuns(result, &carried)
+	if err != nil {
+		return fmt.Errorf("CarryForward: %s", err)
+	}
+	currentScan, err := ioutil.ReadAll(merged.ToReader())
+	if err != nil {
+		return fmt.Errorf("CarryForward: Error reading current scan %s", err)
+	}
+	n.currentScanSlice = currentScan
+	return nil
+}
+
+// DiffScans compares the instances from the previous scan with the ones from the current scan. The function returns
+// the ports that were opened and closed on known hosts, along with the hosts that appeared or vanished since the last
+// scan.
+func (n *nmapStruct) DiffScans() wrapper.ScanDiff {
 	log.WithFields(log.Fields{
 		"previousInstanceCount": len(n.previousInstances),
 		"currentInstanceCount":  len(n.currentInstances),
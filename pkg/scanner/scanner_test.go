@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/Invoca/nmap-diff/pkg/wrapper"
 
 	"github.com/Invoca/nmap-diff/pkg/mocks"
@@ -37,7 +39,10 @@ func TestParsePreviousScan(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 	log.Debug("Starting TestParsePreviousScan")
 
-	nmapInterface := New()
+	nmapInterface, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
 
 	testCases := []scannerParseTestCase{
 		{
@@ -89,39 +94,45 @@ func TestParsePreviousScan(t *testing.T) {
 func TestNmapDiffScans(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 	firstInstanceName := "Ready Instance 1"
-	firstInstancePort := uint16(16)
+	firstInstancePort := server.Port{Protocol: "tcp", ID: 16}
 
 	secondInstanceName := "Over Port 9000"
-	secondInstancePort := uint16(9001)
+	secondInstancePort := server.Port{Protocol: "tcp", ID: 9001}
 
 	thirdInstanceName := "An Instance Of The Impossible"
-	thirdInstancePort := uint16(0)
+	thirdInstancePort := server.Port{Protocol: "tcp", ID: 0}
+
+	openState := server.PortState{State: server.PortOpen}
 
 	instancesFromCurrentScan := make(map[string]wrapper.PortMap)
 	instancesFromPreviousScan := make(map[string]wrapper.PortMap)
 
-	newInstancesExposed := make(map[string]wrapper.PortMap)
+	diff := wrapper.NewScanDiff()
 
-	n := New()
+	n, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
 
 	testCases := []scannerDiffTestCase{
 		{
-			desc: "Three new instances are found and should be added to NewInstancesExposed",
+			desc: "Three new instances are found and should be added to NewHosts",
 			setup: func() {
 				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
 				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
-				newInstancesExposed = make(map[string]wrapper.PortMap)
+				diff = wrapper.NewScanDiff()
 				n.scanParser.currentInstances = instancesFromCurrentScan
 				n.scanParser.previousInstances = instancesFromPreviousScan
-				n.scanParser.newInstancesExposed = newInstancesExposed
-				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: true}
-				instancesFromCurrentScan[secondInstanceName] = wrapper.PortMap{secondInstancePort: true}
-				instancesFromCurrentScan[thirdInstanceName] = wrapper.PortMap{thirdInstancePort: true}
+				n.scanParser.diff = diff
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				instancesFromCurrentScan[secondInstanceName] = wrapper.PortMap{secondInstancePort: openState}
+				instancesFromCurrentScan[thirdInstanceName] = wrapper.PortMap{thirdInstancePort: openState}
 			},
 			assertions: func() {
-				assert.Equal(t, true, wrapper.PortMap(newInstancesExposed[firstInstanceName])[firstInstancePort])
-				assert.Equal(t, true, wrapper.PortMap(newInstancesExposed[secondInstanceName])[secondInstancePort])
-				assert.Equal(t, true, wrapper.PortMap(newInstancesExposed[thirdInstanceName])[thirdInstancePort])
+				assert.Contains(t, diff.NewHosts[firstInstanceName], firstInstancePort)
+				assert.Contains(t, diff.NewHosts[secondInstanceName], secondInstancePort)
+				assert.Contains(t, diff.NewHosts[thirdInstanceName], thirdInstancePort)
+				assert.Equal(t, 0, len(diff.OpenedPorts))
 			},
 		},
 		{
@@ -129,17 +140,18 @@ func TestNmapDiffScans(t *testing.T) {
 			setup: func() {
 				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
 				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
-				newInstancesExposed = make(map[string]wrapper.PortMap)
+				diff = wrapper.NewScanDiff()
 				n.scanParser.currentInstances = instancesFromCurrentScan
 				n.scanParser.previousInstances = instancesFromPreviousScan
-				n.scanParser.newInstancesExposed = newInstancesExposed
+				n.scanParser.diff = diff
 				instancesFromPreviousScan[firstInstanceName] = make(wrapper.PortMap)
 				instancesFromCurrentScan[secondInstanceName] = make(wrapper.PortMap)
-				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: true}
-				instancesFromCurrentScan[secondInstanceName] = wrapper.PortMap{secondInstancePort: true}
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				instancesFromCurrentScan[secondInstanceName] = wrapper.PortMap{secondInstancePort: openState}
 			},
 			assertions: func() {
-				assert.Equal(t, true, wrapper.PortMap(newInstancesExposed[secondInstanceName])[secondInstancePort])
+				assert.Contains(t, diff.NewHosts[secondInstanceName], secondInstancePort)
+				assert.Contains(t, diff.RemovedHosts[firstInstanceName], firstInstancePort)
 			},
 		},
 		{
@@ -147,15 +159,15 @@ func TestNmapDiffScans(t *testing.T) {
 			setup: func() {
 				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
 				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
-				newInstancesExposed = make(map[string]wrapper.PortMap)
+				diff = wrapper.NewScanDiff()
 				n.scanParser.currentInstances = instancesFromCurrentScan
 				n.scanParser.previousInstances = instancesFromPreviousScan
-				n.scanParser.newInstancesExposed = newInstancesExposed
-				instancesFromCurrentScan[firstInstanceName] = make(map[uint16]bool)
-				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: true}
+				n.scanParser.diff = diff
+				instancesFromCurrentScan[firstInstanceName] = make(wrapper.PortMap)
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
 			},
 			assertions: func() {
-				assert.Equal(t, true, wrapper.PortMap(newInstancesExposed[firstInstanceName])[firstInstancePort])
+				assert.Contains(t, diff.NewHosts[firstInstanceName], firstInstancePort)
 			},
 		},
 		{
@@ -163,15 +175,185 @@ func TestNmapDiffScans(t *testing.T) {
 			setup: func() {
 				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
 				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
-				instancesFromPreviousScan[firstInstanceName] = make(map[uint16]bool)
-				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: true}
-				newInstancesExposed = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = make(wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, 0, len(diff.NewHosts))
+				assert.Equal(t, 0, len(diff.OpenedPorts))
+				assert.Contains(t, diff.RemovedHosts[firstInstanceName], firstInstancePort)
+			},
+		},
+		{
+			desc: "One instance is still found but one port was closed and another was opened",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState, secondInstancePort: openState}
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{secondInstancePort: openState, thirdInstancePort: openState}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, wrapper.PortMap{thirdInstancePort: openState}, diff.OpenedPorts[firstInstanceName])
+				assert.Equal(t, wrapper.PortMap{firstInstancePort: openState}, diff.ClosedPorts[firstInstanceName])
+				assert.Equal(t, 0, len(diff.NewHosts))
+				assert.Equal(t, 0, len(diff.RemovedHosts))
+			},
+		},
+		{
+			desc: "One instance is still found but every port was closed",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				instancesFromCurrentScan[firstInstanceName] = make(wrapper.PortMap)
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Contains(t, diff.ClosedPorts[firstInstanceName], firstInstancePort)
+				assert.Equal(t, 0, len(diff.RemovedHosts))
+			},
+		},
+		{
+			desc: "The same port number changing on one protocol is not hidden by the other protocol",
+			setup: func() {
+				tcpPort := server.Port{Protocol: "tcp", ID: 53}
+				udpPort := server.Port{Protocol: "udp", ID: 53}
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{tcpPort: openState}
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{udpPort: openState}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, wrapper.PortMap{server.Port{Protocol: "udp", ID: 53}: openState}, diff.OpenedPorts[firstInstanceName])
+				assert.Equal(t, wrapper.PortMap{server.Port{Protocol: "tcp", ID: 53}: openState}, diff.ClosedPorts[firstInstanceName])
+			},
+		},
+		{
+			desc: "A port stayed open but the probed service on it changed",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort:  {State: server.PortOpen, Service: server.Service{Name: "https", Product: "nginx", Version: "1.18", Method: "probed"}},
+					secondInstancePort: {State: server.PortOpen, Service: server.Service{Name: "http", Product: "Apache httpd", Method: "probed"}},
+				}
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort:  {State: server.PortOpen, Service: server.Service{Name: "ssh", Product: "OpenSSH", Version: "8.2p1", Method: "probed"}},
+					secondInstancePort: {State: server.PortOpen, Service: server.Service{Name: "http", Product: "Apache httpd", Method: "probed"}},
+				}
+				diff = wrapper.NewScanDiff()
 				n.scanParser.currentInstances = instancesFromCurrentScan
 				n.scanParser.previousInstances = instancesFromPreviousScan
-				n.scanParser.newInstancesExposed = newInstancesExposed
+				n.scanParser.diff = diff
 			},
 			assertions: func() {
-				assert.Equal(t, 0, len(newInstancesExposed))
+				assert.Equal(t, 1, len(diff.ChangedServices[firstInstanceName]))
+				change := diff.ChangedServices[firstInstanceName][0]
+				assert.Equal(t, firstInstancePort, change.Port)
+				assert.Equal(t, "nginx", change.Previous.Product)
+				assert.Equal(t, "OpenSSH", change.Current.Product)
+				assert.Equal(t, 0, len(diff.OpenedPorts))
+				assert.Equal(t, 0, len(diff.ClosedPorts))
+			},
+		},
+		{
+			desc: "A service is not reported as changed when version detection only ran on one of the scans",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort: {State: server.PortOpen, Service: server.Service{Name: "https", Method: "table"}},
+				}
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort: {State: server.PortOpen, Service: server.Service{Name: "https", Product: "nginx", Version: "1.18", Method: "probed"}},
+				}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, true, diff.Empty())
+			},
+		},
+		{
+			desc: "Ports that changed state are reported as typed transitions",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort:  {State: server.PortClosed},
+					secondInstancePort: {State: server.PortFiltered},
+				}
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{
+					secondInstancePort: {State: server.PortOpen},
+				}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.previousDefaults = map[string]portDefaults{firstInstanceName: {state: server.PortFiltered}}
+				n.scanParser.currentDefaults = map[string]portDefaults{firstInstanceName: {state: server.PortFiltered}}
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, []server.StateTransition{
+					{Port: firstInstancePort, Previous: server.PortClosed, Current: server.PortFiltered},
+					{Port: secondInstancePort, Previous: server.PortFiltered, Current: server.PortOpen},
+				}, diff.StateTransitions[firstInstanceName])
+				assert.Contains(t, diff.OpenedPorts[firstInstanceName], secondInstancePort)
+				assert.Equal(t, 0, len(diff.ClosedPorts))
+			},
+		},
+		{
+			desc: "Transitions are not reported when the state of a port is unknown on one of the scans",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort: {State: server.PortClosed},
+				}
+				instancesFromCurrentScan[firstInstanceName] = make(wrapper.PortMap)
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.previousDefaults = map[string]portDefaults{}
+				n.scanParser.currentDefaults = map[string]portDefaults{}
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, true, diff.Empty())
+			},
+		},
+		{
+			desc: "A new host with no open ports is not reported",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{
+					firstInstancePort: {State: server.PortClosed},
+				}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, true, diff.Empty())
 			},
 		},
 		{
@@ -179,13 +361,87 @@ func TestNmapDiffScans(t *testing.T) {
 			setup: func() {
 				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
 				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
-				newInstancesExposed = make(map[string]wrapper.PortMap)
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, true, diff.Empty())
+			},
+		},
+		{
+			desc: "A resource found at another address is diffed against its previous address",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				instancesFromCurrentScan[secondInstanceName] = wrapper.PortMap{
+					firstInstancePort:  openState,
+					secondInstancePort: openState,
+				}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.previousIdentities = map[string]string{firstInstanceName: "aws:i-123"}
+				n.scanParser.currentIdentities = map[string]string{secondInstanceName: "aws:i-123"}
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, map[string]server.AddressChange{
+					secondInstanceName: {Identity: "aws:i-123", Previous: firstInstanceName, Current: secondInstanceName},
+				}, diff.AddressChanges)
+				assert.Equal(t, wrapper.PortMap{secondInstancePort: openState}, diff.OpenedPorts[secondInstanceName])
+				assert.Equal(t, 0, len(diff.NewHosts))
+				assert.Equal(t, 0, len(diff.RemovedHosts))
+			},
+		},
+		{
+			desc: "An address that belongs to another resource is reported as reassigned",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				instancesFromCurrentScan[firstInstanceName] = wrapper.PortMap{firstInstancePort: openState}
+				diff = wrapper.NewScanDiff()
+				n.scanParser.currentInstances = instancesFromCurrentScan
+				n.scanParser.previousInstances = instancesFromPreviousScan
+				n.scanParser.previousIdentities = map[string]string{firstInstanceName: "aws:i-123"}
+				n.scanParser.currentIdentities = map[string]string{firstInstanceName: "aws:i-456"}
+				n.scanParser.diff = diff
+			},
+			assertions: func() {
+				assert.Equal(t, map[string]server.Reassignment{
+					firstInstanceName: {Address: firstInstanceName, Previous: "aws:i-123", Current: "aws:i-456"},
+				}, diff.ReassignedAddresses)
+				assert.Contains(t, diff.NewHosts, firstInstanceName)
+				assert.Contains(t, diff.RemovedHosts, firstInstanceName)
+				assert.Equal(t, 0, len(diff.OpenedPorts))
+				assert.Equal(t, 0, len(diff.AddressChanges))
+			},
+		},
+		{
+			desc: "Resources with several addresses and scans without identities are related by address",
+			setup: func() {
+				instancesFromCurrentScan = make(map[string]wrapper.PortMap)
+				instancesFromPreviousScan = make(map[string]wrapper.PortMap)
+				for _, host := range []string{firstInstanceName, secondInstanceName, thirdInstanceName} {
+					instancesFromPreviousScan[host] = wrapper.PortMap{firstInstancePort: openState}
+					instancesFromCurrentScan[host] = wrapper.PortMap{firstInstancePort: openState}
+				}
+				diff = wrapper.NewScanDiff()
 				n.scanParser.currentInstances = instancesFromCurrentScan
 				n.scanParser.previousInstances = instancesFromPreviousScan
-				n.scanParser.newInstancesExposed = newInstancesExposed
+				n.scanParser.previousIdentities = map[string]string{firstInstanceName: "aws:i-123", secondInstanceName: "aws:i-123"}
+				n.scanParser.currentIdentities = map[string]string{
+					firstInstanceName:  "aws:i-123",
+					secondInstanceName: "aws:i-123",
+					thirdInstanceName:  "gcloud:instance-1",
+				}
+				n.scanParser.diff = diff
 			},
 			assertions: func() {
-				assert.Equal(t, 0, len(newInstancesExposed))
+				assert.Equal(t, true, diff.Empty())
 			},
 		},
 	}
@@ -209,7 +465,10 @@ func TestRunNmapScan(t *testing.T) {
 		"2.2.2.2",
 	}
 	serviceMock := mocks.ScannerMock{}
-	n := New()
+	n, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
 	n.nmapClientSvc = &serviceMock
 
 	result := nmap.Run{Hosts: []nmap.Host{
@@ -239,6 +498,28 @@ func TestRunNmapScan(t *testing.T) {
 		},
 	}}
 
+	protocolResult := nmap.Run{Hosts: []nmap.Host{
+		{
+			Addresses: []nmap.Address{
+				{
+					Addr: "2.2.2.2",
+				},
+			},
+			Ports: []nmap.Port{
+				{
+					ID:       uint16(53),
+					Protocol: "tcp",
+					State:    nmap.State{State: "open"},
+				},
+				{
+					ID:       uint16(53),
+					Protocol: "udp",
+					State:    nmap.State{State: "open"},
+				},
+			},
+		},
+	}}
+
 	testCases := []scannerTestCase{
 		{
 			desc: "Scan runs without and returns instances",
@@ -256,6 +537,14 @@ func TestRunNmapScan(t *testing.T) {
 			},
 			shouldError: false,
 		},
+		{
+			desc: "Scan keeps ports with the same number on different protocols",
+			setup: func() {
+				serviceMock.Reset()
+				serviceMock.On("Run", mock.Anything).Return(&protocolResult, []string{}, nil)
+			},
+			shouldError: false,
+		},
 		{
 			desc: "Scan runs without issue but returns no instances",
 			setup: func() {
@@ -273,7 +562,7 @@ func TestRunNmapScan(t *testing.T) {
 		}).Debug("Starting testCase " + strconv.Itoa(index))
 
 		testCase.setup()
-		err := n.StartScan(ipAddresses)
+		err := n.StartScan(ipAddresses, nil)
 		if testCase.shouldError {
 			assert.Error(t, err)
 		} else {
@@ -282,4 +571,347 @@ func TestRunNmapScan(t *testing.T) {
 
 	}
 
+	assert.Equal(t, 2, len(n.currentInstances["2.2.2.2"]))
+	assert.Contains(t, n.currentInstances["2.2.2.2"], server.Port{Protocol: "udp", ID: 53})
+	assert.Equal(t, "open", n.currentInstances["2.2.2.2"][server.Port{Protocol: "udp", ID: 53}].State)
+
+	t.Logf("TestRunNmapScan: identities, providers and scopes are stored with the scan and read back by the next run")
+	serviceMock.Reset()
+	serviceMock.On("Run", mock.Anything).Return(&protocolResult, []string{}, nil)
+	err = n.StartScan(ipAddresses, map[string]server.Server{
+		"2.2.2.2": {Address: "2.2.2.2", Provider: "aws", ResourceID: "i-123", Scopes: []string{"123/us-east-1", "default credentials"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "aws:i-123", n.currentIdentities["2.2.2.2"])
+
+	currentScan, err := n.CurrentScanResults()
+	assert.NoError(t, err)
+	next, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
+	assert.NoError(t, next.ParsePreviousScan(currentScan))
+	assert.Equal(t, map[string]string{"2.2.2.2": "aws:i-123"}, next.previousIdentities)
+	assert.Equal(t, 2, len(next.previousInstances["2.2.2.2"]))
+	assert.Equal(t, hostRecord{identity: "aws:i-123", provider: "aws", scopes: []string{"123/us-east-1", "default credentials"}},
+		parseHostComment(next.previousHosts["2.2.2.2"]))
+}
+
+func TestStartScanExtraPorts(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	serviceMock := mocks.ScannerMock{}
+	n, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
+	n.nmapClientSvc = &serviceMock
+
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{Hosts: []nmap.Host{
+		{
+			Addresses:  []nmap.Address{{Addr: "1.1.1.1"}},
+			ExtraPorts: []nmap.ExtraPort{{State: server.PortFiltered, Count: 999}},
+			Ports:      []nmap.Port{{ID: 22, Protocol: "tcp", State: nmap.State{State: "open"}}},
+		},
+		{
+			Addresses: []nmap.Address{{Addr: "2.2.2.2"}},
+			Ports:     []nmap.Port{{ID: 443, Protocol: "tcp", State: nmap.State{State: "open"}}},
+		},
+	}}, []string{}, nil).Once()
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{Hosts: []nmap.Host{
+		{
+			Addresses:  []nmap.Address{{Addr: "1.1.1.1"}},
+			ExtraPorts: []nmap.ExtraPort{{State: server.PortClosed, Count: 2767}},
+			Ports:      []nmap.Port{{ID: 30080, Protocol: "tcp", State: nmap.State{State: "open"}}},
+		},
+	}}, []string{}, nil).Once()
+
+	t.Logf("TestStartScanExtraPorts: the extra ports of a server are scanned and added to its host")
+	err = n.StartScan([]string{"1.1.1.1", "2.2.2.2"}, map[string]server.Server{
+		"1.1.1.1": {Address: "1.1.1.1", ExtraPorts: "30000-32767"},
+		"2.2.2.2": {Address: "2.2.2.2"},
+	})
+	assert.NoError(t, err)
+	serviceMock.AssertNumberOfCalls(t, "Run", 2)
+	assert.Equal(t, wrapper.PortMap{
+		server.Port{Protocol: "tcp", ID: 22}:    {State: server.PortOpen},
+		server.Port{Protocol: "tcp", ID: 30080}: {State: server.PortOpen},
+	}, n.currentInstances["1.1.1.1"])
+	assert.Equal(t, wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen}}, n.currentInstances["2.2.2.2"])
+
+	t.Logf("TestStartScanExtraPorts: unlisted extra ports take the state of the ports nmap did not list on their own run")
+	assert.Equal(t, server.PortClosed, n.currentDefaults["1.1.1.1"].stateOf(server.Port{Protocol: "tcp", ID: 30081}))
+	assert.Equal(t, server.PortFiltered, n.currentDefaults["1.1.1.1"].stateOf(server.Port{Protocol: "tcp", ID: 8080}))
+
+	t.Logf("TestStartScanExtraPorts: the extra ports are stored with the scan")
+	currentScan, err := n.CurrentScanResults()
+	assert.NoError(t, err)
+	next, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
+	assert.NoError(t, next.ParsePreviousScan(currentScan))
+	assert.Equal(t, n.currentInstances["1.1.1.1"], next.previousInstances["1.1.1.1"])
+	assert.Equal(t, portDefaults{state: server.PortFiltered, extraPorts: "30000-32767", extraState: server.PortClosed},
+		next.previousDefaults["1.1.1.1"])
+
+	t.Logf("TestStartScanExtraPorts: an extra port that is no longer listed closes with the state of its own run")
+	next.nmapClientSvc = &serviceMock
+	serviceMock.Reset()
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{Hosts: []nmap.Host{
+		{
+			Addresses:  []nmap.Address{{Addr: "1.1.1.1"}},
+			ExtraPorts: []nmap.ExtraPort{{State: server.PortFiltered, Count: 999}},
+			Ports:      []nmap.Port{{ID: 22, Protocol: "tcp", State: nmap.State{State: "open"}}},
+		},
+	}}, []string{}, nil).Once()
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{Hosts: []nmap.Host{
+		{
+			Addresses:  []nmap.Address{{Addr: "1.1.1.1"}},
+			ExtraPorts: []nmap.ExtraPort{{State: server.PortClosed, Count: 2768}},
+		},
+	}}, []string{}, nil).Once()
+	err = next.StartScan([]string{"1.1.1.1"}, map[string]server.Server{"1.1.1.1": {Address: "1.1.1.1", ExtraPorts: "30000-32767"}})
+	assert.NoError(t, err)
+	diff := next.DiffScans()
+	assert.Equal(t, wrapper.PortMap{server.Port{Protocol: "tcp", ID: 30080}: {State: server.PortOpen}}, diff.ClosedPorts["1.1.1.1"])
+	assert.Equal(t, []server.StateTransition{
+		{Port: server.Port{Protocol: "tcp", ID: 30080}, Previous: server.PortOpen, Current: server.PortClosed},
+	}, diff.StateTransitions["1.1.1.1"])
+
+	t.Logf("TestStartScanExtraPorts: an error scanning the extra ports fails the scan")
+	n, err = New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
+	n.nmapClientSvc = &serviceMock
+	serviceMock.Reset()
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{}, []string{}, nil).Once()
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{}, []string{}, fmt.Errorf("Error")).Once()
+	err = n.StartScan([]string{"1.1.1.1"}, map[string]server.Server{"1.1.1.1": {Address: "1.1.1.1", ExtraPorts: "30000-32767"}})
+	assert.Error(t, err)
+}
+
+func TestInPortList(t *testing.T) {
+	testCases := []struct {
+		list     string
+		port     server.Port
+		expected bool
+	}{
+		{"30000-32767", server.Port{Protocol: "tcp", ID: 30080}, true},
+		{"30000-32767", server.Port{Protocol: "udp", ID: 30080}, true},
+		{"30000-32767", server.Port{Protocol: "tcp", ID: 8080}, false},
+		{"22,80,443", server.Port{Protocol: "tcp", ID: 80}, true},
+		{"-1024", server.Port{Protocol: "tcp", ID: 22}, true},
+		{"60000-", server.Port{Protocol: "tcp", ID: 65000}, true},
+		{"U:53,T:80-90", server.Port{Protocol: "udp", ID: 53}, true},
+		{"U:53,T:80-90", server.Port{Protocol: "tcp", ID: 53}, false},
+		{"U:53,T:80-90", server.Port{Protocol: "tcp", ID: 85}, true},
+		{"U:53,T:80-90", server.Port{Protocol: "udp", ID: 85}, false},
+		{"http,443", server.Port{Protocol: "tcp", ID: 80}, false},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"list": testCase.list,
+			"port": testCase.port.String(),
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+		assert.Equal(t, testCase.expected, inPortList(testCase.list, testCase.port))
+	}
+}
+
+type scanProfileTestCase struct {
+	desc          string
+	configObject  config.BaseConfig
+	shouldError   bool
+	expectedArgs  []string
+	forbiddenArgs []string
+}
+
+func TestScanProfiles(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	maxRetries := 0
+
+	testCases := []scanProfileTestCase{
+		{
+			desc:          "Default profile keeps the original unprivileged options",
+			configObject:  config.BaseConfig{},
+			expectedArgs:  []string{"--unprivileged"},
+			forbiddenArgs: []string{"-sU", "--top-ports", "-p"},
+		},
+		{
+			desc:         "Built in profile is selected by name",
+			configObject: config.BaseConfig{ScanProfile: "quick"},
+			expectedArgs: []string{"--top-ports", "100", "-T4"},
+		},
+		{
+			desc: "Custom profile sets every option",
+			configObject: config.BaseConfig{
+				ScanProfile: "custom",
+				ScanProfiles: map[string]*config.ScanProfile{
+					"custom": {
+						Ports:          "53,443",
+						UDP:            true,
+						MinRate:        500,
+						MaxRetries:     &maxRetries,
+						ServiceVersion: true,
+						ExtraArgs:      []string{"--open"},
+					},
+				},
+			},
+			expectedArgs:  []string{"-p", "53,443", "-sS", "-sU", "--min-rate", "500", "--max-retries", "0", "-sV", "--open"},
+			forbiddenArgs: []string{"--unprivileged"},
+		},
+		{
+			desc:         "Unknown profile returns an error",
+			configObject: config.BaseConfig{ScanProfile: "does-not-exist"},
+			shouldError:  true,
+		},
+		{
+			desc: "Invalid profile returns an error",
+			configObject: config.BaseConfig{
+				ScanProfile:  "invalid",
+				ScanProfiles: map[string]*config.ScanProfile{"invalid": {TopPorts: -1}},
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		n, err := New(testCase.configObject)
+		if testCase.shouldError {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+
+		args := profileArguments(n.nmapClientSvc.(*nmapWrapper).profile)
+		for _, expectedArg := range testCase.expectedArgs {
+			assert.Contains(t, args, expectedArg)
+		}
+		for _, forbiddenArg := range testCase.forbiddenArgs {
+			assert.NotContains(t, args, forbiddenArg)
+		}
+	}
+}
+
+func TestSplitAddressFamilies(t *testing.T) {
+	ipv4Addresses, ipv6Addresses := splitAddressFamilies([]string{"1.1.1.1", "2600:1900:4000::1", "example.com", "::ffff:2.2.2.2"})
+	assert.Equal(t, []string{"1.1.1.1", "example.com", "::ffff:2.2.2.2"}, ipv4Addresses)
+	assert.Equal(t, []string{"2600:1900:4000::1"}, ipv6Addresses)
+}
+
+func TestMergeRuns(t *testing.T) {
+	fileContents, err := ioutil.ReadFile("./test_scan.xml")
+	assert.NoError(t, err)
+	first, err := nmap.Parse(fileContents)
+	assert.NoError(t, err)
+
+	second, err := nmap.Parse([]byte(`<?xml version="1.0"?>
+<nmaprun scanner="nmap" args="nmap -6">
+<host><status state="up"/><address addr="2600:1900:4000::1" addrtype="ipv6"/>
+<ports><port protocol="tcp" portid="443"><state state="open"/></port></ports></host>
+<runstats><finished time="1" timestr="" elapsed="1" summary="" exit="success"/><hosts up="1" down="0" total="1"/></runstats>
+</nmaprun>`))
+	assert.NoError(t, err)
+
+	merged, err := mergeRuns(first, second)
+	assert.NoError(t, err)
+	assert.Equal(t, len(first.Hosts)+1, len(merged.Hosts))
+	assert.Equal(t, first.Stats.Hosts.Total+1, merged.Stats.Hosts.Total)
+
+	t.Logf("TestMergeRuns: the raw XML of the merged scan lists every host")
+	raw, err := ioutil.ReadAll(merged.ToReader())
+	assert.NoError(t, err)
+	reparsed, err := nmap.Parse(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, len(merged.Hosts), len(reparsed.Hosts))
+	last := reparsed.Hosts[len(reparsed.Hosts)-1]
+	assert.Equal(t, "2600:1900:4000::1", last.Addresses[0].Addr)
+	assert.Equal(t, uint16(443), last.Ports[0].ID)
+}
+
+func TestCarryForward(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	serviceMock := mocks.ScannerMock{}
+	n, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
+	n.nmapClientSvc = &serviceMock
+
+	inventoryErrors := []wrapper.InventoryError{
+		{Provider: "aws", Scope: "123/us-east-1", Message: "error"},
+		{Provider: "gcloud", Scope: wrapper.AllScopes, Message: "error"},
+	}
+
+	err = n.CarryForward([]string{"1.1.1.1"}, inventoryErrors)
+	assert.Error(t, err)
+
+	err = n.ParsePreviousScan([]byte(`<?xml version="1.0"?>
+<nmaprun scanner="nmap">
+<host comment="nmap-diff:identity=aws%3Ai-123&amp;provider=aws&amp;scope=123%2Fus-east-1"><status state="up"/><address addr="1.1.1.1" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>
+<host comment="nmap-diff:identity=aws%3Ai-456&amp;provider=aws&amp;scope=123%2Fus-east-1"><status state="up"/><address addr="2.2.2.2" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="80"><state state="open"/></port></ports></host>
+<host><status state="up"/><address addr="3.3.3.3" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="443"><state state="open"/></port></ports></host>
+<host comment="nmap-diff:identity=aws%3Ai-789&amp;provider=aws&amp;scope=123%2Fus-west-2"><status state="up"/><address addr="4.4.4.4" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>
+<host comment="nmap-diff:provider=gcloud&amp;scope=project"><status state="up"/><address addr="5.5.5.5" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>
+<host comment="nmap-diff-identity:aws:i-012"><status state="up"/><address addr="6.6.6.6" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>
+</nmaprun>`))
+	assert.NoError(t, err)
+
+	serviceMock.On("Run", mock.Anything).Return(&nmap.Run{Hosts: []nmap.Host{
+		{
+			Addresses: []nmap.Address{{Addr: "1.1.1.1"}},
+			Ports:     []nmap.Port{{ID: 22, Protocol: "tcp", State: nmap.State{State: "open"}}},
+		},
+	}}, []string{}, nil)
+	ipAddresses := []string{"1.1.1.1", "3.3.3.3"}
+	err = n.StartScan(ipAddresses, map[string]server.Server{
+		"1.1.1.1": {Address: "1.1.1.1", Provider: "aws", ResourceID: "i-123", Scopes: []string{"123/us-east-1"}},
+	})
+	assert.NoError(t, err)
+
+	t.Logf("TestCarryForward: hosts of the failed scopes that were not scanned are carried forward, scanned hosts are not replaced")
+	err = n.CarryForward(ipAddresses, inventoryErrors)
+	assert.NoError(t, err)
+	assert.Equal(t, "aws:i-456", n.currentIdentities["2.2.2.2"])
+	assert.Contains(t, n.currentInstances, "5.5.5.5")
+	assert.NotContains(t, n.currentInstances, "3.3.3.3")
+
+	t.Logf("TestCarryForward: hosts of the scopes that were inventoried are not carried forward")
+	assert.NotContains(t, n.currentInstances, "4.4.4.4")
+
+	t.Logf("TestCarryForward: hosts whose provider was not recorded are carried forward")
+	assert.Equal(t, "aws:i-012", n.currentIdentities["6.6.6.6"])
+
+	diff := n.DiffScans()
+	assert.Equal(t, 0, len(diff.NewHosts))
+	assert.Equal(t, wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen}}, diff.RemovedHosts["3.3.3.3"])
+	assert.Contains(t, diff.RemovedHosts, "4.4.4.4")
+	assert.Equal(t, 2, len(diff.RemovedHosts))
+
+	currentScan, err := n.CurrentScanResults()
+	assert.NoError(t, err)
+	next, err := New(config.BaseConfig{})
+	if err != nil {
+		t.Fatalf("Error! %s", err)
+	}
+	assert.NoError(t, next.ParsePreviousScan(currentScan))
+	assert.Equal(t, map[string]string{"1.1.1.1": "aws:i-123", "2.2.2.2": "aws:i-456", "6.6.6.6": "aws:i-012"},
+		next.previousIdentities)
+	assert.Contains(t, next.previousInstances["2.2.2.2"], server.Port{Protocol: "tcp", ID: 80})
+	assert.Equal(t, "gcloud", parseHostComment(next.previousHosts["5.5.5.5"]).provider)
 }
@@ -1,9 +1,163 @@
 package server
 
+import (
+	"strconv"
+	"strings"
+)
+
 type Server struct {
-	Name        string
-	Address     string
-	ClosedPorts []uint16
-	OpenedPorts []uint16
-	Tags        map[string]string
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Provider names the inventory the server was found in, such as aws or gcloud.
+	Provider string `json:"provider"`
+	// ResourceID identifies the resource behind the address within its provider, such as an instance ID or an ARN.
+	// It is empty when the provider has nothing more stable than the address.
+	ResourceID string `json:"resourceId,omitempty"`
+	// Scopes names the inventory scopes the server was found in, such as an AWS account and region or a load balancer
+	// DNS name, using the names inventory errors are reported with.
+	Scopes []string `json:"scopes,omitempty"`
+	// ExtraPorts is an nmap port list scanned on the server besides the ports of the scan profile, such as the node
+	// port range of a Kubernetes node.
+	ExtraPorts       string            `json:"extraPorts,omitempty"`
+	ClosedPorts      []Port            `json:"closedPorts,omitempty"`
+	OpenedPorts      []Port            `json:"openedPorts,omitempty"`
+	ChangedServices  []ServiceChange   `json:"changedServices,omitempty"`
+	StateTransitions []StateTransition `json:"stateTransitions,omitempty"`
+	// PreviousAddress is the address the resource was found at on the previous scan, when it changed.
+	PreviousAddress string `json:"previousAddress,omitempty"`
+	// PreviousIdentity is the identity of the resource the address belonged to on the previous scan, when it changed.
+	PreviousIdentity string            `json:"previousIdentity,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+}
+
+// Identity returns the provider and resource ID of the server as "provider:resourceID", which relates hosts between
+// scans when their address changes. It is empty when the server has no resource ID.
+func (s Server) Identity() string {
+	if s.ResourceID == "" {
+		return ""
+	}
+	return s.Provider + ":" + s.ResourceID
+}
+
+// Port identifies a port by both its protocol and number so that ports such as 53/tcp and 53/udp are tracked
+// separately.
+type Port struct {
+	Protocol string `json:"protocol"`
+	ID       uint16 `json:"id"`
+}
+
+// String formats the port the same way nmap does, e.g. 443/tcp.
+func (p Port) String() string {
+	return strconv.FormatUint(uint64(p.ID), 10) + "/" + p.Protocol
+}
+
+// Less orders ports by protocol and then by port number.
+func (p Port) Less(other Port) bool {
+	if p.Protocol != other.Protocol {
+		return p.Protocol < other.Protocol
+	}
+	return p.ID < other.ID
+}
+
+// The port states reported by nmap.
+const (
+	PortOpen           = "open"
+	PortClosed         = "closed"
+	PortFiltered       = "filtered"
+	PortUnfiltered     = "unfiltered"
+	PortOpenFiltered   = "open|filtered"
+	PortClosedFiltered = "closed|filtered"
+)
+
+// PortState holds the state nmap reported for a port along with the service detected on it.
+type PortState struct {
+	State   string
+	Service Service
+}
+
+// Open returns true when nmap found the port open.
+func (p PortState) Open() bool {
+	return p.State == PortOpen
+}
+
+// Service holds what nmap detected running on a port. Method is "probed" when the service was found through version
+// detection and "table" when nmap only guessed it from the port number.
+type Service struct {
+	Name      string `json:"name"`
+	Product   string `json:"product"`
+	Version   string `json:"version"`
+	ExtraInfo string `json:"extraInfo"`
+	Method    string `json:"method"`
+}
+
+// Probed returns true when the service was found through version detection.
+func (s Service) Probed() bool {
+	return s.Method == "probed"
+}
+
+// Differs returns true when the detected name, product, version or extra info differ between the two services.
+func (s Service) Differs(other Service) bool {
+	return s.Name != other.Name || s.Product != other.Product || s.Version != other.Version || s.ExtraInfo != other.ExtraInfo
+}
+
+// String formats the service as "name product version (extra info)", leaving out anything nmap did not detect.
+func (s Service) String() string {
+	var parts []string
+	for _, part := range []string{s.Name, s.Product, s.Version} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if s.ExtraInfo != "" {
+		parts = append(parts, "("+s.ExtraInfo+")")
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, " ")
+}
+
+// ServiceChange records a port that stayed open while the service detected on it changed.
+type ServiceChange struct {
+	Port     Port    `json:"port"`
+	Previous Service `json:"previous"`
+	Current  Service `json:"current"`
+}
+
+// StateTransition records a port that moved from one nmap state to another, e.g. filtered to open.
+type StateTransition struct {
+	Port     Port   `json:"port"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// String formats the transition as "previous->current", which is also the form matched by notification filters.
+func (t StateTransition) String() string {
+	return t.Previous + "->" + t.Current
+}
+
+// Matches returns true when the transition matches a "previous->current" pattern. Either side of the pattern may be
+// "*" to match any state.
+func (t StateTransition) Matches(pattern string) bool {
+	parts := strings.SplitN(pattern, "->", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	previous, current := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	return (previous == "*" || previous == t.Previous) && (current == "*" || current == t.Current)
+}
+
+// AddressChange records a resource that was found at another address on the previous scan.
+type AddressChange struct {
+	Identity string
+	Previous string
+	Current  string
+}
+
+// Reassignment records an address that belonged to another resource on the previous scan, such as a recycled
+// elastic IP.
+type Reassignment struct {
+	Address  string
+	Previous string
+	Current  string
 }
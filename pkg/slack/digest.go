@@ -0,0 +1,317 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	postMessageUrl = "https://slack.com/api/chat.postMessage"
+
+	// Slack rejects messages with more than 50 blocks and section texts longer than 3000 characters.
+	maxBlocksPerMessage = 50
+	maxSectionLength    = 3000
+
+	groupByChange    = "change"
+	groupByProvider  = "provider"
+	groupByTagPrefix = "tag:"
+)
+
+// digestLine is a single change of a host, along with the group it is listed under.
+type digestLine struct {
+	group string
+	text  string
+}
+
+type postMessageResponse struct {
+	Ok    bool   `json:"ok"`
+	Ts    string `json:"ts"`
+	Error string `json:"error"`
+}
+
+func validateGroupBy(groupBy string) error {
+	if groupBy == "" || groupBy == groupByChange || groupBy == groupByProvider {
+		return nil
+	}
+	if strings.HasPrefix(groupBy, groupByTagPrefix) && len(groupBy) > len(groupByTagPrefix) {
+		return nil
+	}
+	return fmt.Errorf("validateGroupBy: unknown group %s, expected change, provider or tag:<key>", groupBy)
+}
+
+// notifyDigest posts every change in the report as a single message. Digests with more blocks than Slack allows are
+// split into follow-up messages, which are threaded under the first one when posting through the Web API.
+func (s *slack) notifyDigest(report wrapper.Report) error {
+	lines := s.digestLines(report)
+	if len(lines) == 0 && len(report.InventoryErrors) == 0 {
+		log.Debug("notifyDigest: No changes to post")
+		return nil
+	}
+
+	summary := s.digestSummary(report)
+	blocks := []block{
+		{BlockType: "header", BlockText: &markdownText{Type: "plain_text", Text: "nmap-diff scan changes"}},
+		{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: summary}},
+	}
+	if len(report.InventoryErrors) > 0 {
+		blocks = append(blocks,
+			block{BlockType: "divider"},
+			block{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: "*:warning: Inventory Errors*"}},
+			block{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: formatInventoryErrors(report.InventoryErrors)}},
+		)
+	}
+	if len(report.OutsideInventory) > 0 {
+		blocks = append(blocks,
+			block{BlockType: "divider"},
+			block{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: "*:mag: Outside of the Inventory*"}},
+			block{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: s.formatOutsideInventory(report.OutsideInventory)}},
+		)
+	}
+	messages := splitBlocks(append(blocks, groupBlocks(lines)...))
+
+	threadTs := ""
+	for index, blocks := range messages {
+		body := slackBody{Text: summary, Blocks: blocks}
+		if len(messages) > 1 {
+			// Every part is marked, since incoming webhooks cannot thread the follow up messages under the first one.
+			part := "nmap-diff scan changes (part " + strconv.Itoa(index+1) + "/" + strconv.Itoa(len(messages)) + ")"
+			header := block{BlockType: "header", BlockText: &markdownText{Type: "plain_text", Text: part}}
+			if index == 0 {
+				body.Blocks = append([]block{header}, blocks[1:]...)
+			} else {
+				body.Text = part
+				body.Blocks = append([]block{header}, blocks...)
+			}
+		}
+
+		if s.token == "" {
+			err := s.postWebhook(body)
+			if err != nil {
+				return fmt.Errorf("notifyDigest: Error posting digest %s", err)
+			}
+			continue
+		}
+
+		body.Channel = s.channel
+		body.ThreadTs = threadTs
+		ts, err := s.postMessage(body)
+		if err != nil {
+			return fmt.Errorf("notifyDigest: Error posting digest %s", err)
+		}
+		if index == 0 {
+			threadTs = ts
+		}
+	}
+	return nil
+}
+
+// postMessage posts the body through the chat.postMessage Web API method and returns the timestamp of the message.
+func (s *slack) postMessage(body slackBody) (string, error) {
+	data, _ := json.Marshal(body)
+
+	log.Debug(string(data))
+
+	req, _ := http.NewRequest("POST", s.apiUrl, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := s.rateLimit.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("postMessage: Error Posting Request %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Status != "200 OK" {
+		return "", fmt.Errorf("postMessage: Received non 200 Status Code %s", resp.Status)
+	}
+
+	var response postMessageResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return "", fmt.Errorf("postMessage: Error decoding response %s", err)
+	}
+	if !response.Ok {
+		return "", fmt.Errorf("postMessage: Slack returned error %s", response.Error)
+	}
+	return response.Ts, nil
+}
+
+func (s *slack) digestSummary(report wrapper.Report) string {
+	counts := []struct {
+		label string
+		count int
+	}{
+		{"hosts with changed addresses", len(report.AddressChanges())},
+		{"reassigned addresses", len(report.ReassignedAddresses())},
+		{"new hosts", len(report.NewHosts())},
+		{"hosts with opened ports", len(report.OpenedPorts())},
+		{"hosts with closed ports", len(report.ClosedPorts())},
+		{"hosts with changed services", len(report.ChangedServices())},
+		{"hosts with state transitions", len(report.StateTransitions())},
+		{"removed hosts", len(report.RemovedHosts())},
+		{"scopes that could not be inventoried", len(report.InventoryErrors)},
+		{"hosts outside of the inventory", len(report.OutsideInventory)},
+	}
+
+	var parts []string
+	for _, c := range counts {
+		if c.count > 0 {
+			parts = append(parts, "*"+strconv.Itoa(c.count)+"* "+c.label)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// digestLines returns one line per host and change type, sorted by group. Lines keep the order of the report within
+// a group.
+func (s *slack) digestLines(report wrapper.Report) []digestLine {
+	var lines []digestLine
+
+	add := func(change string, hosts []server.Server, format func(server.Server) string) {
+		for _, host := range hosts {
+			lines = append(lines, digestLine{group: s.group(change, host), text: format(host)})
+		}
+	}
+
+	add("Address Changes", report.AddressChanges(), func(host server.Server) string {
+		return ":twisted_rightwards_arrows: " + s.digestHost(host) + " moved from `" + host.PreviousAddress + "`"
+	})
+	add("Reassigned Addresses", report.ReassignedAddresses(), func(host server.Server) string {
+		return ":recycle: " + s.digestHost(host) + " reassigned from `" + host.PreviousIdentity + "` to `" +
+			host.Identity() + "`"
+	})
+	add("New Hosts", report.NewHosts(), func(host server.Server) string {
+		return ":new: " + s.digestHost(host) + " new host with " + s.formatPorts(host.OpenedPorts)
+	})
+	add("Opened Ports", report.OpenedPorts(), func(host server.Server) string {
+		return ":large_green_circle: " + s.digestHost(host) + " opened " + s.formatPorts(host.OpenedPorts)
+	})
+	add("Closed Ports", report.ClosedPorts(), func(host server.Server) string {
+		return ":red_circle: " + s.digestHost(host) + " closed " + s.formatPorts(host.ClosedPorts)
+	})
+	add("Changed Services", report.ChangedServices(), func(host server.Server) string {
+		var changes []string
+		for _, change := range host.ChangedServices {
+			changes = append(changes, "`"+change.Port.String()+"` "+change.Previous.String()+" :arrow_right: "+change.Current.String())
+		}
+		return ":large_yellow_circle: " + s.digestHost(host) + " changed " + strings.Join(changes, ", ")
+	})
+	add("State Transitions", report.StateTransitions(), func(host server.Server) string {
+		var transitions []string
+		for _, transition := range host.StateTransitions {
+			transitions = append(transitions, "`"+transition.Port.String()+"` "+transition.Previous+" :arrow_right: "+transition.Current)
+		}
+		return ":large_orange_circle: " + s.digestHost(host) + " " + strings.Join(transitions, ", ")
+	})
+	add("Removed Hosts", report.RemovedHosts(), func(host server.Server) string {
+		return ":wastebasket: " + s.digestHost(host) + " removed with " + s.formatPorts(host.ClosedPorts)
+	})
+
+	// Change groups are already listed in the same order as the per port messages.
+	if s.groupBy != "" && s.groupBy != groupByChange {
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].group < lines[j].group })
+	}
+	return lines
+}
+
+func (s *slack) digestHost(host server.Server) string {
+	if host.Name == host.Address {
+		return "`" + host.Address + "`"
+	}
+	return "`" + host.Name + "` (" + host.Address + ")"
+}
+
+// group returns the name of the group the change of the host is listed under.
+func (s *slack) group(change string, host server.Server) string {
+	switch {
+	case s.groupBy == groupByProvider:
+		if host.Provider == "" {
+			return "unknown provider"
+		}
+		return host.Provider
+	case strings.HasPrefix(s.groupBy, groupByTagPrefix):
+		key := strings.TrimPrefix(s.groupBy, groupByTagPrefix)
+		if value, ok := host.Tags[key]; ok && value != "" {
+			return key + ": " + value
+		}
+		return "no " + key + " tag"
+	default:
+		return change
+	}
+}
+
+// groupBlocks turns the lines into a header section per group followed by sections holding the lines of the group.
+// Lines are packed into as few sections as the section length limit allows.
+func groupBlocks(lines []digestLine) []block {
+	var blocks []block
+	currentGroup := ""
+	var text strings.Builder
+	// textLength counts the characters of text, which is what the section length limit applies to.
+	textLength := 0
+
+	flush := func() {
+		if text.Len() > 0 {
+			blocks = append(blocks, block{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: text.String()}})
+			text.Reset()
+			textLength = 0
+		}
+	}
+
+	for index, line := range lines {
+		if index == 0 || line.group != currentGroup {
+			flush()
+			currentGroup = line.group
+			blocks = append(blocks, block{BlockType: "divider"})
+			blocks = append(blocks, block{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: "*" + line.group + "*"}})
+		}
+
+		lineText := truncate(line.text, maxSectionLength)
+		lineLength := utf8.RuneCountInString(lineText)
+		if textLength+lineLength+1 > maxSectionLength {
+			flush()
+		}
+		if text.Len() > 0 {
+			text.WriteString("\n")
+			textLength++
+		}
+		text.WriteString(lineText)
+		textLength += lineLength
+	}
+	flush()
+	return blocks
+}
+
+// splitBlocks splits the blocks into messages that stay under the block limit. The first message starts with the
+// header of the digest, and room is left at the start of every follow up message for a header marking the part.
+func splitBlocks(blocks []block) [][]block {
+	if len(blocks) <= maxBlocksPerMessage {
+		return [][]block{blocks}
+	}
+
+	messages := [][]block{blocks[:maxBlocksPerMessage]}
+	blocks = blocks[maxBlocksPerMessage:]
+	for len(blocks) > maxBlocksPerMessage-1 {
+		messages = append(messages, blocks[:maxBlocksPerMessage-1])
+		blocks = blocks[maxBlocksPerMessage-1:]
+	}
+	return append(messages, blocks)
+}
+
+// truncate cuts the text to at most maxLength characters, ending it with an ellipsis. Slack limits count characters
+// rather than bytes, and text cut in the middle of a multi-byte character is rejected.
+func truncate(text string, maxLength int) string {
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:maxLength-3]) + "..."
+}
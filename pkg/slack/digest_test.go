@@ -0,0 +1,264 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func digestReport(hostCount int) wrapper.Report {
+	diff := wrapper.NewScanDiff()
+	servers := make(map[string]server.Server)
+	for i := 0; i < hostCount; i++ {
+		address := "10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)
+		diff.OpenedPorts[address] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen}}
+		provider := "aws"
+		if i%2 == 1 {
+			provider = "gcloud"
+		}
+		servers[address] = server.Server{Name: "instance-" + strconv.Itoa(i), Address: address, Provider: provider,
+			Tags: map[string]string{"team": "team-" + strconv.Itoa(i%3)}}
+	}
+	diff.RemovedHosts["3.3.3.3"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 22}: {State: server.PortOpen}}
+	return wrapper.Report{Servers: servers, Diff: diff}
+}
+
+func TestNotifyDigest(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	slackInterface := slack{digest: true}
+	slackInterface.rateLimit = &rateLimitedHTTPClient{
+		client:   http.DefaultClient,
+		rlClient: rate.NewLimiter(rate.Inf, 0),
+	}
+
+	var bodies []slackBody
+
+	testCases := []struct {
+		desc          string
+		report        wrapper.Report
+		token         string
+		setup         func() *httptest.Server
+		expectedPosts int
+		shouldError   bool
+	}{
+		{
+			desc:   "Posts a single webhook message for a small report",
+			report: digestReport(3),
+			setup: func() *httptest.Server {
+				bodies = nil
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var body slackBody
+					json.NewDecoder(r.Body).Decode(&body)
+					bodies = append(bodies, body)
+				}))
+			},
+			expectedPosts: 1,
+			shouldError:   false,
+		},
+		{
+			desc:   "Posts nothing for an empty report",
+			report: wrapper.Report{Diff: wrapper.NewScanDiff()},
+			setup: func() *httptest.Server {
+				bodies = nil
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					bodies = append(bodies, slackBody{})
+				}))
+			},
+			expectedPosts: 0,
+			shouldError:   false,
+		},
+		{
+			desc:   "Threads follow up messages under the first message when using a token",
+			report: digestReport(2000),
+			token:  "xoxb-token",
+			setup: func() *httptest.Server {
+				bodies = nil
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var body slackBody
+					json.NewDecoder(r.Body).Decode(&body)
+					bodies = append(bodies, body)
+					w.Write([]byte(`{"ok": true, "ts": "1600000000.000100"}`))
+				}))
+			},
+			expectedPosts: -1,
+			shouldError:   false,
+		},
+		{
+			desc:   "Marks each part of a split digest when using a webhook",
+			report: digestReport(2000),
+			setup: func() *httptest.Server {
+				bodies = nil
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var body slackBody
+					json.NewDecoder(r.Body).Decode(&body)
+					bodies = append(bodies, body)
+				}))
+			},
+			expectedPosts: -1,
+			shouldError:   false,
+		},
+		{
+			desc:   "Error if the Web API returns an error",
+			report: digestReport(3),
+			token:  "xoxb-token",
+			setup: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+				}))
+			},
+			shouldError: true,
+		},
+		{
+			desc:   "Error posting to slack",
+			report: digestReport(3),
+			setup: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(500)
+				}))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testServer := testCase.setup()
+		slackInterface.slackUrl = testServer.URL
+		slackInterface.apiUrl = testServer.URL
+		slackInterface.token = testCase.token
+		slackInterface.channel = "#security"
+
+		err := slackInterface.Notify(testCase.report)
+
+		testServer.Close()
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+
+		if testCase.expectedPosts >= 0 {
+			assert.Equal(t, testCase.expectedPosts, len(bodies))
+			continue
+		}
+
+		assert.True(t, len(bodies) > 1)
+		assert.Equal(t, "", bodies[0].ThreadTs)
+		for index, body := range bodies {
+			part := "nmap-diff scan changes (part " + strconv.Itoa(index+1) + "/" + strconv.Itoa(len(bodies)) + ")"
+			assert.Equal(t, "header", body.Blocks[0].BlockType)
+			assert.Equal(t, part, body.Blocks[0].BlockText.Text)
+			assert.True(t, len(body.Blocks) <= maxBlocksPerMessage)
+			for _, b := range body.Blocks {
+				if b.BlockText != nil {
+					assert.True(t, len(b.BlockText.Text) <= maxSectionLength)
+				}
+			}
+		}
+		if testCase.token == "" {
+			continue
+		}
+		for _, body := range bodies {
+			assert.Equal(t, "#security", body.Channel)
+		}
+		for _, body := range bodies[1:] {
+			assert.Equal(t, "1600000000.000100", body.ThreadTs)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		text     string
+		expected string
+	}{
+		{desc: "Keeps text within the limit", text: "héllo", expected: "héllo"},
+		{desc: "Counts characters rather than bytes", text: "éééééééééé", expected: "éééééééééé"},
+		{desc: "Cuts on a character boundary", text: "ééééééééééé", expected: "ééééééé..."},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc": testCase.desc,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		truncated := truncate(testCase.text, 10)
+		assert.Equal(t, testCase.expected, truncated)
+		assert.True(t, utf8.ValidString(truncated))
+	}
+}
+
+func TestDigestGroups(t *testing.T) {
+	report := digestReport(4)
+
+	testCases := []struct {
+		groupBy  string
+		expected []string
+	}{
+		{groupBy: "", expected: []string{"Opened Ports", "Removed Hosts"}},
+		{groupBy: "provider", expected: []string{"aws", "gcloud", "unknown provider"}},
+		{groupBy: "tag:team", expected: []string{"no team tag", "team: team-0", "team: team-1", "team: team-2"}},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"groupBy": testCase.groupBy,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		slackInterface := slack{groupBy: testCase.groupBy}
+
+		var groups []string
+		for _, line := range slackInterface.digestLines(report) {
+			if len(groups) == 0 || groups[len(groups)-1] != line.group {
+				groups = append(groups, line.group)
+			}
+		}
+		assert.Equal(t, testCase.expected, groups)
+	}
+}
+
+func TestNewDigestConfig(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		config      config.SlackConfig
+		shouldError bool
+	}{
+		{desc: "Webhook digest", config: config.SlackConfig{SlackURL: "https://hooks.slack.com/x", Digest: true}},
+		{desc: "Web API digest without a webhook", config: config.SlackConfig{Digest: true, Token: "xoxb", Channel: "#security"}},
+		{desc: "Error if a token is set without a channel", config: config.SlackConfig{Digest: true, Token: "xoxb"}, shouldError: true},
+		{desc: "Error if the token is used without digest mode", config: config.SlackConfig{Token: "xoxb", Channel: "#security"}, shouldError: true},
+		{desc: "Error for an unknown group", config: config.SlackConfig{SlackURL: "https://hooks.slack.com/x", GroupBy: "region"}, shouldError: true},
+		{desc: "Error for a tag group without a key", config: config.SlackConfig{SlackURL: "https://hooks.slack.com/x", GroupBy: "tag:"}, shouldError: true},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		_, err := New(&testCase.config)
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
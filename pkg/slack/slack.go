@@ -7,15 +7,25 @@ import (
 	"fmt"
 	"github.com/Invoca/nmap-diff/pkg/config"
 	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type SlackInterface interface {
-	PrintOpenedPorts(host server.Server, ports []uint16) error
+	Notify(report wrapper.Report) error
+	PrintOpenedPorts(host server.Server) error
+	PrintClosedPorts(host server.Server) error
+	PrintNewHost(host server.Server) error
+	PrintRemovedHost(host server.Server) error
+	PrintChangedServices(host server.Server) error
+	PrintStateTransitions(host server.Server) error
+	PrintAddressChange(host server.Server) error
+	PrintReassignedAddress(host server.Server) error
 }
 
 type markdownText struct {
@@ -29,12 +39,20 @@ type block struct {
 }
 
 type slackBody struct {
-	Blocks []block `json:"blocks"`
+	Channel  string  `json:"channel,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	ThreadTs string  `json:"thread_ts,omitempty"`
+	Blocks   []block `json:"blocks"`
 }
 
 type slack struct {
 	slackUrl  string
 	rateLimit *rateLimitedHTTPClient
+	digest    bool
+	groupBy   string
+	token     string
+	channel   string
+	apiUrl    string
 }
 
 type rateLimitedHTTPClient struct {
@@ -55,17 +73,31 @@ func (c *rateLimitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
-func New(config config.BaseConfig) (*slack, error) {
-	if config.SlackConfig == nil {
+func New(slackConfig *config.SlackConfig) (*slack, error) {
+	if slackConfig == nil {
 		return nil, fmt.Errorf("Error: SlackConfig cannot be nil")
 	}
 
-	if config.SlackConfig.SlackURL == "" {
+	if slackConfig.Token != "" && slackConfig.Channel == "" {
+		return nil, fmt.Errorf("Error: Channel cannot be empty when Token is set")
+	}
+
+	if slackConfig.SlackURL == "" && !(slackConfig.Digest && slackConfig.Token != "") {
 		return nil, fmt.Errorf("Error: SlackURL cannot be empty")
 	}
 
+	err := validateGroupBy(slackConfig.GroupBy)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %s", err)
+	}
+
 	s := slack{}
-	s.slackUrl = config.SlackConfig.SlackURL
+	s.slackUrl = slackConfig.SlackURL
+	s.digest = slackConfig.Digest
+	s.groupBy = slackConfig.GroupBy
+	s.token = slackConfig.Token
+	s.channel = slackConfig.Channel
+	s.apiUrl = postMessageUrl
 	s.rateLimit = &rateLimitedHTTPClient{
 		client:   http.DefaultClient,
 		rlClient: rate.NewLimiter(rate.Every(10*time.Second), 10),
@@ -103,8 +135,15 @@ func (s *slack) createBlockSlackPost(text string, additionalText string) error {
 
 	blockSlice = append(blockSlice, divider, mainBlock, additionalInfoBlock)
 
-	body := slackBody{Blocks: blockSlice}
+	err := s.postWebhook(slackBody{Blocks: blockSlice})
+	if err != nil {
+		return fmt.Errorf("createBlockSlackPost: %s", err)
+	}
+	return nil
+}
 
+// postWebhook posts the body to the incoming webhook at slackUrl.
+func (s *slack) postWebhook(body slackBody) error {
 	data, _ := json.Marshal(body)
 
 	log.Debug(string(data))
@@ -113,11 +152,12 @@ func (s *slack) createBlockSlackPost(text string, additionalText string) error {
 	resp, err := s.rateLimit.Do(req)
 
 	if err != nil {
-		return fmt.Errorf("createBlockSlackPost: Error Posting Request %s", err)
+		return fmt.Errorf("postWebhook: Error Posting Request %s", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.Status != "200 OK" {
-		return fmt.Errorf("createBlockSlackPost: Received non 200 Status Code %s", err)
+		return fmt.Errorf("postWebhook: Received non 200 Status Code %s", resp.Status)
 	}
 	log.Debug("Received Status: " + resp.Status)
 	return nil
@@ -138,21 +178,264 @@ func (s *slack) formatLabels(labels map[string]string) string {
 	return baseString
 }
 
-//TODO: Refactor usage of server struct to be able to use ports field
-func (s *slack) PrintOpenedPorts(host server.Server, ports []uint16) error {
+// Notify posts every change found in the report, either as a single digest or as one message per port or host.
+func (s *slack) Notify(report wrapper.Report) error {
+	if s.digest && !report.Baseline {
+		return s.notifyDigest(report)
+	}
+
+	if len(report.InventoryErrors) > 0 {
+		err := s.notifyInventoryErrors(report.InventoryErrors)
+		if err != nil {
+			return fmt.Errorf("Notify: %s", err)
+		}
+	}
+
+	// Hosts outside of the inventory are listed along with whatever else is posted, rather than on every run.
+	if len(report.OutsideInventory) > 0 && !report.Empty() {
+		err := s.notifyOutsideInventory(report.OutsideInventory)
+		if err != nil {
+			return fmt.Errorf("Notify: %s", err)
+		}
+	}
+
+	if report.Baseline {
+		return s.notifyBaseline(report)
+	}
+
+	printers := []struct {
+		hosts []server.Server
+		print func(server.Server) error
+	}{
+		{report.AddressChanges(), s.PrintAddressChange},
+		{report.ReassignedAddresses(), s.PrintReassignedAddress},
+		{report.NewHosts(), s.PrintNewHost},
+		{report.OpenedPorts(), s.PrintOpenedPorts},
+		{report.ClosedPorts(), s.PrintClosedPorts},
+		{report.ChangedServices(), s.PrintChangedServices},
+		{report.StateTransitions(), s.PrintStateTransitions},
+		{report.RemovedHosts(), s.PrintRemovedHost},
+	}
+
+	for _, printer := range printers {
+		for _, host := range printer.hosts {
+			err := printer.print(host)
+			if err != nil {
+				return fmt.Errorf("Notify: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// notifyBaseline posts a single message summarizing the scan a baseline was established with.
+func (s *slack) notifyBaseline(report wrapper.Report) error {
+	title := ":white_check_mark: *" + report.BaselineSummary() + "*"
+	details := "No previous scan was found. Changes will be posted from the next run on."
+
+	err := s.postSummary(report.BaselineSummary(), title, details)
+	if err != nil {
+		return fmt.Errorf("notifyBaseline: Error posting message to slack %s", err)
+	}
+	return nil
+}
+
+// notifyInventoryErrors posts a single message listing the scopes that could not be inventoried.
+func (s *slack) notifyInventoryErrors(inventoryErrors []wrapper.InventoryError) error {
+	summary := "Inventory incomplete, " + strconv.Itoa(len(inventoryErrors)) + " scopes were not scanned"
+	title := ":warning: *" + summary + "*"
+
+	err := s.postSummary(summary, title, formatInventoryErrors(inventoryErrors))
+	if err != nil {
+		return fmt.Errorf("notifyInventoryErrors: Error posting message to slack %s", err)
+	}
+	return nil
+}
+
+// notifyOutsideInventory posts a single message listing the hosts DNS records point at that no provider inventoried.
+func (s *slack) notifyOutsideInventory(hosts []server.Server) error {
+	summary := strconv.Itoa(len(hosts)) + " hosts are outside of the inventory"
+	title := ":mag: *" + summary + "*"
+
+	err := s.postSummary(summary, title, s.formatOutsideInventory(hosts))
+	if err != nil {
+		return fmt.Errorf("notifyOutsideInventory: Error posting message to slack %s", err)
+	}
+	return nil
+}
+
+// postSummary posts a message made of a title and details, through the webhook when one is set and through the Web
+// API otherwise.
+func (s *slack) postSummary(text string, title string, details string) error {
+	if s.slackUrl != "" {
+		return s.createBlockSlackPost(title, details)
+	}
+
+	_, err := s.postMessage(slackBody{
+		Channel: s.channel,
+		Text:    text,
+		Blocks: []block{
+			{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: title}},
+			{BlockType: "section", BlockText: &markdownText{Type: "mrkdwn", Text: details}},
+		},
+	})
+	return err
+}
+
+// formatInventoryErrors returns a line per scope that could not be inventoried, cut to fit in a single section.
+func formatInventoryErrors(inventoryErrors []wrapper.InventoryError) string {
+	lines := make([]string, len(inventoryErrors))
+	for index, inventoryError := range inventoryErrors {
+		lines[index] = "`" + inventoryError.Provider + " " + inventoryError.Scope + "` " + inventoryError.Message
+	}
+
+	return truncate(strings.Join(lines, "\n"), maxSectionLength)
+}
+
+// formatOutsideInventory returns a line per host outside of the inventory, cut to fit in a single section.
+func (s *slack) formatOutsideInventory(hosts []server.Server) string {
+	lines := make([]string, len(hosts))
+	for index, host := range hosts {
+		lines[index] = s.digestHost(host)
+	}
+
+	return truncate(strings.Join(lines, "\n"), maxSectionLength)
+}
+
+func (s *slack) hostDetails(host server.Server) string {
+	return "*Address*: " + host.Address + "\n" + s.formatLabels(host.Tags)
+}
+
+func (s *slack) formatPorts(ports []server.Port) string {
+	portStrings := make([]string, len(ports))
+	for i, port := range ports {
+		portStrings[i] = "`" + port.String() + "`"
+	}
+	return strings.Join(portStrings, ", ")
+}
+
+// PrintOpenedPorts posts a message for every port in host.OpenedPorts.
+func (s *slack) PrintOpenedPorts(host server.Server) error {
 	if s.slackUrl == "" {
 		return fmt.Errorf("PrintOpenedPorts: slackUrl cannot be empty")
 	}
-	for _, port := range ports {
-		title := ":large_green_circle: *Host* `" + host.Name + "` _Opened_ *Port* `" + strconv.FormatUint(uint64(port), 10) + "`"
-
-		attachmentText := "*Address*: " + host.Address + "\n"
-		attachmentText = attachmentText + s.formatLabels(host.Tags)
+	for _, port := range host.OpenedPorts {
+		title := ":large_green_circle: *Host* `" + host.Name + "` _Opened_ *Port* `" + port.String() + "`"
 
-		err := s.createBlockSlackPost(title, attachmentText)
+		err := s.createBlockSlackPost(title, s.hostDetails(host))
 		if err != nil {
 			return fmt.Errorf("PrintOpenedPorts: Error posting message to slack %s", err)
 		}
 	}
 	return nil
 }
+
+// PrintClosedPorts posts a message for every port in host.ClosedPorts.
+func (s *slack) PrintClosedPorts(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintClosedPorts: slackUrl cannot be empty")
+	}
+	for _, port := range host.ClosedPorts {
+		title := ":red_circle: *Host* `" + host.Name + "` _Closed_ *Port* `" + port.String() + "`"
+
+		err := s.createBlockSlackPost(title, s.hostDetails(host))
+		if err != nil {
+			return fmt.Errorf("PrintClosedPorts: Error posting message to slack %s", err)
+		}
+	}
+	return nil
+}
+
+// PrintNewHost posts a single message for a host that was not found on the previous scan, listing host.OpenedPorts.
+func (s *slack) PrintNewHost(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintNewHost: slackUrl cannot be empty")
+	}
+	title := ":new: *New Host* `" + host.Name + "` _Opened_ *Ports* " + s.formatPorts(host.OpenedPorts)
+
+	err := s.createBlockSlackPost(title, s.hostDetails(host))
+	if err != nil {
+		return fmt.Errorf("PrintNewHost: Error posting message to slack %s", err)
+	}
+	return nil
+}
+
+// PrintRemovedHost posts a single message for a host that is no longer found on the current scan, listing
+// host.ClosedPorts.
+func (s *slack) PrintRemovedHost(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintRemovedHost: slackUrl cannot be empty")
+	}
+	title := ":wastebasket: *Removed Host* `" + host.Name + "` _Closed_ *Ports* " + s.formatPorts(host.ClosedPorts)
+
+	err := s.createBlockSlackPost(title, s.hostDetails(host))
+	if err != nil {
+		return fmt.Errorf("PrintRemovedHost: Error posting message to slack %s", err)
+	}
+	return nil
+}
+
+// PrintChangedServices posts a message for every port in host.ChangedServices.
+func (s *slack) PrintChangedServices(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintChangedServices: slackUrl cannot be empty")
+	}
+	for _, change := range host.ChangedServices {
+		title := ":large_yellow_circle: *Host* `" + host.Name + "` _Changed Service_ on *Port* `" + change.Port.String() + "`"
+		serviceText := "*Previous*: " + change.Previous.String() + "\n*Current*: " + change.Current.String() + "\n"
+
+		err := s.createBlockSlackPost(title, serviceText+s.hostDetails(host))
+		if err != nil {
+			return fmt.Errorf("PrintChangedServices: Error posting message to slack %s", err)
+		}
+	}
+	return nil
+}
+
+// PrintStateTransitions posts a message for every port in host.StateTransitions.
+func (s *slack) PrintStateTransitions(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintStateTransitions: slackUrl cannot be empty")
+	}
+	for _, transition := range host.StateTransitions {
+		title := ":large_orange_circle: *Host* `" + host.Name + "` *Port* `" + transition.Port.String() + "` _" +
+			transition.Previous + "_ :arrow_right: _" + transition.Current + "_"
+
+		err := s.createBlockSlackPost(title, s.hostDetails(host))
+		if err != nil {
+			return fmt.Errorf("PrintStateTransitions: Error posting message to slack %s", err)
+		}
+	}
+	return nil
+}
+
+// PrintAddressChange posts a single message for a host that was found at host.PreviousAddress on the previous scan.
+func (s *slack) PrintAddressChange(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintAddressChange: slackUrl cannot be empty")
+	}
+	title := ":twisted_rightwards_arrows: *Host* `" + host.Name + "` _Moved_ from `" + host.PreviousAddress +
+		"` :arrow_right: `" + host.Address + "`"
+
+	err := s.createBlockSlackPost(title, s.hostDetails(host))
+	if err != nil {
+		return fmt.Errorf("PrintAddressChange: Error posting message to slack %s", err)
+	}
+	return nil
+}
+
+// PrintReassignedAddress posts a single message for an address that belonged to host.PreviousIdentity on the previous
+// scan.
+func (s *slack) PrintReassignedAddress(host server.Server) error {
+	if s.slackUrl == "" {
+		return fmt.Errorf("PrintReassignedAddress: slackUrl cannot be empty")
+	}
+	title := ":recycle: *Address* `" + host.Address + "` _Reassigned_ from `" + host.PreviousIdentity +
+		"` :arrow_right: `" + host.Identity() + "`"
+
+	err := s.createBlockSlackPost(title, s.hostDetails(host))
+	if err != nil {
+		return fmt.Errorf("PrintReassignedAddress: Error posting message to slack %s", err)
+	}
+	return nil
+}
@@ -1,15 +1,17 @@
 package slack
 
 import (
+	"encoding/json"
 	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/time/rate"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
-	"time"
 )
 
 type slackTestCase struct {
@@ -24,16 +26,28 @@ func TestPrintOpenedPorts(t *testing.T) {
 	slackInterface := slack{}
 	slackInterface.rateLimit = &rateLimitedHTTPClient{
 		client:   http.DefaultClient,
-		rlClient: rate.NewLimiter(rate.Every(10*time.Second), 10),
+		rlClient: rate.NewLimiter(rate.Inf, 0),
 	}
 
 	serverTag := make(map[string]string)
 	serverTag["tagName"] = "tagValue"
 
 	serverInterface := server.Server{
-		Name:    "Instance1",
-		Address: "1.1.1.1",
-		Tags:    serverTag,
+		Name:        "Instance1",
+		Address:     "1.1.1.1",
+		Tags:        serverTag,
+		OpenedPorts: []server.Port{{Protocol: "tcp", ID: 20}, {Protocol: "tcp", ID: 22}},
+		ClosedPorts: []server.Port{{Protocol: "udp", ID: 53}},
+		ChangedServices: []server.ServiceChange{
+			{
+				Port:     server.Port{Protocol: "tcp", ID: 443},
+				Previous: server.Service{Name: "https", Product: "nginx", Version: "1.18"},
+				Current:  server.Service{Name: "ssh", Product: "OpenSSH"},
+			},
+		},
+		StateTransitions: []server.StateTransition{
+			{Port: server.Port{Protocol: "udp", ID: 161}, Previous: "filtered", Current: "open|filtered"},
+		},
 	}
 
 	testCases := []slackTestCase{
@@ -64,7 +78,88 @@ func TestPrintOpenedPorts(t *testing.T) {
 		testServer := testCase.setup()
 		slackInterface.slackUrl = testServer.URL
 
-		err := slackInterface.PrintOpenedPorts(serverInterface, []uint16{20, 22})
+		printFuncs := []func(server.Server) error{
+			slackInterface.PrintOpenedPorts,
+			slackInterface.PrintClosedPorts,
+			slackInterface.PrintNewHost,
+			slackInterface.PrintRemovedHost,
+			slackInterface.PrintChangedServices,
+			slackInterface.PrintStateTransitions,
+			slackInterface.PrintAddressChange,
+			slackInterface.PrintReassignedAddress,
+		}
+
+		for _, printFunc := range printFuncs {
+			err := printFunc(serverInterface)
+			if testCase.shouldError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+
+		testServer.Close()
+	}
+}
+
+func TestNotify(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	slackInterface := slack{}
+	slackInterface.rateLimit = &rateLimitedHTTPClient{
+		client:   http.DefaultClient,
+		rlClient: rate.NewLimiter(rate.Inf, 0),
+	}
+
+	diff := wrapper.NewScanDiff()
+	diff.NewHosts["2.2.2.2"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 80}: {State: server.PortOpen}}
+	diff.OpenedPorts["1.1.1.1"] = wrapper.PortMap{
+		server.Port{Protocol: "tcp", ID: 443}:  {State: server.PortOpen},
+		server.Port{Protocol: "tcp", ID: 8443}: {State: server.PortOpen},
+	}
+	diff.RemovedHosts["3.3.3.3"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 22}: {State: server.PortOpen}}
+	report := wrapper.Report{
+		Servers: map[string]server.Server{
+			"1.1.1.1": {Name: "Instance1", Address: "1.1.1.1"},
+			"2.2.2.2": {Name: "Instance2", Address: "2.2.2.2"},
+		},
+		Diff: diff,
+	}
+
+	var postCount int
+
+	testCases := []slackTestCase{
+		{
+			desc: "Posts one message per opened port and one per new or removed host",
+			setup: func() *httptest.Server {
+				postCount = 0
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					postCount += 1
+				}))
+			},
+			shouldError: false,
+		},
+		{
+			desc: "Error posting to slack",
+			setup: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(500)
+				}))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testServer := testCase.setup()
+		slackInterface.slackUrl = testServer.URL
+
+		err := slackInterface.Notify(report)
 
 		testServer.Close()
 
@@ -72,6 +167,146 @@ func TestPrintOpenedPorts(t *testing.T) {
 			assert.Error(t, err)
 		} else {
 			assert.NoError(t, err)
+			assert.Equal(t, 4, postCount)
+		}
+	}
+}
+
+func TestNotifyBaseline(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	slackInterface := slack{digest: true}
+	slackInterface.rateLimit = &rateLimitedHTTPClient{
+		client:   http.DefaultClient,
+		rlClient: rate.NewLimiter(rate.Inf, 0),
+	}
+
+	diff := wrapper.NewScanDiff()
+	diff.NewHosts["2.2.2.2"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 80}: {State: server.PortOpen}}
+	diff.NewHosts["3.3.3.3"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 22}: {State: server.PortOpen}}
+
+	var bodies []slackBody
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body slackBody
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+	}))
+	defer testServer.Close()
+	slackInterface.slackUrl = testServer.URL
+
+	err := slackInterface.Notify(wrapper.Report{Diff: diff, Baseline: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(bodies))
+	assert.Contains(t, bodies[0].Blocks[1].BlockText.Text, "Baseline established with 2 hosts and 2 open ports")
+}
+
+func TestNotifyInventoryErrors(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	inventoryErrors := []wrapper.InventoryError{
+		{Provider: "aws", Scope: "123456789012/ap-east-1", Message: "AccessDenied"},
+		{Provider: "gcloud", Scope: "us-east1-b", Message: "Error 403"},
+	}
+	diff := wrapper.NewScanDiff()
+	diff.OpenedPorts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen}}
+
+	var bodies []slackBody
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body slackBody
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+	}))
+	defer testServer.Close()
+
+	for _, digest := range []bool{false, true} {
+		bodies = nil
+		slackInterface := slack{slackUrl: testServer.URL, digest: digest}
+		slackInterface.rateLimit = &rateLimitedHTTPClient{
+			client:   http.DefaultClient,
+			rlClient: rate.NewLimiter(rate.Inf, 0),
+		}
+
+		err := slackInterface.Notify(wrapper.Report{Diff: diff, InventoryErrors: inventoryErrors})
+		assert.NoError(t, err)
+
+		var texts []string
+		for _, body := range bodies {
+			for _, b := range body.Blocks {
+				if b.BlockText != nil {
+					texts = append(texts, b.BlockText.Text)
+				}
+			}
+		}
+		all := strings.Join(texts, "\n")
+
+		t.Logf("TestNotifyInventoryErrors: digest %t lists every scope along with the changes", digest)
+		assert.Contains(t, all, "`aws 123456789012/ap-east-1` AccessDenied\n`gcloud us-east1-b` Error 403")
+		assert.Contains(t, all, "443/tcp")
+		if digest {
+			assert.Equal(t, 1, len(bodies))
+			assert.Contains(t, bodies[0].Text, "*2* scopes that could not be inventoried")
+		} else {
+			assert.Equal(t, 2, len(bodies))
+			assert.Contains(t, bodies[0].Blocks[1].BlockText.Text, "Inventory incomplete, 2 scopes were not scanned")
+		}
+	}
+}
+
+func TestNotifyOutsideInventory(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	outsideInventory := []server.Server{
+		{Name: "shop.example.com", Address: "5.5.5.5", Provider: "dns"},
+		{Name: "vpn.example.com", Address: "6.6.6.6", Provider: "dns"},
+	}
+	diff := wrapper.NewScanDiff()
+	diff.OpenedPorts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen}}
+
+	var bodies []slackBody
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body slackBody
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+	}))
+	defer testServer.Close()
+
+	for _, digest := range []bool{false, true} {
+		slackInterface := slack{slackUrl: testServer.URL, digest: digest}
+		slackInterface.rateLimit = &rateLimitedHTTPClient{
+			client:   http.DefaultClient,
+			rlClient: rate.NewLimiter(rate.Inf, 0),
+		}
+
+		t.Logf("TestNotifyOutsideInventory: digest %t posts nothing when nothing else changed", digest)
+		bodies = nil
+		err := slackInterface.Notify(wrapper.Report{Diff: wrapper.NewScanDiff(), OutsideInventory: outsideInventory})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(bodies))
+
+		bodies = nil
+		err = slackInterface.Notify(wrapper.Report{Diff: diff, OutsideInventory: outsideInventory})
+		assert.NoError(t, err)
+
+		var texts []string
+		for _, body := range bodies {
+			for _, b := range body.Blocks {
+				if b.BlockText != nil {
+					texts = append(texts, b.BlockText.Text)
+				}
+			}
+		}
+		all := strings.Join(texts, "\n")
+
+		t.Logf("TestNotifyOutsideInventory: digest %t lists every host along with the changes", digest)
+		assert.Contains(t, all, "`shop.example.com` (5.5.5.5)\n`vpn.example.com` (6.6.6.6)")
+		assert.Contains(t, all, "443/tcp")
+		if digest {
+			assert.Equal(t, 1, len(bodies))
+			assert.Contains(t, bodies[0].Text, "*2* hosts outside of the inventory")
+		} else {
+			assert.Equal(t, 2, len(bodies))
+			assert.Contains(t, bodies[0].Blocks[1].BlockText.Text, "2 hosts are outside of the inventory")
 		}
 	}
 }
@@ -0,0 +1,268 @@
+package static
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileTag holds the path of the file the target is listed in.
+	FileTag = "targetsFile"
+	// CIDRTag holds the range an address was expanded from.
+	CIDRTag = "cidr"
+	// HostnameTag holds the hostname an address was resolved from.
+	HostnameTag = "hostname"
+
+	// maxCIDRHostBits bounds the number of addresses a single range can expand to, a /16 in IPv4 or a /112 in IPv6.
+	maxCIDRHostBits = 16
+)
+
+// Target is an entry of a targets file. Address is an IP address, a CIDR range or a hostname.
+type Target struct {
+	Name    string            `json:"name" yaml:"name"`
+	Address string            `json:"address" yaml:"address"`
+	Tags    map[string]string `json:"tags" yaml:"tags"`
+}
+
+type staticSvc struct {
+	files []string
+	// lookupHost resolves the hostnames listed as targets.
+	lookupHost func(host string) ([]string, error)
+}
+
+// New returns a service inventorying the targets listed in the configured files. The files are read on every
+// inventory so that changes are picked up by long running servers.
+func New(configObject config.BaseConfig) (*staticSvc, error) {
+	if configObject.StaticConfig == nil || len(configObject.StaticConfig.Files) == 0 {
+		return nil, fmt.Errorf("New: no targets file is configured")
+	}
+
+	for _, file := range configObject.StaticConfig.Files {
+		if _, err := fileFormat(file); err != nil {
+			return nil, fmt.Errorf("New: %s", err)
+		}
+	}
+
+	return &staticSvc{files: configObject.StaticConfig.Files, lookupHost: net.LookupHost}, nil
+}
+
+// Instances records every address of the targets of every file. Ranges are expanded into their addresses and
+// hostnames resolved, so that targets are keyed by address like those of the cloud providers. Addresses listed on
+// their own take precedence over those of a range, and addresses already inventoried by another provider are kept as
+// they are. Hostnames that could not be resolved are returned as
+// inventory.Errors after the other targets are recorded.
+func (s *staticSvc) Instances(serversMap map[string]server.Server) error {
+	var hostErrors inventory.Errors
+	for _, file := range s.files {
+		targets, err := LoadTargets(file)
+		if err != nil {
+			return fmt.Errorf("Instances: %s", err)
+		}
+
+		for _, target := range targets {
+			var hostError *inventory.TaskError
+			err = s.addTarget(file, target, serversMap)
+			if errors.As(err, &hostError) {
+				hostErrors = append(hostErrors, hostError)
+			} else if err != nil {
+				return fmt.Errorf("Instances: Error in %s %s", file, err)
+			}
+		}
+	}
+
+	if len(hostErrors) > 0 {
+		return fmt.Errorf("Instances: Error resolving targets %w", hostErrors)
+	}
+	return nil
+}
+
+func (s *staticSvc) addTarget(file string, target *Target, serversMap map[string]server.Server) error {
+	if ip := net.ParseIP(target.Address); ip != nil {
+		addServer(serversMap, newServer(file, target, ip.String(), nil))
+		return nil
+	}
+
+	if _, network, err := net.ParseCIDR(target.Address); err == nil {
+		addresses, err := expandCIDR(network)
+		if err != nil {
+			return err
+		}
+		for _, address := range addresses {
+			if _, ok := serversMap[address]; !ok {
+				serversMap[address] = newServer(file, target, address, map[string]string{CIDRTag: network.String()})
+			}
+		}
+		return nil
+	}
+
+	addresses, err := s.lookupHost(target.Address)
+	if err != nil {
+		return &inventory.TaskError{Task: target.Address, Err: err}
+	}
+	// The hostname is the scope of the addresses it resolves to, since it is reported when it cannot be resolved.
+	for _, address := range addresses {
+		resolved := newServer(file, target, address, map[string]string{HostnameTag: target.Address})
+		resolved.Scopes = []string{target.Address}
+		addServer(serversMap, resolved)
+	}
+	return nil
+}
+
+// addServer records the server unless its address is already inventoried by another provider, whose entry carries
+// the identity of the resource behind the address.
+func addServer(serversMap map[string]server.Server, newServer server.Server) {
+	if existing, ok := serversMap[newServer.Address]; ok && existing.Provider != newServer.Provider {
+		return
+	}
+	serversMap[newServer.Address] = newServer
+}
+
+func newServer(file string, target *Target, address string, extraTags map[string]string) server.Server {
+	newServer := server.Server{
+		Name:     target.Name,
+		Address:  address,
+		Provider: "static",
+		Tags:     make(map[string]string),
+	}
+	if newServer.Name == "" {
+		newServer.Name = target.Address
+	}
+	for key, value := range target.Tags {
+		newServer.Tags[key] = value
+	}
+	for key, value := range extraTags {
+		newServer.Tags[key] = value
+	}
+	newServer.Tags[FileTag] = file
+	return newServer
+}
+
+// expandCIDR returns every address of the range, including the network and broadcast addresses nmap scans too.
+func expandCIDR(network *net.IPNet) ([]string, error) {
+	ones, bits := network.Mask.Size()
+	if bits-ones > maxCIDRHostBits {
+		return nil, fmt.Errorf("expandCIDR: %s has more than %d addresses", network, 1<<maxCIDRHostBits)
+	}
+
+	addresses := make([]string, 1<<uint(bits-ones))
+	ip := make(net.IP, len(network.IP))
+	copy(ip, network.IP)
+	for index := range addresses {
+		addresses[index] = ip.String()
+		incrementIP(ip)
+	}
+	return addresses, nil
+}
+
+func incrementIP(ip net.IP) {
+	for index := len(ip) - 1; index >= 0; index-- {
+		ip[index]++
+		if ip[index] != 0 {
+			return
+		}
+	}
+}
+
+// fileFormat returns the format of a targets file from its extension.
+func fileFormat(path string) (string, error) {
+	switch extension := strings.ToLower(filepath.Ext(path)); extension {
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".json":
+		return "json", nil
+	case ".csv":
+		return "csv", nil
+	default:
+		return "", fmt.Errorf("fileFormat: unknown targets file extension %q of %s, expected .yaml, .yml, .json or .csv", extension, path)
+	}
+}
+
+// LoadTargets reads the targets of a YAML or JSON list of targets, or of a CSV file with a header row. CSV files
+// need an address column and may have a name column. Every other column is recorded as a tag named after its header.
+func LoadTargets(path string) ([]*Target, error) {
+	format, err := fileFormat(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadTargets: %s", err)
+	}
+
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadTargets: Error reading file %s", err)
+	}
+
+	var targets []*Target
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(fileBytes, &targets)
+	case "json":
+		err = json.Unmarshal(fileBytes, &targets)
+	case "csv":
+		targets, err = parseCSV(string(fileBytes))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadTargets: Error parsing file %s %s", path, err)
+	}
+
+	for index, target := range targets {
+		if target == nil || target.Address == "" {
+			return nil, fmt.Errorf("LoadTargets: target %d of %s has no address", index, path)
+		}
+	}
+	return targets, nil
+}
+
+func parseCSV(content string) ([]*Target, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parseCSV: %s", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	addressColumn := -1
+	for column, name := range header {
+		header[column] = strings.TrimSpace(name)
+		if header[column] == "address" {
+			addressColumn = column
+		}
+	}
+	if addressColumn < 0 {
+		return nil, fmt.Errorf("parseCSV: the header has no address column")
+	}
+
+	targets := make([]*Target, len(records)-1)
+	for index, record := range records[1:] {
+		target := &Target{Tags: make(map[string]string)}
+		for column, value := range record {
+			value = strings.TrimSpace(value)
+			switch header[column] {
+			case "address":
+				target.Address = value
+			case "name":
+				target.Name = value
+			default:
+				if value != "" {
+					target.Tags[header[column]] = value
+				}
+			}
+		}
+		targets[index] = target
+	}
+	return targets, nil
+}
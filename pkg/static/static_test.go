@@ -0,0 +1,198 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlTargets = `
+- name: colo-web-1
+  address: 203.0.113.10
+  tags:
+    site: colo
+- name: office-nat
+  address: 198.51.100.0/30
+  tags:
+    site: office
+- name: office-gateway
+  address: 198.51.100.1
+- address: api.vendor.example
+`
+
+const jsonTargets = `[{"name": "colo-db-1", "address": "2001:db8::10", "tags": {"site": "colo"}}]`
+
+const csvTargets = `# vendor endpoints
+name, address, owner, site
+vendor-api, missing.vendor.example, payments,
+colo-web-2, 203.0.113.11, web, colo
+`
+
+type loadTargetsTestCase struct {
+	desc        string
+	fileName    string
+	content     string
+	targets     []*Target
+	shouldError bool
+}
+
+func TestLoadTargets(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	directory, err := ioutil.TempDir("", "nmap-diff-static")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	testCases := []loadTargetsTestCase{
+		{
+			desc:     "Load a YAML file",
+			fileName: "targets.yaml",
+			content:  `[{name: colo-web-1, address: 203.0.113.10, tags: {site: colo}}]`,
+			targets: []*Target{
+				{Name: "colo-web-1", Address: "203.0.113.10", Tags: map[string]string{"site": "colo"}},
+			},
+			shouldError: false,
+		},
+		{
+			desc:     "Load a JSON file",
+			fileName: "targets.json",
+			content:  jsonTargets,
+			targets: []*Target{
+				{Name: "colo-db-1", Address: "2001:db8::10", Tags: map[string]string{"site": "colo"}},
+			},
+			shouldError: false,
+		},
+		{
+			desc:     "Load a CSV file with extra columns as tags",
+			fileName: "targets.csv",
+			content:  csvTargets,
+			targets: []*Target{
+				{Name: "vendor-api", Address: "missing.vendor.example", Tags: map[string]string{"owner": "payments"}},
+				{Name: "colo-web-2", Address: "203.0.113.11", Tags: map[string]string{"owner": "web", "site": "colo"}},
+			},
+			shouldError: false,
+		},
+		{
+			desc:        "Error on a CSV file without an address column",
+			fileName:    "no-address.csv",
+			content:     "name,site\ncolo-web-1,colo\n",
+			shouldError: true,
+		},
+		{
+			desc:        "Error on a target without an address",
+			fileName:    "no-address.yaml",
+			content:     "- name: colo-web-1\n",
+			shouldError: true,
+		},
+		{
+			desc:        "Error on an unknown extension",
+			fileName:    "targets.txt",
+			content:     "203.0.113.10\n",
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		path := filepath.Join(directory, testCase.fileName)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(testCase.content), 0600))
+
+		targets, err := LoadTargets(path)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.targets, targets)
+		}
+	}
+}
+
+func TestInstances(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	directory, err := ioutil.TempDir("", "nmap-diff-static")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	var files []string
+	for fileName, content := range map[string]string{"a.yaml": yamlTargets, "b.json": jsonTargets, "c.csv": csvTargets} {
+		path := filepath.Join(directory, fileName)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		files = append(files, path)
+	}
+
+	s, err := New(config.BaseConfig{StaticConfig: &config.StaticConfig{Files: files}})
+	assert.NoError(t, err)
+	s.lookupHost = func(host string) ([]string, error) {
+		if host == "api.vendor.example" {
+			return []string{"192.0.2.80", "192.0.2.81"}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+
+	awsServer := server.Server{Name: "i-0123", Address: "203.0.113.11", Provider: "aws", ResourceID: "i-0123"}
+	serversMap := map[string]server.Server{awsServer.Address: awsServer}
+	err = s.Instances(serversMap)
+
+	t.Logf("TestInstances: hostnames that cannot be resolved are returned after recording the other targets")
+	var hostErrors inventory.Errors
+	assert.True(t, errors.As(err, &hostErrors))
+	assert.Equal(t, 1, len(hostErrors))
+	assert.Equal(t, "missing.vendor.example", hostErrors[0].Task)
+
+	var addresses []string
+	for address := range serversMap {
+		addresses = append(addresses, address)
+	}
+	assert.ElementsMatch(t, []string{
+		"203.0.113.10", "203.0.113.11", "2001:db8::10",
+		"198.51.100.0", "198.51.100.1", "198.51.100.2", "198.51.100.3",
+		"192.0.2.80", "192.0.2.81",
+	}, addresses)
+
+	t.Logf("TestInstances: targets are tagged with their file and what their address was derived from")
+	assert.Equal(t, "static", serversMap["203.0.113.10"].Provider)
+	assert.Equal(t, map[string]string{"site": "colo", FileTag: filepath.Join(directory, "a.yaml")}, serversMap["203.0.113.10"].Tags)
+	assert.Equal(t, "office-nat", serversMap["198.51.100.2"].Name)
+	assert.Equal(t, "198.51.100.0/30", serversMap["198.51.100.2"].Tags[CIDRTag])
+	assert.Equal(t, "api.vendor.example", serversMap["192.0.2.81"].Name)
+	assert.Equal(t, "api.vendor.example", serversMap["192.0.2.81"].Tags[HostnameTag])
+	assert.Equal(t, []string{"api.vendor.example"}, serversMap["192.0.2.81"].Scopes)
+
+	t.Logf("TestInstances: addresses listed on their own take precedence over those of a range")
+	assert.Equal(t, "office-gateway", serversMap["198.51.100.1"].Name)
+	assert.NotContains(t, serversMap["198.51.100.1"].Tags, CIDRTag)
+
+	t.Logf("TestInstances: addresses inventoried by another provider are kept")
+	assert.Equal(t, awsServer, serversMap["203.0.113.11"])
+}
+
+func TestExpandCIDR(t *testing.T) {
+	_, network, _ := net.ParseCIDR("2001:db8::fe/127")
+	addresses, err := expandCIDR(network)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2001:db8::fe", "2001:db8::ff"}, addresses)
+
+	_, network, _ = net.ParseCIDR("10.0.0.0/15")
+	_, err = expandCIDR(network)
+	assert.Error(t, err)
+
+	_, err = New(config.BaseConfig{StaticConfig: &config.StaticConfig{Files: []string{"targets.txt"}}})
+	assert.Error(t, err)
+}
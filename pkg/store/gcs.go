@@ -0,0 +1,75 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+type gcsStore struct {
+	bucketName     string
+	storageService *storage.Service
+}
+
+// NewGCS creates a store that keeps scan results as objects in a Google Cloud Storage bucket.
+func NewGCS(storageConfig *config.StorageConfig, options ...option.ClientOption) (*gcsStore, error) {
+	if storageConfig.Bucket == "" {
+		return nil, fmt.Errorf("NewGCS: Bucket cannot be empty")
+	}
+
+	if storageConfig.ServiceAccountPath != "" {
+		options = append(options, option.WithCredentialsFile(storageConfig.ServiceAccountPath))
+	}
+
+	storageService, err := storage.NewService(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("NewGCS: Error creating storage.Service object %s", err)
+	}
+
+	g := gcsStore{}
+	g.bucketName = storageConfig.Bucket
+	g.storageService = storageService
+	return &g, nil
+}
+
+func (g *gcsStore) Save(key string, data []byte) error {
+	_, err := g.storageService.Objects.Insert(g.bucketName, &storage.Object{Name: key}).
+		Media(bytes.NewReader(data)).Do()
+	if err != nil {
+		return fmt.Errorf("Save: Error uploading object %s", err)
+	}
+	return nil
+}
+
+func (g *gcsStore) Delete(key string) error {
+	err := g.storageService.Objects.Delete(g.bucketName, key).Do()
+	if err != nil {
+		return fmt.Errorf("Delete: Error deleting object %s", err)
+	}
+	return nil
+}
+
+func (g *gcsStore) Load(key string) ([]byte, error) {
+	resp, err := g.storageService.Objects.Get(g.bucketName, key).Download()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		return nil, fmt.Errorf("Load: %s %w", key, wrapper.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Load: Error getting object from gcs %s", err)
+	}
+	defer resp.Body.Close()
+
+	byteSlice, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Load: Error reading to byte slice %s", err)
+	}
+	return byteSlice, nil
+}
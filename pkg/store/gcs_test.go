@@ -0,0 +1,70 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+func TestGCSStore(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	objects := make(map[string][]byte)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/bucket/o"):
+			// Uploads are multipart, holding the object metadata followed by its contents.
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			reader := multipart.NewReader(r.Body, params["boundary"])
+			var metadata storage.Object
+			part, _ := reader.NextPart()
+			json.NewDecoder(part).Decode(&metadata)
+			part, _ = reader.NextPart()
+			body, _ := ioutil.ReadAll(part)
+			name := metadata.Name
+			objects[name] = body
+			w.Write([]byte(`{"name": "` + name + `", "bucket": "bucket"}`))
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+			data, ok := objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer testServer.Close()
+
+	gcsStore, err := NewGCS(&config.StorageConfig{Bucket: "bucket"},
+		option.WithEndpoint(testServer.URL+"/storage/v1/"), option.WithoutAuthentication())
+	assert.NoError(t, err)
+
+	t.Logf("TestGCSStore: Load returns ErrNotFound when the key was never saved")
+	_, err = gcsStore.Load("previous.xml")
+	assert.True(t, errors.Is(err, wrapper.ErrNotFound))
+
+	t.Logf("TestGCSStore: Save uploads the object")
+	err = gcsStore.Save("previous.xml", []byte("scan"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("scan"), objects["previous.xml"])
+
+	t.Logf("TestGCSStore: Load downloads the object")
+	data, err := gcsStore.Load("previous.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("scan"), data)
+}
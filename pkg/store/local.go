@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+)
+
+type localStore struct {
+	directory string
+}
+
+// NewLocal creates a store that keeps scan results as files in a local directory. Keys containing slashes are stored
+// in subdirectories, which are created as needed.
+func NewLocal(storageConfig *config.StorageConfig) (*localStore, error) {
+	if storageConfig.Directory == "" {
+		return nil, fmt.Errorf("NewLocal: Directory cannot be empty")
+	}
+
+	l := localStore{}
+	l.directory = storageConfig.Directory
+	return &l, nil
+}
+
+func (l *localStore) path(key string) string {
+	return filepath.Join(l.directory, filepath.FromSlash(key))
+}
+
+func (l *localStore) Save(key string, data []byte) error {
+	path := l.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("Save: Error creating directory %s", err)
+	}
+
+	// Writing to a temporary file first keeps the previous scan intact if the write is interrupted.
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Save: Error creating file %s", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Save: Error writing file %s", err)
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return fmt.Errorf("Save: Error renaming file %s", err)
+	}
+	return nil
+}
+
+func (l *localStore) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Delete: Error removing file %s", err)
+	}
+	return nil
+}
+
+func (l *localStore) Load(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Load: %s %w", key, wrapper.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Load: Error reading file %s", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type s3Store struct {
+	bucketName string
+	s3svc      s3iface.S3API
+}
+
+// NewS3 creates a store that keeps scan results as objects in an S3 bucket.
+func NewS3(storageConfig *config.StorageConfig) (*s3Store, error) {
+	if storageConfig.Bucket == "" {
+		return nil, fmt.Errorf("NewS3: Bucket cannot be empty")
+	}
+
+	s := s3Store{}
+	s.bucketName = storageConfig.Bucket
+	s.s3svc = s3.New(session.Must(session.NewSession()))
+	return &s, nil
+}
+
+func (s *s3Store) Save(key string, data []byte) error {
+	if s.s3svc == nil {
+		return fmt.Errorf("Save: s3svc cannot be nil")
+	}
+
+	_, err := s.s3svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Body:   bytes.NewReader(data),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("Save: Error uploading object %s", err)
+	}
+	return nil
+}
+
+func (s *s3Store) Delete(key string) error {
+	if s.s3svc == nil {
+		return fmt.Errorf("Delete: s3svc cannot be nil")
+	}
+
+	_, err := s.s3svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("Delete: Error deleting object %s", err)
+	}
+	return nil
+}
+
+func (s *s3Store) Load(key string) ([]byte, error) {
+	if s.s3svc == nil {
+		return nil, fmt.Errorf("Load: s3svc cannot be nil")
+	}
+
+	resp, err := s.s3svc.GetObject(&s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(s.bucketName),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("Load: %s %w", key, wrapper.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Load: Error getting object from s3 %s", err)
+	}
+	defer resp.Body.Close()
+
+	byteSlice, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Load: Error reading to byte slice %s", err)
+	}
+	return byteSlice, nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/mocks"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestS3Load(t *testing.T) {
+	mockS3 := &mocks.MockS3API{}
+
+	testCases := []struct {
+		desc        string
+		setup       func()
+		notFound    bool
+		shouldError bool
+	}{
+		{
+			desc: "successful object retrieval",
+			setup: func() {
+				mockS3.Reset()
+				mockS3.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(&s3.GetObjectOutput{
+					Body: ioutil.NopCloser(strings.NewReader("I am string")),
+				}, nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "error returned by object retrieval",
+			setup: func() {
+				mockS3.Reset()
+				mockS3.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+		{
+			desc: "ErrNotFound returned when the object does not exist",
+			setup: func() {
+				mockS3.Reset()
+				mockS3.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(nil,
+					awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+			},
+			notFound:    true,
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		s := s3Store{bucketName: "bucket", s3svc: mockS3}
+		_, err := s.Load("file")
+
+		mockS3.AssertExpectations(t)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+			assert.Equal(t, testCase.notFound, errors.Is(err, wrapper.ErrNotFound))
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestS3Save(t *testing.T) {
+	mockS3 := &mocks.MockS3API{}
+
+	testCases := []storeTestCase{
+		{
+			desc: "successful object upload",
+			setup: func() {
+				mockS3.Reset()
+				mockS3.On("PutObject", mock.AnythingOfType("*s3.PutObjectInput")).Return(&s3.PutObjectOutput{}, nil)
+			},
+			shouldError: false,
+		},
+		{
+			desc: "error returned by object upload",
+			setup: func() {
+				mockS3.Reset()
+				mockS3.On("PutObject", mock.AnythingOfType("*s3.PutObjectInput")).Return(nil, fmt.Errorf("error"))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testCase.setup()
+
+		s := s3Store{bucketName: "bucket", s3svc: mockS3}
+		err := s.Save("file", []byte("data"))
+
+		mockS3.AssertExpectations(t)
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
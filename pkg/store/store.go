@@ -0,0 +1,28 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+)
+
+// New creates the scan store selected by configObject.StorageConfig. When it is not set, the S3 bucket in
+// configObject.BucketName is used.
+func New(configObject config.BaseConfig) (wrapper.ScanStore, error) {
+	storageConfig := configObject.StorageConfig
+	if storageConfig == nil {
+		storageConfig = &config.StorageConfig{Type: config.S3Storage, Bucket: configObject.BucketName}
+	}
+
+	switch storageConfig.Type {
+	case config.S3Storage, "":
+		return NewS3(storageConfig)
+	case config.LocalStorage:
+		return NewLocal(storageConfig)
+	case config.GCSStorage:
+		return NewGCS(storageConfig)
+	default:
+		return nil, fmt.Errorf("New: unknown storage type %s", storageConfig.Type)
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type storeTestCase struct {
+	desc        string
+	setup       func()
+	shouldError bool
+}
+
+func TestNew(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	testCases := []struct {
+		desc         string
+		configObject config.BaseConfig
+		shouldError  bool
+	}{
+		{
+			desc:         "Use the S3 bucket in BucketName when no storage is configured",
+			configObject: config.BaseConfig{BucketName: "bucket"},
+			shouldError:  false,
+		},
+		{
+			desc:         "Error if no storage is configured and BucketName is empty",
+			configObject: config.BaseConfig{},
+			shouldError:  true,
+		},
+		{
+			desc:         "Create a local store",
+			configObject: config.BaseConfig{StorageConfig: &config.StorageConfig{Type: config.LocalStorage, Directory: "/tmp"}},
+			shouldError:  false,
+		},
+		{
+			desc:         "Error if the local store has no directory",
+			configObject: config.BaseConfig{StorageConfig: &config.StorageConfig{Type: config.LocalStorage}},
+			shouldError:  true,
+		},
+		{
+			desc:         "Error if the gcs store has no bucket",
+			configObject: config.BaseConfig{StorageConfig: &config.StorageConfig{Type: config.GCSStorage}},
+			shouldError:  true,
+		},
+		{
+			desc:         "Error for an unknown storage type",
+			configObject: config.BaseConfig{StorageConfig: &config.StorageConfig{Type: "ftp"}},
+			shouldError:  true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		_, err := New(testCase.configObject)
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	directory, err := ioutil.TempDir("", "nmap-diff")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	localStore, err := NewLocal(&config.StorageConfig{Directory: directory})
+	assert.NoError(t, err)
+
+	t.Logf("TestLocalStore: Load returns ErrNotFound when the key was never saved")
+	_, err = localStore.Load("scans/previous.xml")
+	assert.True(t, errors.Is(err, wrapper.ErrNotFound))
+
+	t.Logf("TestLocalStore: Save creates the key in a subdirectory")
+	err = localStore.Save("scans/previous.xml", []byte("first"))
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(directory, "scans", "previous.xml"))
+
+	t.Logf("TestLocalStore: Save replaces the previous contents")
+	err = localStore.Save("scans/previous.xml", []byte("second"))
+	assert.NoError(t, err)
+
+	data, err := localStore.Load("scans/previous.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("second"), data)
+
+	files, err := ioutil.ReadDir(filepath.Join(directory, "scans"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	t.Logf("TestLocalStore: Delete removes the key and ignores keys that do not exist")
+	assert.NoError(t, localStore.Delete("scans/previous.xml"))
+	assert.NoError(t, localStore.Delete("scans/previous.xml"))
+	_, err = localStore.Load("scans/previous.xml")
+	assert.Error(t, err)
+}
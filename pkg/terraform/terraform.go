@@ -0,0 +1,333 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/store"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StateTag holds the location of the state file the resource is found in.
+	StateTag = "terraformState"
+	// ResourceTypeTag holds the type of the resource, such as aws_eip. Tags and labels of the resource are recorded
+	// under their own keys.
+	ResourceTypeTag = "terraformResourceType"
+	// HostnameTag holds the hostname a load balancer address was resolved from.
+	HostnameTag = "hostname"
+
+	// stateVersion is the version of the state files written since Terraform 0.12.
+	stateVersion = 4
+)
+
+// state holds the parts of a Terraform state file that are inventoried.
+type state struct {
+	Version   int              `json:"version"`
+	Resources []*stateResource `json:"resources"`
+}
+
+type stateResource struct {
+	Module    string           `json:"module"`
+	Mode      string           `json:"mode"`
+	Type      string           `json:"type"`
+	Name      string           `json:"name"`
+	Instances []*stateInstance `json:"instances"`
+}
+
+// stateInstance is an instance of a resource. IndexKey is a number for resources using count, a string for those
+// using for_each and nil otherwise.
+type stateInstance struct {
+	IndexKey   interface{}            `json:"index_key"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// exposure holds the public addresses of a resource instance, and the hostnames of load balancers that have to be
+// resolved into addresses.
+type exposure struct {
+	addresses []string
+	hostnames []string
+}
+
+// resourceTypes extracts the public addresses of every resource type that is inventoried.
+var resourceTypes = map[string]func(attributes map[string]interface{}) exposure{
+	"aws_instance":                          attributeAddress("public_ip"),
+	"aws_eip":                               attributeAddress("public_ip"),
+	"aws_nat_gateway":                       attributeAddress("public_ip"),
+	"aws_lb":                                loadBalancerHostname,
+	"aws_alb":                               loadBalancerHostname,
+	"aws_elb":                               loadBalancerHostname,
+	"google_compute_instance":               instanceNatIPs,
+	"google_compute_address":                externalAddress("address", "address_type"),
+	"google_compute_global_address":         externalAddress("address", "address_type"),
+	"google_compute_forwarding_rule":        externalAddress("ip_address", "load_balancing_scheme"),
+	"google_compute_global_forwarding_rule": externalAddress("ip_address", "load_balancing_scheme"),
+	"azurerm_public_ip":                     attributeAddress("ip_address"),
+}
+
+// stateFile is a state file loaded from a store, such as the S3 or GCS bucket of a Terraform backend.
+type stateFile struct {
+	location string
+	store    wrapper.ScanStore
+	key      string
+}
+
+type terraformSvc struct {
+	stateFiles []*stateFile
+	// lookupHost resolves the hostnames of load balancers.
+	lookupHost func(host string) ([]string, error)
+}
+
+// New returns a service inventorying the resources of the configured state files. The state files are loaded on every
+// inventory, so that changes are picked up by long running servers.
+func New(configObject config.BaseConfig) (*terraformSvc, error) {
+	if configObject.TerraformConfig == nil || len(configObject.TerraformConfig.StateFiles) == 0 {
+		return nil, fmt.Errorf("New: no Terraform state file is configured")
+	}
+
+	serviceAccountPath := ""
+	if configObject.GCloudConfig != nil {
+		serviceAccountPath = configObject.GCloudConfig.ServiceAccountPath
+	}
+
+	t := terraformSvc{lookupHost: net.LookupHost}
+	for _, location := range configObject.TerraformConfig.StateFiles {
+		stateFile, err := newStateFile(location, serviceAccountPath)
+		if err != nil {
+			return nil, fmt.Errorf("New: %s", err)
+		}
+		t.stateFiles = append(t.stateFiles, stateFile)
+	}
+	return &t, nil
+}
+
+// newStateFile returns the state file at location, which is an s3://bucket/key or gs://bucket/key URL or a local
+// path. GCS objects are read with the service account at serviceAccountPath, or the default credentials when empty.
+func newStateFile(location string, serviceAccountPath string) (*stateFile, error) {
+	var err error
+	s := &stateFile{location: location}
+
+	scheme := strings.SplitN(location, "://", 2)
+	switch scheme[0] {
+	case "s3", "gs":
+		parts := strings.SplitN(scheme[1], "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("newStateFile: invalid state file URL %s, expected a bucket and a key", location)
+		}
+
+		s.key = parts[1]
+		storageConfig := &config.StorageConfig{Bucket: parts[0], ServiceAccountPath: serviceAccountPath}
+		if scheme[0] == "s3" {
+			s.store, err = store.NewS3(storageConfig)
+		} else {
+			s.store, err = store.NewGCS(storageConfig)
+		}
+	default:
+		s.key = filepath.Base(location)
+		s.store, err = store.NewLocal(&config.StorageConfig{Directory: filepath.Dir(location)})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("newStateFile: Error configuring the storage of %s %s", location, err)
+	}
+	return s, nil
+}
+
+// Instances records the public addresses of the resources of every state file, named after the address of the
+// resource, such as module.vpc.aws_eip.nat[0]. State files that could not be loaded and load balancer hostnames that
+// could not be resolved are returned as inventory.Errors after the other resources are recorded.
+func (t *terraformSvc) Instances(serversMap map[string]server.Server) error {
+	var errs inventory.Errors
+	for _, s := range t.stateFiles {
+		log.Debug("Loading Terraform state " + s.location)
+		stateBytes, err := s.store.Load(s.key)
+		if err != nil {
+			errs = append(errs, &inventory.TaskError{Task: s.location, Err: err})
+			continue
+		}
+
+		hostErrors, err := t.addResources(s.location, stateBytes, serversMap)
+		if err != nil {
+			errs = append(errs, &inventory.TaskError{Task: s.location, Err: err})
+			continue
+		}
+		errs = append(errs, hostErrors...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Instances: Error inventorying Terraform state %w", errs)
+	}
+	return nil
+}
+
+// addResources records the public addresses of the managed resources of the state. An address that is already
+// recorded, by a cloud provider or an earlier resource, keeps its entry so that the instances of the cloud providers
+// keep their identity. Resources are recorded with the state file as scope, along with the hostname their addresses
+// were resolved from. Hostnames that could not be resolved are returned as inventory.Errors.
+func (t *terraformSvc) addResources(location string, stateBytes []byte, serversMap map[string]server.Server) (inventory.Errors, error) {
+	var s state
+	err := json.Unmarshal(stateBytes, &s)
+	if err != nil {
+		return nil, fmt.Errorf("addResources: Error parsing state %s", err)
+	}
+	if s.Version != stateVersion {
+		return nil, fmt.Errorf("addResources: unsupported state version %d, expected %d", s.Version, stateVersion)
+	}
+
+	var hostErrors inventory.Errors
+	add := func(address string, newServer server.Server) {
+		if _, ok := serversMap[address]; ok {
+			return
+		}
+		serversMap[address] = newServer
+	}
+	for _, resource := range s.Resources {
+		extract, ok := resourceTypes[resource.Type]
+		if !ok || resource.Mode != "managed" {
+			continue
+		}
+
+		for _, instance := range resource.Instances {
+			name := resourceAddress(resource, instance)
+			found := extract(instance.Attributes)
+			for _, address := range found.addresses {
+				add(address, newServer(location, resource.Type, name, address, instance.Attributes, nil))
+			}
+
+			for _, hostname := range found.hostnames {
+				addresses, err := t.lookupHost(hostname)
+				if err != nil {
+					hostErrors = append(hostErrors, &inventory.TaskError{Task: hostname, Err: err})
+					continue
+				}
+				for _, address := range addresses {
+					resolved := newServer(location, resource.Type, name, address, instance.Attributes,
+						map[string]string{HostnameTag: hostname})
+					resolved.Scopes = append(resolved.Scopes, hostname)
+					add(address, resolved)
+				}
+			}
+		}
+	}
+	return hostErrors, nil
+}
+
+func newServer(location string, resourceType string, name string, address string, attributes map[string]interface{},
+	extraTags map[string]string) server.Server {
+	// Resources are identified by the ID the provider assigned them, or by their address in the state.
+	newServer := server.Server{
+		Name:       name,
+		Address:    address,
+		Provider:   "terraform",
+		ResourceID: stringAttribute(attributes, "id"),
+		Scopes:     []string{location},
+		Tags:       make(map[string]string),
+	}
+	if newServer.ResourceID == "" {
+		newServer.ResourceID = location + "#" + name
+	}
+	// AWS resources have tags and GCloud resources labels.
+	for _, tagsAttribute := range []string{"tags", "labels"} {
+		tags, _ := attributes[tagsAttribute].(map[string]interface{})
+		for key, value := range tags {
+			if value, ok := value.(string); ok {
+				newServer.Tags[key] = value
+			}
+		}
+	}
+	for key, value := range extraTags {
+		newServer.Tags[key] = value
+	}
+	newServer.Tags[StateTag] = location
+	newServer.Tags[ResourceTypeTag] = resourceType
+	return newServer
+}
+
+// resourceAddress returns the address Terraform shows for the resource instance, such as module.vpc.aws_eip.nat[0]
+// or aws_instance.web["blue"].
+func resourceAddress(resource *stateResource, instance *stateInstance) string {
+	address := resource.Type + "." + resource.Name
+	if resource.Module != "" {
+		address = resource.Module + "." + address
+	}
+
+	switch indexKey := instance.IndexKey.(type) {
+	case float64:
+		address += "[" + strconv.FormatFloat(indexKey, 'f', -1, 64) + "]"
+	case string:
+		address += "[" + strconv.Quote(indexKey) + "]"
+	}
+	return address
+}
+
+// attributeAddress returns the address held in the attribute, when one is set.
+func attributeAddress(attribute string) func(attributes map[string]interface{}) exposure {
+	return func(attributes map[string]interface{}) exposure {
+		return exposure{addresses: validAddresses(stringAttribute(attributes, attribute))}
+	}
+}
+
+// externalAddress returns the address held in the attribute unless schemeAttribute marks it as internal. GCloud
+// addresses and forwarding rules are external when the scheme is empty or starts with EXTERNAL.
+func externalAddress(attribute string, schemeAttribute string) func(attributes map[string]interface{}) exposure {
+	return func(attributes map[string]interface{}) exposure {
+		scheme := stringAttribute(attributes, schemeAttribute)
+		if scheme != "" && !strings.HasPrefix(scheme, "EXTERNAL") {
+			return exposure{}
+		}
+		return exposure{addresses: validAddresses(stringAttribute(attributes, attribute))}
+	}
+}
+
+// loadBalancerHostname returns the hostname of AWS load balancers that are not internal.
+func loadBalancerHostname(attributes map[string]interface{}) exposure {
+	if internal, _ := attributes["internal"].(bool); internal {
+		return exposure{}
+	}
+	if hostname := stringAttribute(attributes, "dns_name"); hostname != "" {
+		return exposure{hostnames: []string{hostname}}
+	}
+	return exposure{}
+}
+
+// instanceNatIPs returns the external addresses of every access config of every network interface of a GCloud
+// instance.
+func instanceNatIPs(attributes map[string]interface{}) exposure {
+	var addresses []string
+	networkInterfaces, _ := attributes["network_interface"].([]interface{})
+	for _, networkInterface := range networkInterfaces {
+		networkInterface, _ := networkInterface.(map[string]interface{})
+		accessConfigs, _ := networkInterface["access_config"].([]interface{})
+		for _, accessConfig := range accessConfigs {
+			accessConfig, _ := accessConfig.(map[string]interface{})
+			addresses = append(addresses, validAddresses(stringAttribute(accessConfig, "nat_ip"))...)
+		}
+	}
+	return exposure{addresses: addresses}
+}
+
+// stringAttribute returns the attribute when it is a string, or an empty string.
+func stringAttribute(attributes map[string]interface{}, attribute string) string {
+	value, _ := attributes[attribute].(string)
+	return value
+}
+
+// validAddresses returns the addresses that are IP addresses, in their canonical form.
+func validAddresses(addresses ...string) []string {
+	var valid []string
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			valid = append(valid, ip.String())
+		} else if address != "" {
+			log.Debug("Skipping invalid address " + address)
+		}
+	}
+	return valid
+}
@@ -0,0 +1,180 @@
+package terraform
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/inventory"
+	"github.com/Invoca/nmap-diff/pkg/mocks"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const stateContent = `{
+  "version": 4,
+  "terraform_version": "0.14.7",
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "web",
+      "instances": [
+        {"index_key": 0, "attributes": {"id": "i-123", "public_ip": "1.1.1.1", "tags": {"team": "web"}}},
+        {"index_key": 1, "attributes": {"id": "i-456", "public_ip": ""}}
+      ]
+    },
+    {
+      "module": "module.vpc",
+      "mode": "managed",
+      "type": "aws_eip",
+      "name": "nat",
+      "instances": [{"index_key": "us-east-1a", "attributes": {"public_ip": "2.2.2.2"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_lb",
+      "name": "public",
+      "instances": [{"attributes": {"dns_name": "public.elb.amazonaws.com", "internal": false}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_lb",
+      "name": "private",
+      "instances": [{"attributes": {"dns_name": "internal-private.elb.amazonaws.com", "internal": true}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_elb",
+      "name": "legacy",
+      "instances": [{"attributes": {"dns_name": "missing.elb.amazonaws.com"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "google_compute_instance",
+      "name": "api",
+      "instances": [{"attributes": {
+        "labels": {"env": "prod"},
+        "network_interface": [{"access_config": [{"nat_ip": "4.4.4.4"}]}, {"access_config": []}]
+      }}]
+    },
+    {
+      "mode": "managed",
+      "type": "google_compute_address",
+      "name": "internal",
+      "instances": [{"attributes": {"address": "10.0.0.5", "address_type": "INTERNAL"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "google_compute_global_forwarding_rule",
+      "name": "https",
+      "instances": [{"attributes": {"ip_address": "5.5.5.5", "load_balancing_scheme": "EXTERNAL_MANAGED"}}]
+    },
+    {
+      "mode": "data",
+      "type": "aws_eip",
+      "name": "existing",
+      "instances": [{"attributes": {"public_ip": "6.6.6.6"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_security_group",
+      "name": "web",
+      "instances": [{"attributes": {"id": "sg-123"}}]
+    }
+  ]
+}`
+
+func lookupHost(host string) ([]string, error) {
+	if host == "public.elb.amazonaws.com" {
+		return []string{"3.3.3.3"}, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(config.BaseConfig{})
+	assert.Error(t, err)
+
+	_, err = New(config.BaseConfig{TerraformConfig: &config.TerraformConfig{StateFiles: []string{"s3://bucket"}}})
+	assert.Error(t, err)
+
+	_, err = New(config.BaseConfig{TerraformConfig: &config.TerraformConfig{StateFiles: []string{"gs:///key"}}})
+	assert.Error(t, err)
+
+	tf, err := New(config.BaseConfig{TerraformConfig: &config.TerraformConfig{
+		StateFiles: []string{"s3://bucket/env/prod/terraform.tfstate", "states/prod.tfstate"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tf.stateFiles))
+	assert.Equal(t, "env/prod/terraform.tfstate", tf.stateFiles[0].key)
+	assert.Equal(t, "prod.tfstate", tf.stateFiles[1].key)
+}
+
+func TestInstances(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	directory, err := ioutil.TempDir("", "nmap-diff-terraform")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "terraform.tfstate")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(stateContent), 0644))
+	oldPath := filepath.Join(directory, "old.tfstate")
+	assert.NoError(t, ioutil.WriteFile(oldPath, []byte(`{"version": 3, "modules": []}`), 0644))
+
+	tf, err := New(config.BaseConfig{TerraformConfig: &config.TerraformConfig{StateFiles: []string{path, oldPath}}})
+	assert.NoError(t, err)
+	tf.lookupHost = lookupHost
+
+	backend := &mocks.ScanStoreMock{}
+	backend.On("Load", nil).Return(nil, errors.New("AccessDenied"))
+	tf.stateFiles = append(tf.stateFiles, &stateFile{location: "s3://bucket/terraform.tfstate", store: backend, key: "terraform.tfstate"})
+
+	serversMap := make(map[string]server.Server)
+	err = tf.Instances(serversMap)
+
+	var errs inventory.Errors
+	assert.True(t, errors.As(err, &errs))
+	tasks := make([]string, len(errs))
+	for index, taskError := range errs {
+		tasks[index] = taskError.Task
+	}
+	assert.Equal(t, []string{"missing.elb.amazonaws.com", oldPath, "s3://bucket/terraform.tfstate"}, tasks)
+
+	tags := func(resourceType string, extraTags map[string]string) map[string]string {
+		tags := map[string]string{StateTag: path, ResourceTypeTag: resourceType}
+		for key, value := range extraTags {
+			tags[key] = value
+		}
+		return tags
+	}
+	assert.Equal(t, map[string]server.Server{
+		"1.1.1.1": {Name: "aws_instance.web[0]", Address: "1.1.1.1", Provider: "terraform", ResourceID: "i-123",
+			Scopes: []string{path}, Tags: tags("aws_instance", map[string]string{"team": "web"})},
+		"2.2.2.2": {Name: `module.vpc.aws_eip.nat["us-east-1a"]`, Address: "2.2.2.2", Provider: "terraform",
+			ResourceID: path + `#module.vpc.aws_eip.nat["us-east-1a"]`, Scopes: []string{path},
+			Tags: tags("aws_eip", nil)},
+		"3.3.3.3": {Name: "aws_lb.public", Address: "3.3.3.3", Provider: "terraform", ResourceID: path + "#aws_lb.public",
+			Scopes: []string{path, "public.elb.amazonaws.com"},
+			Tags:   tags("aws_lb", map[string]string{HostnameTag: "public.elb.amazonaws.com"})},
+		"4.4.4.4": {Name: "google_compute_instance.api", Address: "4.4.4.4", Provider: "terraform",
+			ResourceID: path + "#google_compute_instance.api", Scopes: []string{path},
+			Tags: tags("google_compute_instance", map[string]string{"env": "prod"})},
+		"5.5.5.5": {Name: "google_compute_global_forwarding_rule.https", Address: "5.5.5.5", Provider: "terraform",
+			ResourceID: path + "#google_compute_global_forwarding_rule.https", Scopes: []string{path},
+			Tags: tags("google_compute_global_forwarding_rule", nil)},
+	}, serversMap)
+
+	t.Logf("TestInstances: addresses already inventoried by a cloud provider keep their entry")
+	awsServer := server.Server{Name: "web", Address: "1.1.1.1", Provider: "aws", ResourceID: "i-123"}
+	serversMap = map[string]server.Server{awsServer.Address: awsServer}
+	tf.Instances(serversMap)
+	assert.Equal(t, awsServer, serversMap["1.1.1.1"])
+	assert.Equal(t, "terraform", serversMap["2.2.2.2"].Provider)
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+)
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+// payload is the JSON body posted to the webhook. Every change is listed per host, in the same order slack posts
+// them. When a baseline was established, only Baseline, Summary, InventoryErrors and OutsideInventory are set.
+type payload struct {
+	Baseline            bool                     `json:"baseline,omitempty"`
+	Summary             string                   `json:"summary,omitempty"`
+	InventoryErrors     []wrapper.InventoryError `json:"inventoryErrors,omitempty"`
+	OutsideInventory    []server.Server          `json:"outsideInventory,omitempty"`
+	AddressChanges      []server.Server          `json:"addressChanges"`
+	ReassignedAddresses []server.Server          `json:"reassignedAddresses"`
+	NewHosts            []server.Server          `json:"newHosts"`
+	OpenedPorts         []server.Server          `json:"openedPorts"`
+	ClosedPorts         []server.Server          `json:"closedPorts"`
+	ChangedServices     []server.Server          `json:"changedServices"`
+	StateTransitions    []server.Server          `json:"stateTransitions"`
+	RemovedHosts        []server.Server          `json:"removedHosts"`
+}
+
+func New(webhookConfig *config.WebhookConfig) (*webhook, error) {
+	if webhookConfig == nil {
+		return nil, fmt.Errorf("New: WebhookConfig cannot be nil")
+	}
+
+	if webhookConfig.URL == "" {
+		return nil, fmt.Errorf("New: URL cannot be empty")
+	}
+
+	return &webhook{
+		url:    webhookConfig.URL,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Notify posts the whole report to the webhook as a single JSON document. Nothing is posted when nothing changed, no
+// baseline was established and the inventory was complete.
+func (w *webhook) Notify(report wrapper.Report) error {
+	if report.Empty() {
+		log.Debug("Nothing changed, skipping the webhook")
+		return nil
+	}
+
+	body := payload{}
+	if report.Baseline {
+		body.Baseline = true
+		body.Summary = report.BaselineSummary()
+	} else {
+		body = payload{
+			AddressChanges:      report.AddressChanges(),
+			ReassignedAddresses: report.ReassignedAddresses(),
+			NewHosts:            report.NewHosts(),
+			OpenedPorts:         report.OpenedPorts(),
+			ClosedPorts:         report.ClosedPorts(),
+			ChangedServices:     report.ChangedServices(),
+			StateTransitions:    report.StateTransitions(),
+			RemovedHosts:        report.RemovedHosts(),
+		}
+	}
+	body.InventoryErrors = report.InventoryErrors
+	body.OutsideInventory = report.OutsideInventory
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("Notify: Error marshalling report %s", err)
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Notify: Error posting report %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Notify: Received non 2xx Status Code %s", resp.Status)
+	}
+	log.Debug("Received Status: " + resp.Status)
+	return nil
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Invoca/nmap-diff/pkg/config"
+	"github.com/Invoca/nmap-diff/pkg/server"
+	"github.com/Invoca/nmap-diff/pkg/wrapper"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookTestCase struct {
+	desc        string
+	setup       func() *httptest.Server
+	shouldError bool
+}
+
+func TestNotify(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	diff := wrapper.NewScanDiff()
+	diff.OpenedPorts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen}}
+	diff.AddressChanges["1.1.1.1"] = server.AddressChange{Identity: "aws:i-123", Previous: "2.2.2.2", Current: "1.1.1.1"}
+	diff.ReassignedAddresses["3.3.3.3"] = server.Reassignment{Address: "3.3.3.3", Previous: "aws:i-456", Current: "aws:i-789"}
+	diff.RemovedHosts["3.3.3.3"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 22}: {State: server.PortOpen}}
+	report := wrapper.Report{
+		Servers: map[string]server.Server{
+			"1.1.1.1": {Name: "Instance1", Address: "1.1.1.1", Provider: "aws", ResourceID: "i-123"},
+			"3.3.3.3": {Name: "Instance3", Address: "3.3.3.3", Provider: "aws", ResourceID: "i-789"},
+		},
+		Diff: diff,
+	}
+
+	var received payload
+
+	testCases := []webhookTestCase{
+		{
+			desc: "Able to post the report to the webhook",
+			setup: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&received)
+				}))
+			},
+			shouldError: false,
+		},
+		{
+			desc: "Error posting to the webhook",
+			setup: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(500)
+				}))
+			},
+			shouldError: true,
+		},
+	}
+
+	for index, testCase := range testCases {
+		log.WithFields(log.Fields{
+			"desc":        testCase.desc,
+			"shouldError": testCase.shouldError,
+		}).Debug("Starting testCase " + strconv.Itoa(index))
+
+		testServer := testCase.setup()
+
+		w, err := New(&config.WebhookConfig{URL: testServer.URL})
+		assert.NoError(t, err)
+
+		err = w.Notify(report)
+
+		testServer.Close()
+
+		if testCase.shouldError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, 1, len(received.OpenedPorts))
+	assert.Equal(t, "Instance1", received.OpenedPorts[0].Name)
+	assert.Equal(t, 1, len(received.AddressChanges))
+	assert.Equal(t, "2.2.2.2", received.AddressChanges[0].PreviousAddress)
+	assert.Equal(t, 1, len(received.ReassignedAddresses))
+	assert.Equal(t, "aws:i-456", received.ReassignedAddresses[0].PreviousIdentity)
+
+	// The host removed from a reassigned address is not named after the resource that now holds the address.
+	assert.Equal(t, 1, len(received.RemovedHosts))
+	assert.Equal(t, "3.3.3.3", received.RemovedHosts[0].Name)
+
+	_, err := New(&config.WebhookConfig{})
+	assert.Error(t, err)
+}
+
+func TestNotifyBaseline(t *testing.T) {
+	diff := wrapper.NewScanDiff()
+	diff.NewHosts["1.1.1.1"] = wrapper.PortMap{
+		server.Port{Protocol: "tcp", ID: 22}:  {State: server.PortOpen},
+		server.Port{Protocol: "tcp", ID: 443}: {State: server.PortOpen},
+	}
+
+	var received payload
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer testServer.Close()
+
+	w, err := New(&config.WebhookConfig{URL: testServer.URL})
+	assert.NoError(t, err)
+
+	err = w.Notify(wrapper.Report{Diff: diff, Baseline: true})
+	assert.NoError(t, err)
+
+	assert.True(t, received.Baseline)
+	assert.Equal(t, "Baseline established with 1 hosts and 2 open ports", received.Summary)
+	assert.Empty(t, received.NewHosts)
+}
+
+func TestNotifyInventoryErrors(t *testing.T) {
+	inventoryErrors := []wrapper.InventoryError{{Provider: "aws", Scope: "123456789012/ap-east-1", Message: "AccessDenied"}}
+
+	var received payload
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer testServer.Close()
+
+	w, err := New(&config.WebhookConfig{URL: testServer.URL})
+	assert.NoError(t, err)
+
+	for _, baseline := range []bool{false, true} {
+		received = payload{}
+		err = w.Notify(wrapper.Report{Diff: wrapper.NewScanDiff(), Baseline: baseline, InventoryErrors: inventoryErrors})
+		assert.NoError(t, err)
+		assert.Equal(t, inventoryErrors, received.InventoryErrors)
+	}
+}
+
+func TestNotifyOutsideInventory(t *testing.T) {
+	outsideInventory := []server.Server{{Name: "shop.example.com", Address: "5.5.5.5", Provider: "dns"}}
+
+	posted := 0
+	var received payload
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer testServer.Close()
+
+	w, err := New(&config.WebhookConfig{URL: testServer.URL})
+	assert.NoError(t, err)
+
+	t.Logf("TestNotifyOutsideInventory: hosts outside of the inventory are not posted on their own")
+	err = w.Notify(wrapper.Report{Diff: wrapper.NewScanDiff(), OutsideInventory: outsideInventory})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, posted)
+
+	for _, baseline := range []bool{false, true} {
+		diff := wrapper.NewScanDiff()
+		diff.NewHosts["1.1.1.1"] = wrapper.PortMap{server.Port{Protocol: "tcp", ID: 22}: {State: server.PortOpen}}
+
+		t.Logf("TestNotifyOutsideInventory: baseline %t lists the hosts outside of the inventory", baseline)
+		received = payload{}
+		err = w.Notify(wrapper.Report{Diff: diff, Baseline: baseline, OutsideInventory: outsideInventory})
+		assert.NoError(t, err)
+		assert.Equal(t, outsideInventory, received.OutsideInventory)
+	}
+}
+
+func TestNotifyNothingChanged(t *testing.T) {
+	posted := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer testServer.Close()
+
+	w, err := New(&config.WebhookConfig{URL: testServer.URL})
+	assert.NoError(t, err)
+
+	t.Logf("TestNotifyNothingChanged: nothing is posted when nothing changed")
+	err = w.Notify(wrapper.Report{Diff: wrapper.NewScanDiff()})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, posted)
+
+	t.Logf("TestNotifyNothingChanged: a baseline is posted even without hosts")
+	err = w.Notify(wrapper.Report{Diff: wrapper.NewScanDiff(), Baseline: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, posted)
+}
+
+func TestNotifyPayloadSchema(t *testing.T) {
+	diff := wrapper.NewScanDiff()
+	diff.StateTransitions["1.1.1.1"] = []server.StateTransition{
+		{Port: server.Port{Protocol: "tcp", ID: 443}, Previous: server.PortFiltered, Current: server.PortOpen},
+	}
+	report := wrapper.Report{
+		Servers: map[string]server.Server{
+			"1.1.1.1": {Name: "Instance1", Address: "1.1.1.1", Provider: "aws", ResourceID: "i-123", Tags: map[string]string{"team": "web"}},
+		},
+		Diff: diff,
+	}
+
+	var received map[string]interface{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer testServer.Close()
+
+	w, err := New(&config.WebhookConfig{URL: testServer.URL})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Notify(report))
+
+	t.Logf("TestNotifyPayloadSchema: hosts are posted with camelCase fields")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name":       "Instance1",
+		"address":    "1.1.1.1",
+		"provider":   "aws",
+		"resourceId": "i-123",
+		"stateTransitions": []interface{}{map[string]interface{}{
+			"port":     map[string]interface{}{"protocol": "tcp", "id": float64(443)},
+			"previous": "filtered",
+			"current":  "open",
+		}},
+		"tags": map[string]interface{}{"team": "web"},
+	}}, received["stateTransitions"])
+}
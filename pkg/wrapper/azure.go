@@ -0,0 +1,44 @@
+package wrapper
+
+import "github.com/Invoca/nmap-diff/pkg/server"
+
+type AzureWrapper interface {
+	// Subscriptions returns the IDs of the subscriptions the credentials can read.
+	Subscriptions() ([]string, error)
+	// PublicIPAddresses, NetworkInterfaces and VirtualMachines return the resources of every resource group of the
+	// subscription.
+	PublicIPAddresses(subscriptionID string) ([]*AzurePublicIPAddress, error)
+	NetworkInterfaces(subscriptionID string) ([]*AzureNetworkInterface, error)
+	VirtualMachines(subscriptionID string) ([]*AzureResource, error)
+}
+
+type AzureSvc interface {
+	Instances(serversMap map[string]server.Server) error
+}
+
+// AzureResource holds what every Azure resource has. IDs look like
+// /subscriptions/ID/resourceGroups/GROUP/providers/NAMESPACE/TYPE/NAME.
+type AzureResource struct {
+	ID       string
+	Name     string
+	Location string
+	Tags     map[string]string
+}
+
+type AzurePublicIPAddress struct {
+	AzureResource
+	// IPAddress is empty for dynamic addresses that are not allocated.
+	IPAddress string
+	// IPConfigurationID is the ID of the network interface or load balancer IP configuration the address is
+	// associated with. It is empty for unassociated addresses.
+	IPConfigurationID string
+	// NATGatewayID is the ID of the NAT gateway the address is the outbound address of. Such addresses have no IP
+	// configuration.
+	NATGatewayID string
+}
+
+type AzureNetworkInterface struct {
+	AzureResource
+	// VirtualMachineID is empty for network interfaces that are not attached to a virtual machine.
+	VirtualMachineID string
+}
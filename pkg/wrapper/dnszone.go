@@ -0,0 +1,7 @@
+package wrapper
+
+import "github.com/Invoca/nmap-diff/pkg/server"
+
+type DNSZoneSvc interface {
+	Instances(serversMap map[string]server.Server) error
+}
@@ -6,10 +6,24 @@ import (
 )
 
 type GCloudWrapper interface {
-	Zones() ([]string, error)
-	InstancesInRegion(region string) ([]compute.Instance, error)
+	// AggregatedInstances returns the instances of every zone of the project, keyed by zone. The list of a zone that
+	// could not be reached carries a warning instead of instances.
+	AggregatedInstances() (map[string]compute.InstancesScopedList, error)
+	// AggregatedForwardingRules and AggregatedAddresses return the regional forwarding rules and addresses, keyed by
+	// region.
+	AggregatedForwardingRules() (map[string]compute.ForwardingRulesScopedList, error)
+	GlobalForwardingRules() ([]*compute.ForwardingRule, error)
+	AggregatedAddresses() (map[string]compute.AddressesScopedList, error)
+	GlobalAddresses() ([]*compute.Address, error)
 }
 
 type GCloudSvc interface {
 	Instances(serversMap map[string]server.Server) error
 }
+
+type ProjectLister interface {
+	// Folders and Projects return the folders and the IDs of the active projects directly under the parent, such as
+	// "folders/123" or "organizations/456".
+	Folders(parent string) ([]string, error)
+	Projects(parent string) ([]string, error)
+}
@@ -0,0 +1,213 @@
+package wrapper
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/Invoca/nmap-diff/pkg/server"
+)
+
+// Notifier is a sink for the result of a run, such as Slack.
+type Notifier interface {
+	Notify(report Report) error
+}
+
+// Report holds the result of a run: the servers found during inventory, keyed by address, and the diff between the
+// previous scan and the current scan. Baseline is set when no previous scan existed, in which case every host in the
+// current scan is listed in Diff.NewHosts and notifiers only send a summary. InventoryErrors lists the scopes that
+// could not be inventoried, whose hosts were not scanned. OutsideInventory lists the servers DNS records point at that
+// no provider inventoried, sorted by address. They are listed alongside the rest of the report but are not a reason
+// to notify on their own.
+type Report struct {
+	Servers          map[string]server.Server
+	Diff             ScanDiff
+	Baseline         bool
+	InventoryErrors  []InventoryError
+	OutsideInventory []server.Server
+}
+
+// AllScopes is the scope of the inventory errors of providers nothing could be inventoried from.
+const AllScopes = "all"
+
+// InventoryError is a scope of a provider, such as an AWS region or a GCloud zone, that could not be inventoried.
+// Scope is AllScopes when nothing could be inventoried from the provider.
+type InventoryError struct {
+	Provider string `json:"provider"`
+	Scope    string `json:"scope"`
+	Message  string `json:"message"`
+}
+
+func (e InventoryError) String() string {
+	return e.Provider + " " + e.Scope + ": " + e.Message
+}
+
+// Empty reports whether there is nothing to notify: nothing changed, no baseline was established and the inventory was
+// complete.
+func (r Report) Empty() bool {
+	return r.Diff.Empty() && !r.Baseline && len(r.InventoryErrors) == 0
+}
+
+// BaselineSummary describes the scan a baseline report was established with.
+func (r Report) BaselineSummary() string {
+	openPorts := 0
+	for _, ports := range r.Diff.NewHosts {
+		openPorts += len(ports.OpenPorts())
+	}
+	return "Baseline established with " + strconv.Itoa(len(r.Diff.NewHosts)) + " hosts and " +
+		strconv.Itoa(openPorts) + " open ports"
+}
+
+// NewHosts returns the hosts that appeared since the previous scan with OpenedPorts filled in.
+func (r Report) NewHosts() []server.Server {
+	var hosts []server.Server
+	for _, address := range sortedPortMapHosts(r.Diff.NewHosts) {
+		host := r.host(address)
+		host.OpenedPorts = portsToSlice(r.Diff.NewHosts[address])
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// OpenedPorts returns the known hosts that opened ports with OpenedPorts filled in.
+func (r Report) OpenedPorts() []server.Server {
+	var hosts []server.Server
+	for _, address := range sortedPortMapHosts(r.Diff.OpenedPorts) {
+		host := r.host(address)
+		host.OpenedPorts = portsToSlice(r.Diff.OpenedPorts[address])
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// ClosedPorts returns the known hosts that closed ports with ClosedPorts filled in.
+func (r Report) ClosedPorts() []server.Server {
+	var hosts []server.Server
+	for _, address := range sortedPortMapHosts(r.Diff.ClosedPorts) {
+		host := r.host(address)
+		host.ClosedPorts = portsToSlice(r.Diff.ClosedPorts[address])
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// RemovedHosts returns the hosts that vanished since the previous scan with ClosedPorts filled in. An address that now
+// belongs to another resource is returned as a server only containing the address.
+func (r Report) RemovedHosts() []server.Server {
+	var hosts []server.Server
+	for _, address := range sortedPortMapHosts(r.Diff.RemovedHosts) {
+		host := r.host(address)
+		if _, ok := r.Diff.ReassignedAddresses[address]; ok {
+			host = server.Server{Name: address, Address: address}
+		}
+		host.ClosedPorts = portsToSlice(r.Diff.RemovedHosts[address])
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// ChangedServices returns the hosts with services that changed with ChangedServices filled in.
+func (r Report) ChangedServices() []server.Server {
+	addresses := make([]string, 0, len(r.Diff.ChangedServices))
+	for address, changes := range r.Diff.ChangedServices {
+		if len(changes) > 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	var hosts []server.Server
+	for _, address := range addresses {
+		host := r.host(address)
+		host.ChangedServices = r.Diff.ChangedServices[address]
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// StateTransitions returns the hosts with ports that changed state with StateTransitions filled in.
+func (r Report) StateTransitions() []server.Server {
+	addresses := make([]string, 0, len(r.Diff.StateTransitions))
+	for address, transitions := range r.Diff.StateTransitions {
+		if len(transitions) > 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	var hosts []server.Server
+	for _, address := range addresses {
+		host := r.host(address)
+		host.StateTransitions = r.Diff.StateTransitions[address]
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// AddressChanges returns the hosts found at another address on the previous scan with PreviousAddress filled in.
+func (r Report) AddressChanges() []server.Server {
+	addresses := make([]string, 0, len(r.Diff.AddressChanges))
+	for address := range r.Diff.AddressChanges {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var hosts []server.Server
+	for _, address := range addresses {
+		host := r.host(address)
+		host.PreviousAddress = r.Diff.AddressChanges[address].Previous
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// ReassignedAddresses returns the hosts whose address belonged to another resource on the previous scan with
+// PreviousIdentity filled in.
+func (r Report) ReassignedAddresses() []server.Server {
+	addresses := make([]string, 0, len(r.Diff.ReassignedAddresses))
+	for address := range r.Diff.ReassignedAddresses {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var hosts []server.Server
+	for _, address := range addresses {
+		host := r.host(address)
+		host.PreviousIdentity = r.Diff.ReassignedAddresses[address].Previous
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// host returns the server found at the address during inventory. Hosts that vanished are usually no longer part of
+// the inventory, in which case a server only containing the address is returned.
+func (r Report) host(address string) server.Server {
+	host, ok := r.Servers[address]
+	if !ok {
+		host = server.Server{Name: address, Address: address}
+	}
+	return host
+}
+
+// sortedPortMapHosts returns the addresses with at least one port in sorted order, so notifications are sent in a
+// stable order between runs.
+func sortedPortMapHosts(hostsMap map[string]PortMap) []string {
+	hosts := make([]string, 0, len(hostsMap))
+	for host, portsMap := range hostsMap {
+		if len(portsMap) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func portsToSlice(portsMap PortMap) []server.Port {
+	portsSlice := make([]server.Port, 0, len(portsMap))
+	for port, _ := range portsMap {
+		if port.ID != 0 {
+			portsSlice = append(portsSlice, port)
+		}
+	}
+	sort.Slice(portsSlice, func(i, j int) bool { return portsSlice[i].Less(portsSlice[j]) })
+	return portsSlice
+}
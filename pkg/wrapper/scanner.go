@@ -3,18 +3,72 @@ package wrapper
 import (
 	"context"
 
+	"github.com/Invoca/nmap-diff/pkg/server"
 	"github.com/Ullaakut/nmap"
 )
 
 type NmapClientWrapper interface {
-	Run([]string, context.Context) (*nmap.Run, []string, error)
+	// Run scans the addresses. Ports, when set, is an nmap port list scanned instead of the ports of the scan profile.
+	Run(ipAddresses []string, ports string, ctx context.Context) (*nmap.Run, []string, error)
 }
 
 type NmapSvc interface {
 	CurrentScanResults() ([]byte, error)
 	ParsePreviousScan([]byte) error
-	StartScan(ipAddresses []string) error
-	DiffScans() map[string]PortMap
+	// StartScan scans the addresses and records the identity, provider and scopes of the server of every host, keyed by
+	// address, in the current scan. Servers with ExtraPorts are also scanned for those ports.
+	StartScan(ipAddresses []string, servers map[string]server.Server) error
+	// CarryForward copies the hosts of the previous scan that belong to a scope of inventoryErrors and are not part of
+	// ipAddresses into the current scan.
+	CarryForward(ipAddresses []string, inventoryErrors []InventoryError) error
+	DiffScans() ScanDiff
 }
 
-type PortMap map[uint16]bool
+// PortMap holds the ports nmap listed for a host along with their state and the service detected on each of them.
+type PortMap map[server.Port]server.PortState
+
+// ScanDiff holds every change found between the previous scan and the current scan. Each map is keyed by the address
+// of the host on the current scan, except RemovedHosts which is keyed by its address on the previous scan. The ports of
+// a host listed in AddressChanges are diffed against its previous address. An address listed in ReassignedAddresses
+// belonged to another resource on the previous scan, which is listed in RemovedHosts unless it was found elsewhere.
+type ScanDiff struct {
+	OpenedPorts         map[string]PortMap
+	ClosedPorts         map[string]PortMap
+	NewHosts            map[string]PortMap
+	RemovedHosts        map[string]PortMap
+	ChangedServices     map[string][]server.ServiceChange
+	StateTransitions    map[string][]server.StateTransition
+	AddressChanges      map[string]server.AddressChange
+	ReassignedAddresses map[string]server.Reassignment
+}
+
+func NewScanDiff() ScanDiff {
+	return ScanDiff{
+		OpenedPorts:         make(map[string]PortMap),
+		ClosedPorts:         make(map[string]PortMap),
+		NewHosts:            make(map[string]PortMap),
+		RemovedHosts:        make(map[string]PortMap),
+		ChangedServices:     make(map[string][]server.ServiceChange),
+		StateTransitions:    make(map[string][]server.StateTransition),
+		AddressChanges:      make(map[string]server.AddressChange),
+		ReassignedAddresses: make(map[string]server.Reassignment),
+	}
+}
+
+// Empty returns true when no changes were found between the two scans.
+func (d ScanDiff) Empty() bool {
+	return len(d.OpenedPorts) == 0 && len(d.ClosedPorts) == 0 && len(d.NewHosts) == 0 && len(d.RemovedHosts) == 0 &&
+		len(d.ChangedServices) == 0 && len(d.StateTransitions) == 0 && len(d.AddressChanges) == 0 &&
+		len(d.ReassignedAddresses) == 0
+}
+
+// OpenPorts returns only the ports of the map that nmap found open.
+func (m PortMap) OpenPorts() PortMap {
+	openPorts := make(PortMap)
+	for port, portState := range m {
+		if portState.Open() {
+			openPorts[port] = portState
+		}
+	}
+	return openPorts
+}
@@ -0,0 +1,25 @@
+package wrapper
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned, possibly wrapped, by ScanStore.Load when nothing is stored under the key. It tells a missing
+// baseline on the first run apart from storage errors.
+var ErrNotFound = errors.New("not found")
+
+// ScanStore persists the results of scans so the next run has a baseline to diff against.
+type ScanStore interface {
+	Load(key string) ([]byte, error)
+	Save(key string, data []byte) error
+	Delete(key string) error
+}
+
+// ScanHistory keeps every scan so runs can be diffed against any earlier scan.
+type ScanHistory interface {
+	// Load returns the scan with the given ID, or the latest scan taken at or before the given RFC 3339 time.
+	Load(ref string) ([]byte, error)
+	// Record stores a scan taken at the given time and applies the retention rules.
+	Record(data []byte, takenAt time.Time) error
+}
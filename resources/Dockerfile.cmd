@@ -1,4 +1,4 @@
-FROM golang:1.14.5 as build
+FROM golang:1.20 as build
 ENV CGO_ENABLED=0
 ENV GOPATH=/go
 
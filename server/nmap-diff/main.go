@@ -11,17 +11,94 @@ import (
 )
 
 type Config struct {
-	IncludeAWS         bool   `json:"includeAWS"`
-	BucketName         string `json:"bucketName"`
-	PreviousFileName   string `json:"previousFileName"`
-	IncludeGCloud      bool   `json:"includeGCloud"`
-	ServiceAccountPath string `json:"serviceAccountPath"`
-	SlackURL           string `json:"slackURL"`
-	ProjectName        string `json:"projectName"`
+	IncludeAWS           bool                 `json:"includeAWS"`
+	AWSResources         []string             `json:"awsResources"`
+	AWSAccounts          []*config.AWSAccount `json:"awsAccounts"`
+	AWSRegions           []string             `json:"awsRegions"`
+	AWSExcludeRegions    []string             `json:"awsExcludeRegions"`
+	BucketName           string               `json:"bucketName"`
+	PreviousFileName     string               `json:"previousFileName"`
+	StorageType          string               `json:"storageType"`
+	StorageBucket        string               `json:"storageBucket"`
+	StorageDirectory     string               `json:"storageDirectory"`
+	IncludeGCloud        bool                 `json:"includeGCloud"`
+	ServiceAccountPath   string               `json:"serviceAccountPath"`
+	GCloudResources      []string             `json:"gcloudResources"`
+	GCloudProjects       []string             `json:"gcloudProjects"`
+	GCloudParent         string               `json:"gcloudParent"`
+	TerraformStates      []string             `json:"terraformStates"`
+	IncludeAzure         bool                 `json:"includeAzure"`
+	AzureTenantID        string               `json:"azureTenantId"`
+	AzureClientID        string               `json:"azureClientId"`
+	AzureClientSecret    string               `json:"azureClientSecret"`
+	AzureSubscriptions   []string             `json:"azureSubscriptions"`
+	TargetsFiles         []string             `json:"targetsFiles"`
+	IncludeKubernetes    bool                 `json:"includeKubernetes"`
+	Kubeconfig           string               `json:"kubeconfig"`
+	KubeContexts         []string             `json:"kubeContexts"`
+	DNSZoneFiles         []string             `json:"dnsZoneFiles"`
+	Route53Zones         []string             `json:"route53Zones"`
+	CloudDNSZones        []string             `json:"cloudDNSZones"`
+	InventoryWorkers     int                  `json:"inventoryWorkers"`
+	InventoryErrorPolicy string               `json:"inventoryErrorPolicy"`
+	SlackURL             string               `json:"slackURL"`
+	SlackDigest          bool                 `json:"slackDigest"`
+	SlackGroupBy         string               `json:"slackGroupBy"`
+	SlackToken           string               `json:"slackToken"`
+	SlackChannel         string               `json:"slackChannel"`
+	ProjectName          string               `json:"projectName"`
+	ScanProfile          string               `json:"scanProfile"`
+	NotifyTransitions    []string             `json:"notifyTransitions"`
+	Notifiers            []NotifierConfig     `json:"notifiers"`
+	History              *HistoryConfig       `json:"history"`
+	BaselineNotification string               `json:"baselineNotification"`
+}
+
+// HistoryConfig keeps every scan in storage when set. CompareTo selects the scan to diff against by ID or time.
+type HistoryConfig struct {
+	Prefix    string `json:"prefix"`
+	KeepScans int    `json:"keepScans"`
+	KeepDays  int    `json:"keepDays"`
+	CompareTo string `json:"compareTo"`
+}
+
+// NotifierConfig registers an additional notifier. Type is one of slack, webhook or log.
+type NotifierConfig struct {
+	Type     string `json:"type"`
+	SlackURL string `json:"slackURL"`
+	URL      string `json:"url"`
+}
+
+// redactedValue replaces the secrets of a config before it is logged.
+const redactedValue = "REDACTED"
+
+// redacted returns a copy of the config that is safe to log, with every credential replaced. Slack incoming webhook
+// URLs and the URLs of webhook notifiers can embed their own secret, so they are redacted along with the bot token.
+func (c Config) redacted() Config {
+	for _, secret := range []*string{&c.AzureClientSecret, &c.SlackToken, &c.SlackURL} {
+		if *secret != "" {
+			*secret = redactedValue
+		}
+	}
+
+	notifiers := make([]NotifierConfig, len(c.Notifiers))
+	for index, n := range c.Notifiers {
+		for _, secret := range []*string{&n.SlackURL, &n.URL} {
+			if *secret != "" {
+				*secret = redactedValue
+			}
+		}
+		notifiers[index] = n
+	}
+	c.Notifiers = notifiers
+	return c
 }
 
 type server struct {
 	runner wrapper.Runner
+	// scanProfiles holds the custom scan profiles requests can select by name. They are only read from the file in
+	// SCAN_PROFILES_FILE, since their extra arguments are passed to nmap as they are.
+	scanProfiles map[string]*config.ScanProfile
 }
 
 func main() {
@@ -29,6 +106,14 @@ func main() {
 
 	s := server{runner: &runner.Runner{}}
 
+	if path := os.Getenv("SCAN_PROFILES_FILE"); path != "" {
+		var err error
+		s.scanProfiles, err = config.LoadScanProfiles(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	log.Debug("starting server...")
 	http.HandleFunc("/", s.scanHandler)
 
@@ -57,26 +142,98 @@ func (s *server) scanHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Debug(c)
+	log.Debug(c.redacted())
 
 	gCloudConfig := config.GCloudConfig{
 		ServiceAccountPath: c.ServiceAccountPath,
 		ProjectName:        c.ProjectName,
+		Projects:           c.GCloudProjects,
+		Parent:             c.GCloudParent,
+		Resources:          c.GCloudResources,
+	}
+
+	azureConfig := config.AzureConfig{
+		TenantID:      c.AzureTenantID,
+		ClientID:      c.AzureClientID,
+		ClientSecret:  c.AzureClientSecret,
+		Subscriptions: c.AzureSubscriptions,
 	}
 
 	slackConfig := config.SlackConfig{
 		SlackURL: c.SlackURL,
+		Digest:   c.SlackDigest,
+		GroupBy:  c.SlackGroupBy,
+		Token:    c.SlackToken,
+		Channel:  c.SlackChannel,
+	}
+
+	// Without a storageType, reports are stored in the S3 bucket in bucketName.
+	var storageConfig *config.StorageConfig
+	if c.StorageType != "" {
+		storageConfig = &config.StorageConfig{
+			Type:               c.StorageType,
+			Bucket:             c.StorageBucket,
+			Directory:          c.StorageDirectory,
+			ServiceAccountPath: c.ServiceAccountPath,
+		}
+	}
+
+	var historyConfig *config.HistoryConfig
+	if c.History != nil {
+		historyConfig = &config.HistoryConfig{
+			Prefix:    c.History.Prefix,
+			KeepScans: c.History.KeepScans,
+			KeepDays:  c.History.KeepDays,
+			CompareTo: c.History.CompareTo,
+		}
+	}
+
+	awsConfig := config.AWSConfig{
+		Resources:      c.AWSResources,
+		Accounts:       c.AWSAccounts,
+		Regions:        c.AWSRegions,
+		ExcludeRegions: c.AWSExcludeRegions,
+	}
+
+	dnsConfig := config.DNSConfig{
+		ZoneFiles:     c.DNSZoneFiles,
+		Route53Zones:  c.Route53Zones,
+		CloudDNSZones: c.CloudDNSZones,
+	}
+
+	var notifiers []*config.NotifierConfig
+	for _, n := range c.Notifiers {
+		notifiers = append(notifiers, &config.NotifierConfig{
+			Type:          n.Type,
+			SlackConfig:   &config.SlackConfig{SlackURL: n.SlackURL},
+			WebhookConfig: &config.WebhookConfig{URL: n.URL},
+		})
 	}
 
 	configObject := config.BaseConfig{
-		IncludeAWS:       c.IncludeAWS,
-		BucketName:       c.BucketName,
-		PreviousFileName: c.PreviousFileName,
-		IncludeGCloud:    c.IncludeGCloud,
-		GCloudConfig:     &gCloudConfig,
-		SlackConfig:      &slackConfig,
-	}
-	log.Debug(configObject)
+		IncludeAWS:           c.IncludeAWS,
+		AWSConfig:            &awsConfig,
+		BucketName:           c.BucketName,
+		PreviousFileName:     c.PreviousFileName,
+		StorageConfig:        storageConfig,
+		HistoryConfig:        historyConfig,
+		IncludeGCloud:        c.IncludeGCloud,
+		GCloudConfig:         &gCloudConfig,
+		TerraformConfig:      &config.TerraformConfig{StateFiles: c.TerraformStates},
+		IncludeAzure:         c.IncludeAzure,
+		AzureConfig:          &azureConfig,
+		IncludeKubernetes:    c.IncludeKubernetes,
+		KubernetesConfig:     &config.KubernetesConfig{Kubeconfig: c.Kubeconfig, Contexts: c.KubeContexts},
+		StaticConfig:         &config.StaticConfig{Files: c.TargetsFiles},
+		DNSConfig:            &dnsConfig,
+		InventoryWorkers:     c.InventoryWorkers,
+		InventoryErrorPolicy: c.InventoryErrorPolicy,
+		SlackConfig:          &slackConfig,
+		ScanProfile:          c.ScanProfile,
+		ScanProfiles:         s.scanProfiles,
+		NotifyTransitions:    c.NotifyTransitions,
+		BaselineNotification: c.BaselineNotification,
+	}
 
 	//TODO: Determine if it is worth returning instantly and not keeping the connection open until the scan finishes
 	err = s.runner.Execute(configObject)